@@ -3,6 +3,7 @@ module github.com/cswank/geq/controller
 go 1.24.1
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/alecthomas/kingpin/v2 v2.4.0
 	github.com/cswank/tmc2209 v0.0.0-00010101000000-000000000000
 	github.com/parsyl/sqrl v0.3.0
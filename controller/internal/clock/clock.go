@@ -0,0 +1,106 @@
+// Package clock is the time the mount goes by: the system clock, put
+// right by an offset learned from the gps, the browser or the user, that
+// can also be frozen or run faster than real time to plan or test.  The
+// system clock itself is never changed, so timers aren't disturbed.
+package clock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	Clock struct {
+		lock sync.Mutex
+		// base is what the clock read when the system clock read sys
+		// (which keeps its monotonic reading, so setting the system
+		// clock doesn't move this one).  Since then it's run at rate.
+		base   time.Time
+		sys    time.Time
+		rate   float64
+		source string
+	}
+
+	// Status is the clock's time, how far it's ahead of the system
+	// clock in seconds, how fast it runs and what it was last set from.
+	Status struct {
+		Now    time.Time `json:"now"`
+		Offset float64   `json:"offset"`
+		Rate   float64   `json:"rate"`
+		Source string    `json:"source"`
+	}
+)
+
+// New returns a clock that reads the same as the system clock.
+func New() *Clock {
+	c := &Clock{}
+	c.Reset()
+	return c
+}
+
+// Now returns the clock's time.
+func (c *Clock) Now() time.Time {
+	return c.At(time.Now())
+}
+
+// At returns what the clock reads when the system clock reads t.
+func (c *Clock) At(t time.Time) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.at(t)
+}
+
+func (c *Clock) at(t time.Time) time.Time {
+	d := time.Duration(float64(t.Sub(c.sys)) * c.rate)
+	return c.base.Add(d).Round(0) // a monotonic reading would hide the offset
+}
+
+// Set makes the clock read t now; source is what t came from (gps,
+// browser, user...).
+func (c *Clock) Set(t time.Time, source string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.base = t.Round(0)
+	c.sys = time.Now()
+	c.source = source
+}
+
+// SetRate makes the clock run at rate times real time from now on: 0
+// freezes it and 1 is normal.
+func (c *Clock) SetRate(rate float64) error {
+	if rate < 0 {
+		return fmt.Errorf("the clock can't run backwards (rate %g)", rate)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	c.base = c.at(now)
+	c.sys = now
+	c.rate = rate
+	return nil
+}
+
+// Reset puts the clock back to the system clock running at real time.
+func (c *Clock) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sys = time.Now()
+	c.base = c.sys.Round(0)
+	c.rate = 1
+	c.source = "system"
+}
+
+func (c *Clock) Status() Status {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	at := c.at(now)
+	return Status{
+		Now:    at,
+		Offset: at.Sub(now.Round(0)).Seconds(),
+		Rate:   c.rate,
+		Source: c.source,
+	}
+}
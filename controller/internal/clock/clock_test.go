@@ -0,0 +1,81 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAt(t *testing.T) {
+	c := New()
+	now := time.Now()
+	if d := c.At(now).Sub(now); d.Abs() > time.Millisecond {
+		t.Errorf("a new clock is %s off the system clock", d)
+	}
+
+	if st := c.Status(); st.Rate != 1 || st.Source != "system" {
+		t.Errorf("a new clock has status %+v", st)
+	}
+}
+
+func TestSet(t *testing.T) {
+	c := New()
+	want := time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+	c.Set(want, "gps")
+
+	// an hour later by the system clock the clock has moved an hour too
+	if got := c.At(time.Now().Add(time.Hour)); got.Sub(want.Add(time.Hour)).Abs() > time.Second {
+		t.Errorf("an hour after being set to %s the clock reads %s", want, got)
+	}
+
+	if st := c.Status(); st.Source != "gps" || st.Offset > -1 || st.Rate != 1 {
+		t.Errorf("a clock set in the past has status %+v", st)
+	}
+}
+
+func TestSetRate(t *testing.T) {
+	c := New()
+	want := time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+	c.Set(want, "user")
+
+	if err := c.SetRate(-1); err == nil {
+		t.Error("setting a negative rate didn't return an error")
+	}
+
+	if err := c.SetRate(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// frozen, it reads the same whenever it's asked
+	if got := c.At(time.Now().Add(time.Hour)); got.Sub(want).Abs() > time.Second {
+		t.Errorf("a frozen clock set to %s reads %s an hour later", want, got)
+	}
+
+	// the offset it was set to is kept when the rate changes, and from
+	// then on it runs ten times faster than the system clock
+	if err := c.SetRate(10); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.At(time.Now().Add(time.Minute)); got.Sub(want.Add(10*time.Minute)).Abs() > time.Second {
+		t.Errorf("a minute at 10x after %s the clock reads %s", want, got)
+	}
+}
+
+func TestReset(t *testing.T) {
+	c := New()
+	c.Set(time.Now().Add(-24*time.Hour), "browser")
+	if err := c.SetRate(3); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Reset()
+
+	now := time.Now()
+	if d := c.At(now).Sub(now); d.Abs() > time.Millisecond {
+		t.Errorf("a reset clock is %s off the system clock", d)
+	}
+
+	if st := c.Status(); st.Rate != 1 || st.Source != "system" {
+		t.Errorf("a reset clock has status %+v", st)
+	}
+}
@@ -0,0 +1,462 @@
+// Package config is the mount's configuration file: the mechanics and
+// electronics of the mount, the observing site and the http server.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+type (
+	Config struct {
+		Site      Site      `toml:"site"`
+		Observer  Observer  `toml:"observer"`
+		Telescope Telescope `toml:"telescope"`
+		Mount     Mount     `toml:"mount"`
+		Gamepad   Gamepad   `toml:"gamepad"`
+		Handset   Handset   `toml:"handset"`
+		GPS       GPS       `toml:"gps"`
+		Server    Server    `toml:"server"`
+
+		// path is the file the config was loaded from.
+		path string
+	}
+
+	// Site is where the mount is set up, in degrees (east longitude is
+	// positive), and its elevation in meters above sea level.
+	Site struct {
+		Latitude  float64 `toml:"latitude"`
+		Longitude float64 `toml:"longitude"`
+		Elevation float64 `toml:"elevation"`
+	}
+
+	// Observer is who's logging the observations.
+	Observer struct {
+		Name string `toml:"name"`
+	}
+
+	// Telescope is what's on the mount.  Aperture, in millimeters, is
+	// how faint it can see.  Name and FocalLength, in millimeters (0 if
+	// it isn't known), are for the logbook.
+	Telescope struct {
+		Name        string  `toml:"name"`
+		Aperture    float64 `toml:"aperture"`
+		FocalLength float64 `toml:"focal_length"`
+	}
+
+	Mount struct {
+		// Serial is the device shared by the tmc2209s and the counting
+		// mcu.  If it's empty the motors and mcu are simulated.
+		Serial   string `toml:"serial"`
+		Baud     int    `toml:"baud"`
+		GPIOChip string `toml:"gpio_chip"`
+
+		// Collisions stops both axes when StallGuard sees a load spike
+		// during a slew.
+		Collisions bool `toml:"collisions"`
+
+		// PECFile is where the recorded periodic error of the ra worm is
+		// kept; empty means it's forgotten on restart.
+		PECFile string `toml:"pec_file"`
+
+		RA  Axis `toml:"ra"`
+		Dec Axis `toml:"dec"`
+	}
+
+	Axis struct {
+		// GearRatio is motor revolutions per axis revolution and
+		// MotorSteps is full steps per motor revolution.
+		GearRatio  float64 `toml:"gear_ratio"`
+		MotorSteps int     `toml:"motor_steps"`
+
+		// SlewSpeed and SlowSpeed are motor revolutions per minute.
+		SlewSpeed float64 `toml:"slew_speed"`
+		SlowSpeed float64 `toml:"slow_speed"`
+
+		// Address is the tmc2209's uart address.
+		Address uint8 `toml:"address"`
+
+		// IndexPin is the gpio line the firmware toggles to start, slow
+		// and stop the axis.
+		IndexPin int `toml:"index_pin"`
+
+		// HomePin is an optional gpio line (a home switch or the
+		// driver's DIAG output) that goes low at the axis' stop; -1 if
+		// there isn't one.  HomeDirection is which way (1 or -1) to
+		// drive to reach the stop.
+		HomePin       int     `toml:"home_pin"`
+		HomeDirection float64 `toml:"home_direction"`
+
+		// WormPeriod is motor revolutions per revolution of the worm,
+		// the period of the periodic error.
+		WormPeriod float64 `toml:"worm_period"`
+
+		// Backlash is the slack, in index pulses (two full steps each),
+		// taken up when the axis reverses.  Approach is the direction (1
+		// or -1) every goto finishes in, or 0 to finish in whichever
+		// direction the goto moves.
+		Backlash uint16  `toml:"backlash"`
+		Approach float64 `toml:"approach"`
+	}
+
+	// Gamepad is a usb gamepad or joystick read through evdev.  Axes
+	// and buttons are named as in linux/input-event-codes.h (ABS_X,
+	// BTN_SOUTH and so on).
+	Gamepad struct {
+		// Device is a glob of the evdev devices to read.  It's checked
+		// every few seconds, so pads can be plugged in and out.  Empty
+		// turns gamepads off.
+		Device string `toml:"device"`
+
+		// RA and Dec are the sticks that move each axis, at a rate
+		// proportional to how far they're pushed up to the speed preset
+		// chosen with the buttons.  Deadzone is the fraction of a
+		// stick's travel either side of center that's ignored.
+		RA       Stick   `toml:"ra"`
+		Dec      Stick   `toml:"dec"`
+		Deadzone float64 `toml:"deadzone"`
+
+		// Buttons maps actions (stop, park, next, or one of the speed
+		// presets guide, center, find and max) to the buttons that do
+		// them; an empty button leaves the action unmapped.
+		Buttons map[string]string `toml:"buttons"`
+	}
+
+	Stick struct {
+		Axis   string `toml:"axis"`
+		Invert bool   `toml:"invert"`
+	}
+
+	// Handset is a hand controller of push buttons, each wired from a
+	// gpio line (on mount.gpio_chip) to ground.
+	Handset struct {
+		// Pins maps actions to the lines of the buttons that do them:
+		// north, south, east and west move the axes while they're held,
+		// guide, center, find and max pick the speed they move at and
+		// speed steps through those presets.  -1 leaves an action
+		// unmapped.
+		Pins map[string]int `toml:"pins"`
+
+		// Debounce is how long, in milliseconds, a line has to be steady
+		// before a press or release counts.
+		Debounce int `toml:"debounce"`
+	}
+
+	// GPS is an nmea receiver, on a serial port or behind gpsd, that
+	// sets the site and the clock.  With neither Serial nor Gpsd there's
+	// no gps.
+	GPS struct {
+		Serial string `toml:"serial"`
+		Baud   int    `toml:"baud"`
+
+		// Gpsd is the address (host:port) of a gpsd to read instead.
+		Gpsd string `toml:"gpsd"`
+	}
+
+	Server struct {
+		Addr string `toml:"addr"`
+
+		// UserDB is the sqlite database the observing lists and the
+		// logbook, which the programs' progress comes from, are kept in;
+		// empty means they're forgotten on restart.
+		UserDB string `toml:"user_db"`
+	}
+)
+
+// Default is the configuration of the original mount.
+func Default() Config {
+	return Config{
+		Telescope: Telescope{
+			Aperture: 100,
+		},
+		Mount: Mount{
+			Baud:     115200,
+			GPIOChip: "gpiochip0",
+			PECFile:  "pec.json",
+			RA: Axis{
+				GearRatio:     100,
+				MotorSteps:    200,
+				SlewSpeed:     5,
+				SlowSpeed:     1,
+				Address:       0,
+				IndexPin:      23,
+				HomePin:       -1,
+				HomeDirection: -1,
+				WormPeriod:    1,
+			},
+			Dec: Axis{
+				GearRatio:     136.0 / 16.0,
+				MotorSteps:    200,
+				SlewSpeed:     5,
+				SlowSpeed:     1,
+				Address:       1,
+				IndexPin:      24,
+				HomePin:       -1,
+				HomeDirection: 1,
+				WormPeriod:    1,
+			},
+		},
+		Gamepad: Gamepad{
+			Device:   "/dev/input/by-id/*-event-joystick",
+			RA:       Stick{Axis: "ABS_X"},
+			Dec:      Stick{Axis: "ABS_Y", Invert: true},
+			Deadzone: 0.15,
+			Buttons: map[string]string{
+				"stop":   "BTN_SOUTH",
+				"guide":  "BTN_WEST",
+				"center": "BTN_NORTH",
+				"find":   "BTN_EAST",
+				"max":    "BTN_TR",
+				"park":   "BTN_SELECT",
+				"next":   "BTN_START",
+			},
+		},
+		Handset: Handset{
+			Debounce: 20,
+		},
+		GPS: GPS{
+			Baud: 9600,
+		},
+		Server: Server{
+			Addr:   ":3434",
+			UserDB: "user.db",
+		},
+	}
+}
+
+// Load reads the file at pth on top of the defaults.  Keys the file
+// doesn't set keep their default values and keys that aren't part of the
+// configuration are an error, so typos don't go unnoticed.  An empty pth
+// returns the defaults.
+func Load(pth string) (Config, error) {
+	cfg := Default()
+	if pth == "" {
+		return cfg, nil
+	}
+	cfg.path = pth
+
+	md, err := toml.DecodeFile(pth, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("unable to read config %s: %w", pth, err)
+	}
+
+	if keys := md.Undecoded(); len(keys) > 0 {
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			names[i] = k.String()
+		}
+		return cfg, fmt.Errorf("unknown keys in config %s: %s", pth, strings.Join(names, ", "))
+	}
+
+	return cfg, nil
+}
+
+// Update changes the file the config was loaded from with f.  The file
+// is read again first so that command line flags don't end up in it.
+// Comments in the file are lost.
+func (c Config) Update(f func(*Config)) error {
+	if c.path == "" {
+		return errors.New("there's no config file to save to (see --config)")
+	}
+
+	cfg, err := Load(c.path)
+	if err != nil {
+		return err
+	}
+
+	f(&cfg)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := toml.NewEncoder(out).Encode(cfg); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// Validate returns every problem with cfg, not just the first.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.Site.Latitude < -90 || c.Site.Latitude > 90 {
+		errs = append(errs, fmt.Errorf("site.latitude must be between -90 and 90, got %g", c.Site.Latitude))
+	}
+
+	if c.Site.Longitude < -180 || c.Site.Longitude > 180 {
+		errs = append(errs, fmt.Errorf("site.longitude must be between -180 and 180, got %g", c.Site.Longitude))
+	}
+
+	if c.Telescope.Aperture <= 0 {
+		errs = append(errs, fmt.Errorf("telescope.aperture must be positive, got %g", c.Telescope.Aperture))
+	}
+
+	if c.Telescope.FocalLength < 0 {
+		errs = append(errs, fmt.Errorf("telescope.focal_length can't be negative, got %g", c.Telescope.FocalLength))
+	}
+
+	if c.Mount.Serial != "" {
+		if c.Mount.Baud <= 0 {
+			errs = append(errs, fmt.Errorf("mount.baud must be positive, got %d", c.Mount.Baud))
+		}
+
+		if c.Mount.GPIOChip == "" {
+			errs = append(errs, errors.New("mount.gpio_chip is required with a serial device"))
+		}
+
+		if c.Mount.RA.Address == c.Mount.Dec.Address {
+			errs = append(errs, fmt.Errorf("mount.ra.address and mount.dec.address must differ, both are %d", c.Mount.RA.Address))
+		}
+
+		if c.Mount.RA.IndexPin == c.Mount.Dec.IndexPin {
+			errs = append(errs, fmt.Errorf("mount.ra.index_pin and mount.dec.index_pin must differ, both are %d", c.Mount.RA.IndexPin))
+		}
+	}
+
+	errs = append(errs, c.Mount.RA.validate("mount.ra")...)
+	errs = append(errs, c.Mount.Dec.validate("mount.dec")...)
+
+	if c.Gamepad.Deadzone < 0 || c.Gamepad.Deadzone >= 1 {
+		errs = append(errs, fmt.Errorf("gamepad.deadzone must be at least 0 and less than 1, got %g", c.Gamepad.Deadzone))
+	}
+
+	errs = append(errs, c.Handset.validate(c.Mount)...)
+
+	if c.GPS.Serial != "" && c.GPS.Gpsd != "" {
+		errs = append(errs, errors.New("gps.serial and gps.gpsd can't both be set"))
+	}
+
+	if c.GPS.Serial != "" && c.GPS.Baud <= 0 {
+		errs = append(errs, fmt.Errorf("gps.baud must be positive, got %d", c.GPS.Baud))
+	}
+
+	if c.GPS.Serial != "" && c.GPS.Serial == c.Mount.Serial {
+		errs = append(errs, fmt.Errorf("gps.serial and mount.serial must differ, both are %s", c.GPS.Serial))
+	}
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (a Axis) validate(name string) []error {
+	var errs []error
+
+	if a.GearRatio <= 0 {
+		errs = append(errs, fmt.Errorf("%s.gear_ratio must be positive, got %g", name, a.GearRatio))
+	}
+
+	if a.MotorSteps <= 0 {
+		errs = append(errs, fmt.Errorf("%s.motor_steps must be positive, got %d", name, a.MotorSteps))
+	}
+
+	if a.SlewSpeed <= 0 || a.SlowSpeed <= 0 {
+		errs = append(errs, fmt.Errorf("%s.slew_speed and %s.slow_speed must be positive, got %g and %g", name, name, a.SlewSpeed, a.SlowSpeed))
+	} else if a.SlowSpeed > a.SlewSpeed {
+		errs = append(errs, fmt.Errorf("%s.slow_speed (%g) can't be faster than %s.slew_speed (%g)", name, a.SlowSpeed, name, a.SlewSpeed))
+	}
+
+	if a.Address > 3 {
+		errs = append(errs, fmt.Errorf("%s.address must be 0-3 (set by the MS1/MS2 pins), got %d", name, a.Address))
+	}
+
+	if a.IndexPin < 0 {
+		errs = append(errs, fmt.Errorf("%s.index_pin must not be negative, got %d", name, a.IndexPin))
+	}
+
+	if a.HomePin < -1 {
+		errs = append(errs, fmt.Errorf("%s.home_pin must be a gpio line or -1 for none, got %d", name, a.HomePin))
+	}
+
+	if a.WormPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("%s.worm_period must be positive, got %g", name, a.WormPeriod))
+	}
+
+	if a.HomeDirection != 1 && a.HomeDirection != -1 {
+		errs = append(errs, fmt.Errorf("%s.home_direction must be 1 or -1, got %g", name, a.HomeDirection))
+	}
+
+	if a.Approach != 0 && a.Approach != 1 && a.Approach != -1 {
+		errs = append(errs, fmt.Errorf("%s.approach must be 1, -1 or 0 for either, got %g", name, a.Approach))
+	}
+
+	return errs
+}
+
+// handsetActions are what handset buttons can do.
+var handsetActions = map[string]bool{
+	"north":  true,
+	"south":  true,
+	"east":   true,
+	"west":   true,
+	"guide":  true,
+	"center": true,
+	"find":   true,
+	"max":    true,
+	"speed":  true,
+}
+
+func (h Handset) validate(m Mount) []error {
+	var errs []error
+
+	if h.Debounce < 0 {
+		errs = append(errs, fmt.Errorf("handset.debounce must not be negative, got %d", h.Debounce))
+	}
+
+	used := map[int]string{
+		m.RA.IndexPin:  "mount.ra.index_pin",
+		m.Dec.IndexPin: "mount.dec.index_pin",
+	}
+
+	for _, a := range []struct {
+		name string
+		pin  int
+	}{{"mount.ra.home_pin", m.RA.HomePin}, {"mount.dec.home_pin", m.Dec.HomePin}} {
+		if a.pin >= 0 {
+			used[a.pin] = a.name
+		}
+	}
+
+	for action, pin := range h.Pins {
+		name := "handset.pins." + action
+		if !handsetActions[action] {
+			errs = append(errs, fmt.Errorf("unknown handset action %q", action))
+			continue
+		}
+
+		if pin < -1 {
+			errs = append(errs, fmt.Errorf("%s must be a gpio line or -1 for none, got %d", name, pin))
+			continue
+		}
+
+		if pin == -1 {
+			continue
+		}
+
+		if other, ok := used[pin]; ok {
+			errs = append(errs, fmt.Errorf("%s and %s must differ, both are %d", other, name, pin))
+			continue
+		}
+		used[pin] = name
+	}
+
+	return errs
+}
@@ -0,0 +1,285 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		change func(*Config)
+		errs   []string
+	}{
+		{name: "default", change: func(c *Config) {}},
+		{
+			name:   "site",
+			change: func(c *Config) { c.Site.Latitude, c.Site.Longitude = 91, -181 },
+			errs:   []string{"site.latitude must be between -90 and 90, got 91", "site.longitude must be between -180 and 180, got -181"},
+		},
+		{
+			name:   "telescope",
+			change: func(c *Config) { c.Telescope.Aperture, c.Telescope.FocalLength = 0, -1 },
+			errs:   []string{"telescope.aperture must be positive", "telescope.focal_length can't be negative"},
+		},
+		{
+			name: "addresses and pins only matter with a serial device",
+			change: func(c *Config) {
+				c.Mount.Dec.Address = c.Mount.RA.Address
+				c.Mount.Dec.IndexPin = c.Mount.RA.IndexPin
+			},
+		},
+		{
+			name: "addresses and pins",
+			change: func(c *Config) {
+				c.Mount.Serial = "/dev/ttyAMA0"
+				c.Mount.Baud = 0
+				c.Mount.GPIOChip = ""
+				c.Mount.Dec.Address = c.Mount.RA.Address
+				c.Mount.Dec.IndexPin = c.Mount.RA.IndexPin
+			},
+			errs: []string{
+				"mount.baud must be positive, got 0",
+				"mount.gpio_chip is required",
+				"mount.ra.address and mount.dec.address must differ, both are 0",
+				"mount.ra.index_pin and mount.dec.index_pin must differ, both are 23",
+			},
+		},
+		{
+			name: "axis",
+			change: func(c *Config) {
+				c.Mount.RA = Axis{GearRatio: -1, SlewSpeed: 1, SlowSpeed: 2, Address: 4, IndexPin: -1, HomePin: -2, HomeDirection: 0, Approach: 2}
+			},
+			errs: []string{
+				"mount.ra.gear_ratio must be positive",
+				"mount.ra.motor_steps must be positive",
+				"mount.ra.slow_speed (2) can't be faster than mount.ra.slew_speed (1)",
+				"mount.ra.address must be 0-3",
+				"mount.ra.index_pin must not be negative",
+				"mount.ra.home_pin must be a gpio line or -1",
+				"mount.ra.worm_period must be positive",
+				"mount.ra.home_direction must be 1 or -1, got 0",
+				"mount.ra.approach must be 1, -1 or 0 for either, got 2",
+			},
+		},
+		{
+			name:   "speeds",
+			change: func(c *Config) { c.Mount.Dec.SlowSpeed = 0 },
+			errs:   []string{"mount.dec.slew_speed and mount.dec.slow_speed must be positive"},
+		},
+		{
+			name:   "approach either way",
+			change: func(c *Config) { c.Mount.RA.Approach, c.Mount.Dec.Approach = 1, -1 },
+		},
+		{
+			name:   "gamepad",
+			change: func(c *Config) { c.Gamepad.Deadzone = 1 },
+			errs:   []string{"gamepad.deadzone must be at least 0 and less than 1"},
+		},
+		{
+			name: "handset",
+			change: func(c *Config) {
+				c.Handset.Debounce = -1
+				c.Handset.Pins = map[string]int{"up": 5, "north": -2, "south": -1, "east": 23, "west": 17}
+			},
+			errs: []string{
+				"handset.debounce must not be negative",
+				`unknown handset action "up"`,
+				"handset.pins.north must be a gpio line or -1 for none, got -2",
+				"mount.ra.index_pin and handset.pins.east must differ, both are 23",
+			},
+		},
+		{
+			name: "handset and home pins",
+			change: func(c *Config) {
+				c.Mount.Dec.HomePin = 6
+				c.Handset.Pins = map[string]int{"north": 6}
+			},
+			errs: []string{"mount.dec.home_pin and handset.pins.north must differ, both are 6"},
+		},
+		{
+			name:   "handset pins",
+			change: func(c *Config) { c.Handset.Pins = map[string]int{"north": 5, "south": 5} },
+			errs:   []string{"must differ, both are 5"},
+		},
+		{
+			name:   "handset pins and unmapped actions",
+			change: func(c *Config) { c.Handset.Pins = map[string]int{"north": 5, "south": 6, "speed": -1} },
+		},
+		{
+			name: "gps",
+			change: func(c *Config) {
+				c.Mount.Serial = "/dev/ttyAMA0"
+				c.GPS.Serial = "/dev/ttyAMA0"
+				c.GPS.Gpsd = "localhost:2947"
+				c.GPS.Baud = 0
+			},
+			errs: []string{
+				"gps.serial and gps.gpsd can't both be set",
+				"gps.baud must be positive",
+				"gps.serial and mount.serial must differ",
+			},
+		},
+		{
+			name:   "server",
+			change: func(c *Config) { c.Server.Addr = "" },
+			errs:   []string{"server.addr is required"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			tc.change(&cfg)
+			err := cfg.Validate()
+			if len(tc.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("got no error, want %q", tc.errs)
+			}
+
+			// every problem is reported, one per line
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tc.errs) {
+				t.Errorf("got %d errors, want %d:\n%s", len(lines), len(tc.errs), err)
+			}
+
+			for _, want := range tc.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("%q isn't in:\n%s", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Addr != Default().Server.Addr {
+		t.Errorf("no file has addr %q, want the default", cfg.Server.Addr)
+	}
+
+	dir := t.TempDir()
+	pth := write(t, dir, "geq.toml", `
+[site]
+latitude = 40.5
+
+[mount.dec]
+backlash = 12
+`)
+
+	cfg, err = Load(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what the file sets is read, the rest keeps its default
+	if cfg.Site.Latitude != 40.5 || cfg.Mount.Dec.Backlash != 12 {
+		t.Errorf("got latitude %g and dec backlash %d from the file", cfg.Site.Latitude, cfg.Mount.Dec.Backlash)
+	}
+
+	if cfg.Mount.Dec.GearRatio != 136.0/16.0 || cfg.Mount.RA.HomePin != -1 || cfg.Server.UserDB != "user.db" {
+		t.Errorf("defaults weren't kept: %+v", cfg)
+	}
+
+	pth = write(t, dir, "typo.toml", `
+[site]
+latitude = 40.5
+lattitude = 41
+
+[mount.ra]
+gear_ration = 100
+`)
+
+	_, err = Load(pth)
+	if err == nil || !strings.Contains(err.Error(), "unknown keys") || !strings.Contains(err.Error(), "site.lattitude, mount.ra.gear_ration") {
+		t.Errorf("loading a config with typos returned %v", err)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.toml")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("loading a missing config returned %v", err)
+	}
+
+	if _, err := Load(write(t, dir, "bad.toml", "[site\n")); err == nil {
+		t.Error("loading a config that isn't toml didn't return an error")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	pth := write(t, dir, "geq.toml", `
+[site]
+latitude = 40.5
+`)
+
+	cfg, err := Load(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a command line flag changes the config, but not the file
+	cfg.Server.Addr = ":8080"
+
+	if err := cfg.Update(func(c *Config) { c.Mount.RA.Backlash = 7 }); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Mount.RA.Backlash != 7 || got.Site.Latitude != 40.5 || got.Server.Addr != Default().Server.Addr {
+		t.Errorf("updated config has ra backlash %d, latitude %g and addr %q", got.Mount.RA.Backlash, got.Site.Latitude, got.Server.Addr)
+	}
+
+	if _, err := os.Stat(pth + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file was left behind: %v", err)
+	}
+
+	// an update that makes the config invalid leaves the file alone
+	before, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.Update(func(c *Config) { c.Mount.RA.Approach = 2 }); err == nil {
+		t.Fatal("an invalid update didn't return an error")
+	}
+
+	after, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(before) != string(after) {
+		t.Errorf("an invalid update changed the file to:\n%s", after)
+	}
+
+	if err := Default().Update(func(*Config) {}); err == nil {
+		t.Error("updating a config that wasn't loaded from a file didn't return an error")
+	}
+}
+
+func write(t *testing.T, dir, name, s string) string {
+	t.Helper()
+
+	pth := filepath.Join(dir, name)
+	if err := os.WriteFile(pth, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return pth
+}
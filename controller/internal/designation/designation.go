@@ -0,0 +1,100 @@
+// Package designation puts the many ways of writing an object's
+// designation into one form, so that "M 31", "Messier 31" and "m31" or
+// "NGC 224", "NGC0224" and "ngc224" are the same.  The catalog builder
+// and the repo both use it, so what's typed matches what's stored.
+package designation
+
+import (
+	"strings"
+	"unicode"
+)
+
+var (
+	// catalogs are spelled out names of catalogs and their abbreviations.
+	catalogs = map[string]string{
+		"MESSIER":  "M",
+		"CALDWELL": "C",
+	}
+
+	// numbered are the prefixes of catalogs whose designations are a
+	// number, which is sometimes written with leading zeros (NGC0224,
+	// PGC 002557).
+	numbered = map[string]bool{
+		"M":   true,
+		"NGC": true,
+		"IC":  true,
+		"C":   true,
+		"HR":  true,
+		"HIP": true,
+		"HD":  true,
+		"UGC": true,
+		"PGC": true,
+		"SAO": true,
+		"MEL": true,
+		"CR":  true,
+	}
+
+	// greek are the names of the letters in Bayer designations and the
+	// abbreviations the catalogs use ("Alp CMa").
+	greek = map[string]string{
+		"ALPHA":   "ALP",
+		"BETA":    "BET",
+		"GAMMA":   "GAM",
+		"DELTA":   "DEL",
+		"EPSILON": "EPS",
+		"ZETA":    "ZET",
+		"ETA":     "ETA",
+		"THETA":   "THE",
+		"IOTA":    "IOT",
+		"KAPPA":   "KAP",
+		"LAMBDA":  "LAM",
+		"MU":      "MU",
+		"NU":      "NU",
+		"XI":      "XI",
+		"OMICRON": "OMI",
+		"PI":      "PI",
+		"RHO":     "RHO",
+		"SIGMA":   "SIG",
+		"TAU":     "TAU",
+		"UPSILON": "UPS",
+		"PHI":     "PHI",
+		"CHI":     "CHI",
+		"PSI":     "PSI",
+		"OMEGA":   "OME",
+	}
+)
+
+// Normalize returns s in upper case without spaces, with spelled out
+// catalogs and Greek letters abbreviated and the leading zeros dropped
+// from the number that follows a numbered catalog's prefix: "Messier
+// 31" is M31, "NGC 0224" NGC224, "UGC 00454" UGC454 and "alpha CMa"
+// ALPCMA.  Other numbers keep their zeros, as they're part of a
+// position (2MASX J00424433+4116074, IRAS 00400+4059).
+func Normalize(s string) string {
+	words := strings.Fields(strings.ToUpper(s))
+	for i, w := range words {
+		if c, ok := catalogs[w]; ok && i == 0 {
+			words[i] = c
+		} else if g, ok := greek[w]; ok {
+			words[i] = g
+		}
+	}
+
+	return trimZeros(strings.Join(words, ""))
+}
+
+// trimZeros drops the leading zeros of the number after d's catalog
+// prefix if the catalog is a numbered one, leaving the last digit of a
+// number that's all zeros.
+func trimZeros(d string) string {
+	i := strings.IndexFunc(d, func(r rune) bool { return !unicode.IsLetter(r) })
+	if i <= 0 || !numbered[d[:i]] {
+		return d
+	}
+
+	j := i
+	for j+1 < len(d) && d[j] == '0' && unicode.IsDigit(rune(d[j+1])) {
+		j++
+	}
+	return d[:i] + d[j:]
+}
@@ -0,0 +1,65 @@
+package designation
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want string
+	}{
+		{"M 31", "M31"},
+		{"m31", "M31"},
+		{"Messier 31", "M31"},
+		{"messier 031", "M31"},
+		{"  M   31 ", "M31"},
+		{"NGC 224", "NGC224"},
+		{"NGC0224", "NGC224"},
+		{"ngc 0224", "NGC224"},
+		{"NGC 0001 NED01", "NGC1NED01"},
+		{"IC 0186A", "IC186A"},
+		{"IC 0", "IC0"},
+		{"NGC 0000", "NGC0"},
+		{"Caldwell 14", "C14"},
+		{"C 041", "C41"},
+		{"HR 7001", "HR7001"},
+		{"HIP 091262", "HIP91262"},
+		{"HD 172167", "HD172167"},
+		{"UGC 00454", "UGC454"},
+		{"PGC 002557", "PGC2557"},
+		{"Mel 025", "MEL25"},
+		{"Mel025", "MEL25"},
+		{"alpha CMa", "ALPCMA"},
+		{"Alp CMa", "ALPCMA"},
+		{"9 CMa", "9CMA"},
+		{"Andromeda Galaxy", "ANDROMEDAGALAXY"},
+
+		// only the number after a numbered catalog's prefix loses its zeros
+		{"2MASX J00424433+4116074", "2MASXJ00424433+4116074"},
+		{"IRAS 00400+4059", "IRAS00400+4059"},
+		{"SDSS J001206.08-002454.7", "SDSSJ001206.08-002454.7"},
+		{"MCG +07-02-016", "MCG+07-02-016"},
+		{"ESO 540-030", "ESO540-030"},
+		{"Sh2-155", "SH2-155"},
+		{"Messier", "M"},
+		{"Sirius", "SIRIUS"},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		if got := Normalize(tc.in); got != tc.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestNormalizeIdempotent checks that a normalized designation stays
+// the same, as the catalog stores designations normalized and the repo
+// normalizes what it looks up.
+func TestNormalizeIdempotent(t *testing.T) {
+	for _, s := range []string{"NGC 0224", "Messier 31", "2MASX J00424433+4116074", "alpha CMa", "IC 0186A", "C 041"} {
+		n := Normalize(s)
+		if again := Normalize(n); again != n {
+			t.Errorf("Normalize(%q) = %q, but Normalize(%q) = %q", s, n, n, again)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package firmware
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	replyTimeout = 100 * time.Millisecond
+
+	// attempts is how many times a command is sent before giving up.
+	// Retries reuse the sequence number, so a count that was received but
+	// whose ack got lost isn't started twice.
+	attempts = 3
+)
+
+// ErrTimeout is returned when the firmware doesn't answer a command.
+var ErrTimeout = errors.New("no reply from firmware")
+
+type (
+	// Client sends commands to the firmware and waits for the answers.
+	// Commands must be sent from one goroutine at a time; frames read
+	// from the firmware are handed to Handle from another.
+	Client struct {
+		w       io.Writer
+		replies chan Frame
+		reports func(Counted)
+		seq     uint8
+	}
+
+	// NakError is the firmware refusing a command.
+	NakError struct {
+		Kind Kind
+		Code Code
+	}
+)
+
+// NewClient returns a client that writes commands to w and passes the
+// reports the firmware sends when a count finishes to reports.  reports
+// is called from whatever goroutine calls Handle and must not block.
+func NewClient(w io.Writer, reports func(Counted)) *Client {
+	return &Client{
+		w:       w,
+		replies: make(chan Frame, 1),
+		reports: reports,
+	}
+}
+
+// Handle takes a frame read from the firmware.
+func (c *Client) Handle(f Frame) {
+	if f.Address != ResponseAddress {
+		return
+	}
+
+	if f.Kind == Report {
+		var rep Counted
+		if err := rep.UnmarshalBinary(f.Payload); err != nil {
+			log.Printf("bad report from firmware: %s", err)
+			return
+		}
+		c.reports(rep)
+		return
+	}
+
+	// a reply nobody has taken yet is stale, so it makes way for f
+	for {
+		select {
+		case c.replies <- f:
+			return
+		default:
+		}
+
+		select {
+		case <-c.replies:
+		default:
+		}
+	}
+}
+
+// Hello asks the firmware what it's running and checks that it speaks
+// this version of the protocol.
+func (c *Client) Hello() (Versions, error) {
+	var v Versions
+	if err := c.request(Hello, Versions{Protocol: Version}, HelloReply, &v); err != nil {
+		return v, err
+	}
+
+	if v.Protocol != Version {
+		return v, fmt.Errorf("firmware %s doesn't speak protocol %d", v, Version)
+	}
+
+	return v, nil
+}
+
+// Count starts the firmware counting index pulses: ra on one axis and dec
+// on the other.  The firmware acks as soon as it starts and reports what
+// it counted when it's done.
+func (c *Client) Count(ra, dec uint16) error {
+	return c.request(Count, Steps{RA: ra, Dec: dec}, Ack, nil)
+}
+
+// Status asks the firmware what it's doing.
+func (c *Client) Status() (Status, error) {
+	var s Status
+	return s, c.request(Query, nil, QueryReply, &s)
+}
+
+// Abort stops the count in progress without toggling the outputs.  The
+// firmware still reports what it counted.
+func (c *Client) Abort() error {
+	return c.request(Abort, nil, Ack, nil)
+}
+
+// request sends a command and decodes the reply, which must be of kind
+// want, into reply.
+func (c *Client) request(kind Kind, payload encoding.BinaryMarshaler, want Kind, reply encoding.BinaryUnmarshaler) error {
+	var p []byte
+	if payload != nil {
+		var err error
+		if p, err = payload.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+
+	c.seq++
+	buf, err := Frame{Address: CommandAddress, Kind: kind, Seq: c.seq, Payload: p}.Encode()
+	if err != nil {
+		return err
+	}
+
+	var f Frame
+	for range attempts {
+		f, err = c.send(buf, c.seq)
+		var nak NakError
+		if err == nil || (errors.As(err, &nak) && nak.Code != BadCRC) {
+			break
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return fmt.Errorf("%s: %w", kind, err)
+		}
+		return err
+	}
+
+	if f.Kind != want {
+		return fmt.Errorf("firmware answered %s with %s", kind, f.Kind)
+	}
+
+	if reply == nil {
+		return nil
+	}
+
+	return reply.UnmarshalBinary(f.Payload)
+}
+
+func (c *Client) send(buf []byte, seq uint8) (Frame, error) {
+	select {
+	case <-c.replies: // drop anything stale
+	default:
+	}
+
+	if _, err := c.w.Write(buf); err != nil {
+		return Frame{}, err
+	}
+
+	tm := time.NewTimer(replyTimeout)
+	defer tm.Stop()
+
+	for {
+		select {
+		case f := <-c.replies:
+			if f.Seq != seq {
+				continue
+			}
+
+			if f.Kind == Nak {
+				code := UnknownCommand
+				if len(f.Payload) == 1 {
+					code = Code(f.Payload[0])
+				}
+				return f, NakError{Kind: Kind(buf[2]), Code: code}
+			}
+
+			return f, nil
+		case <-tm.C:
+			return Frame{}, ErrTimeout
+		}
+	}
+}
+
+func (e NakError) Error() string {
+	return fmt.Sprintf("firmware refused %s: %s", e.Kind, e.Code)
+}
@@ -0,0 +1,244 @@
+package firmware
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// fakeFirmware is the other end of an in-memory serial line.  Each
+	// command written to it is answered with whatever answer returns,
+	// which is read back from it like from the port.
+	fakeFirmware struct {
+		answer func(cmd Frame, n int) []Frame
+
+		lock sync.Mutex
+		cmds []Frame
+		out  chan []byte
+		buf  []byte
+	}
+)
+
+func newFake(t *testing.T, answer func(cmd Frame, n int) []Frame) (*Client, *fakeFirmware, chan Counted) {
+	fw := &fakeFirmware{answer: answer, out: make(chan []byte, 16)}
+	reports := make(chan Counted, 1)
+	c := NewClient(fw, func(rep Counted) { reports <- rep })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var buf []byte
+		p := make([]byte, 3) // read in small pieces, as a serial port would
+		for {
+			n, err := fw.Read(p)
+			if err != nil {
+				return
+			}
+
+			buf = append(buf, p[:n]...)
+			for len(buf) > 0 {
+				f, size, err := Decode(buf)
+				if errors.Is(err, ErrShort) {
+					break
+				}
+				if err != nil {
+					buf = buf[1:]
+					continue
+				}
+				buf = buf[size:]
+				c.Handle(f)
+			}
+		}
+	}()
+
+	t.Cleanup(func() {
+		close(fw.out)
+		<-done
+	})
+	return c, fw, reports
+}
+
+func (fw *fakeFirmware) Write(p []byte) (int, error) {
+	cmd, _, err := Decode(p)
+	if err != nil {
+		return 0, err
+	}
+
+	fw.lock.Lock()
+	fw.cmds = append(fw.cmds, cmd)
+	n := len(fw.cmds)
+	fw.lock.Unlock()
+
+	for _, f := range fw.answer(cmd, n) {
+		buf, err := f.Encode()
+		if err != nil {
+			return 0, err
+		}
+		fw.out <- buf
+	}
+	return len(p), nil
+}
+
+func (fw *fakeFirmware) Read(p []byte) (int, error) {
+	if len(fw.buf) == 0 {
+		var ok bool
+		if fw.buf, ok = <-fw.out; !ok {
+			return 0, errors.New("closed")
+		}
+	}
+
+	n := copy(p, fw.buf)
+	fw.buf = fw.buf[n:]
+	return n, nil
+}
+
+// seqs returns the sequence numbers of the commands written so far.
+func (fw *fakeFirmware) seqs() []uint8 {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	var s []uint8
+	for _, f := range fw.cmds {
+		s = append(s, f.Seq)
+	}
+	return s
+}
+
+func reply(cmd Frame, kind Kind, payload []byte) Frame {
+	return Frame{Address: ResponseAddress, Kind: kind, Seq: cmd.Seq, Payload: payload}
+}
+
+func sameSeq(t *testing.T, fw *fakeFirmware, attempts int) {
+	t.Helper()
+	seqs := fw.seqs()
+	if len(seqs) != attempts {
+		t.Fatalf("sent %d commands, want %d", len(seqs), attempts)
+	}
+	for _, s := range seqs {
+		if s != seqs[0] {
+			t.Errorf("retries used sequence numbers %v, want them all the same", seqs)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	c, _, _ := newFake(t, func(cmd Frame, n int) []Frame {
+		p, _ := Versions{Protocol: Version, Minor: 2}.MarshalBinary()
+		return []Frame{reply(cmd, HelloReply, p)}
+	})
+
+	v, err := c.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != (Versions{Protocol: Version, Minor: 2}) {
+		t.Errorf("got %s", v)
+	}
+}
+
+func TestRetryBadCRC(t *testing.T) {
+	c, fw, _ := newFake(t, func(cmd Frame, n int) []Frame {
+		if n < attempts {
+			return []Frame{reply(cmd, Nak, []byte{byte(BadCRC)})}
+		}
+		return []Frame{reply(cmd, Ack, nil)}
+	})
+
+	if err := c.Count(10, 20); err != nil {
+		t.Fatal(err)
+	}
+	sameSeq(t, fw, attempts)
+}
+
+func TestRetryLostAck(t *testing.T) {
+	c, fw, _ := newFake(t, func(cmd Frame, n int) []Frame {
+		if n == 1 {
+			return nil
+		}
+		return []Frame{reply(cmd, Ack, nil)}
+	})
+
+	if err := c.Count(10, 20); err != nil {
+		t.Fatal(err)
+	}
+	sameSeq(t, fw, 2)
+}
+
+func TestTimeout(t *testing.T) {
+	c, fw, _ := newFake(t, func(cmd Frame, n int) []Frame { return nil })
+
+	start := time.Now()
+	if err := c.Abort(); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want %v", err, ErrTimeout)
+	}
+
+	if d := time.Since(start); d < attempts*replyTimeout {
+		t.Errorf("gave up after %s", d)
+	}
+	sameSeq(t, fw, attempts)
+}
+
+func TestNakNotRetried(t *testing.T) {
+	c, fw, _ := newFake(t, func(cmd Frame, n int) []Frame {
+		return []Frame{reply(cmd, Nak, []byte{byte(Busy)})}
+	})
+
+	var nak NakError
+	if err := c.Count(1, 1); !errors.As(err, &nak) || nak.Code != Busy || nak.Kind != Count {
+		t.Fatalf("got %v, want a busy nak", err)
+	}
+	sameSeq(t, fw, 1)
+}
+
+func TestStaleReply(t *testing.T) {
+	c, fw, _ := newFake(t, func(cmd Frame, n int) []Frame {
+		stale := reply(cmd, Nak, []byte{byte(Busy)})
+		stale.Seq--
+		return []Frame{stale, reply(cmd, Ack, nil)}
+	})
+
+	if err := c.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if seqs := fw.seqs(); seqs[1] != seqs[0]+1 {
+		t.Errorf("sequence numbers %v, want them to go up by one", seqs)
+	}
+}
+
+func TestWrongReply(t *testing.T) {
+	c, _, _ := newFake(t, func(cmd Frame, n int) []Frame {
+		return []Frame{reply(cmd, Ack, nil)}
+	})
+
+	if _, err := c.Status(); err == nil {
+		t.Error("took an ack as a query reply")
+	}
+}
+
+func TestReport(t *testing.T) {
+	want := Counted{RA: 100, Dec: 7, Aborted: true}
+	c, _, reports := newFake(t, func(cmd Frame, n int) []Frame {
+		p, _ := want.MarshalBinary()
+		return []Frame{reply(cmd, Ack, nil), {Address: ResponseAddress, Kind: Report, Payload: p}}
+	})
+
+	if err := c.Count(100, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-reports:
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no report")
+	}
+}
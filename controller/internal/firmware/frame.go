@@ -0,0 +1,296 @@
+// Package firmware speaks the protocol of the mcu that counts index
+// pulses (see firmware/src/main.zig).  The controller sends command
+// frames and the firmware answers each one with an ack, a nak or a reply,
+// and reports on its own when a count finishes.
+//
+// Every frame is
+//
+//	sync (0x05) | address | kind | seq | length | payload... | crc
+//
+// where the crc is the tmc2209's CRC8 of everything before it.  Commands
+// go to CommandAddress and the firmware answers from ResponseAddress, so
+// neither can be mistaken for traffic to or from the tmc2209s that share
+// the uart.
+package firmware
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Version is the protocol version this package speaks.
+	Version = 1
+
+	Sync            = 0x05
+	CommandAddress  = 0x11
+	ResponseAddress = 0x12
+
+	MaxPayload = 16
+	headerSize = 5
+)
+
+const (
+	// commands
+	Hello Kind = 0x01
+	Count Kind = 0x02
+	Query Kind = 0x03
+	Abort Kind = 0x04
+
+	// responses
+	Ack        Kind = 0x80
+	Nak        Kind = 0x81
+	HelloReply Kind = 0x82
+	QueryReply Kind = 0x83
+	Report     Kind = 0x84
+)
+
+const (
+	BadCRC         Code = 1
+	Busy           Code = 2
+	UnknownCommand Code = 3
+	BadLength      Code = 4
+)
+
+var (
+	// ErrShort means the buffer holds the start of a frame and more
+	// bytes are needed to decode it.
+	ErrShort = errors.New("incomplete frame")
+
+	// ErrNotFrame means the buffer doesn't start with a frame; skip a
+	// byte and try again.
+	ErrNotFrame = errors.New("not a frame")
+)
+
+type (
+	Kind uint8
+
+	// Code is the reason the firmware gives for a nak.
+	Code uint8
+
+	Frame struct {
+		Address uint8
+		Kind    Kind
+		Seq     uint8
+		Payload []byte
+	}
+
+	// Versions is the payload of a HelloReply.
+	Versions struct {
+		Protocol uint8
+		Major    uint8
+		Minor    uint8
+		Patch    uint8
+	}
+
+	// Steps is the payload of a Count.
+	Steps struct {
+		RA  uint16
+		Dec uint16
+	}
+
+	// Status is the payload of a QueryReply.
+	Status struct {
+		Counting     bool   `json:"counting"`
+		RARemaining  uint16 `json:"ra_remaining"`
+		DecRemaining uint16 `json:"dec_remaining"`
+		RACounted    uint16 `json:"ra_counted"`
+		DecCounted   uint16 `json:"dec_counted"`
+	}
+
+	// Counted is the payload of a Report: how many index pulses were
+	// counted on each axis, including any that came after the firmware
+	// told the controller to stop, and whether the count was aborted.
+	Counted struct {
+		RA      uint16
+		Dec     uint16
+		Aborted bool
+	}
+)
+
+// Encode returns the frame as it goes over the wire.
+func (f Frame) Encode() ([]byte, error) {
+	if len(f.Payload) > MaxPayload {
+		return nil, fmt.Errorf("payload of %d bytes is longer than %d", len(f.Payload), MaxPayload)
+	}
+
+	buf := make([]byte, 0, headerSize+len(f.Payload)+1)
+	buf = append(buf, Sync, f.Address, uint8(f.Kind), f.Seq, uint8(len(f.Payload)))
+	buf = append(buf, f.Payload...)
+	return append(buf, CRC8(buf)), nil
+}
+
+// Decode decodes the frame at the start of buf and returns it along with
+// the number of bytes it took up.
+func Decode(buf []byte) (Frame, int, error) {
+	if len(buf) == 0 {
+		return Frame{}, 0, ErrShort
+	}
+
+	if buf[0] != Sync {
+		return Frame{}, 0, ErrNotFrame
+	}
+
+	if len(buf) > 1 && buf[1] != CommandAddress && buf[1] != ResponseAddress {
+		return Frame{}, 0, ErrNotFrame
+	}
+
+	if len(buf) < headerSize {
+		return Frame{}, 0, ErrShort
+	}
+
+	n := int(buf[4])
+	if n > MaxPayload {
+		return Frame{}, 0, ErrNotFrame
+	}
+
+	size := headerSize + n + 1
+	if len(buf) < size {
+		return Frame{}, 0, ErrShort
+	}
+
+	if CRC8(buf[:size-1]) != buf[size-1] {
+		return Frame{}, 0, ErrNotFrame
+	}
+
+	return Frame{
+		Address: buf[1],
+		Kind:    Kind(buf[2]),
+		Seq:     buf[3],
+		Payload: append([]byte{}, buf[headerSize:size-1]...),
+	}, size, nil
+}
+
+// CRC8 is the CRC (polynomial x^8 + x^2 + x + 1) from the tmc2209
+// datasheet.  The firmware uses it too so there's only one to get right.
+func CRC8(buf []byte) uint8 {
+	var crc uint8
+	for _, b := range buf {
+		for range 8 {
+			if (crc>>7)^(b&0x01) != 0 {
+				crc = (crc << 1) ^ 0x07
+			} else {
+				crc = crc << 1
+			}
+			b >>= 1
+		}
+	}
+	return crc
+}
+
+func (v Versions) MarshalBinary() ([]byte, error) {
+	return []byte{v.Protocol, v.Major, v.Minor, v.Patch}, nil
+}
+
+func (v *Versions) UnmarshalBinary(p []byte) error {
+	if len(p) != 4 {
+		return fmt.Errorf("hello reply should be 4 bytes, got %d", len(p))
+	}
+
+	*v = Versions{Protocol: p[0], Major: p[1], Minor: p[2], Patch: p[3]}
+	return nil
+}
+
+func (s Steps) MarshalBinary() ([]byte, error) {
+	return binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, s.RA), s.Dec), nil
+}
+
+func (s *Steps) UnmarshalBinary(p []byte) error {
+	if len(p) != 4 {
+		return fmt.Errorf("count should be 4 bytes, got %d", len(p))
+	}
+
+	*s = Steps{RA: binary.LittleEndian.Uint16(p), Dec: binary.LittleEndian.Uint16(p[2:])}
+	return nil
+}
+
+func (s Status) MarshalBinary() ([]byte, error) {
+	p := []byte{boolByte(s.Counting)}
+	for _, v := range []uint16{s.RARemaining, s.DecRemaining, s.RACounted, s.DecCounted} {
+		p = binary.LittleEndian.AppendUint16(p, v)
+	}
+	return p, nil
+}
+
+func (s *Status) UnmarshalBinary(p []byte) error {
+	if len(p) != 9 {
+		return fmt.Errorf("status should be 9 bytes, got %d", len(p))
+	}
+
+	*s = Status{
+		Counting:     p[0] != 0,
+		RARemaining:  binary.LittleEndian.Uint16(p[1:]),
+		DecRemaining: binary.LittleEndian.Uint16(p[3:]),
+		RACounted:    binary.LittleEndian.Uint16(p[5:]),
+		DecCounted:   binary.LittleEndian.Uint16(p[7:]),
+	}
+	return nil
+}
+
+func (c Counted) MarshalBinary() ([]byte, error) {
+	p := binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, c.RA), c.Dec)
+	return append(p, boolByte(c.Aborted)), nil
+}
+
+func (c *Counted) UnmarshalBinary(p []byte) error {
+	if len(p) != 5 {
+		return fmt.Errorf("report should be 5 bytes, got %d", len(p))
+	}
+
+	*c = Counted{
+		RA:      binary.LittleEndian.Uint16(p),
+		Dec:     binary.LittleEndian.Uint16(p[2:]),
+		Aborted: p[4] != 0,
+	}
+	return nil
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (v Versions) String() string {
+	return fmt.Sprintf("%d.%d.%d (protocol %d)", v.Major, v.Minor, v.Patch, v.Protocol)
+}
+
+func (k Kind) String() string {
+	switch k {
+	case Hello:
+		return "hello"
+	case Count:
+		return "count"
+	case Query:
+		return "query"
+	case Abort:
+		return "abort"
+	case Ack:
+		return "ack"
+	case Nak:
+		return "nak"
+	case HelloReply:
+		return "hello reply"
+	case QueryReply:
+		return "query reply"
+	case Report:
+		return "report"
+	}
+	return fmt.Sprintf("kind %#x", uint8(k))
+}
+
+func (c Code) String() string {
+	switch c {
+	case BadCRC:
+		return "bad crc"
+	case Busy:
+		return "busy"
+	case UnknownCommand:
+		return "unknown command"
+	case BadLength:
+		return "bad length"
+	}
+	return fmt.Sprintf("code %d", uint8(c))
+}
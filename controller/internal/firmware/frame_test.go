@@ -0,0 +1,138 @@
+package firmware
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, kind := range []Kind{Hello, Count, Query, Abort, Ack, Nak, HelloReply, QueryReply, Report} {
+		for n := 0; n <= MaxPayload; n++ {
+			f := Frame{Address: CommandAddress, Kind: kind, Seq: uint8(n * 17), Payload: bytes.Repeat([]byte{Sync}, n)}
+			if kind >= Ack {
+				f.Address = ResponseAddress
+			}
+
+			buf, err := f.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, size, err := Decode(append(buf, 0xff, Sync))
+			if err != nil {
+				t.Fatalf("%s with %d bytes: %s", kind, n, err)
+			}
+
+			if size != len(buf) || !reflect.DeepEqual(got, f) {
+				t.Errorf("%s with %d bytes: decoded %+v (%d bytes), want %+v (%d bytes)", kind, n, got, size, f, len(buf))
+			}
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := (Frame{Address: CommandAddress, Payload: make([]byte, MaxPayload+1)}).Encode(); err == nil {
+		t.Error("encoded a payload longer than MaxPayload")
+	}
+}
+
+func TestDecodeShort(t *testing.T) {
+	buf, _ := Frame{Address: ResponseAddress, Kind: QueryReply, Seq: 3, Payload: make([]byte, 9)}.Encode()
+	for i := range len(buf) {
+		if _, _, err := Decode(buf[:i]); !errors.Is(err, ErrShort) {
+			t.Errorf("%d of %d bytes: got %v, want %v", i, len(buf), err, ErrShort)
+		}
+	}
+}
+
+func TestDecodeNotFrame(t *testing.T) {
+	good, _ := Frame{Address: ResponseAddress, Kind: Ack, Seq: 9}.Encode()
+	for name, buf := range map[string][]byte{
+		"no sync":     append([]byte{0x00}, good[1:]...),
+		"bad address": append([]byte{Sync, 0x00}, good[2:]...),
+		"bad crc":     append(append([]byte{}, good[:len(good)-1]...), good[len(good)-1]^0x01),
+		"too long":    {Sync, CommandAddress, byte(Count), 1, MaxPayload + 1},
+	} {
+		if _, _, err := Decode(buf); !errors.Is(err, ErrNotFrame) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrNotFrame)
+		}
+	}
+}
+
+func TestPayloads(t *testing.T) {
+	for _, tc := range []struct {
+		in  encoding.BinaryMarshaler
+		out encoding.BinaryUnmarshaler
+	}{
+		{Versions{Protocol: Version, Major: 1, Minor: 2, Patch: 3}, &Versions{}},
+		{Steps{RA: 0xfffe, Dec: 1}, &Steps{}},
+		{Status{Counting: true, RARemaining: 1, DecRemaining: 2, RACounted: 3, DecCounted: 0xffff}, &Status{}},
+		{Counted{RA: 500, Dec: 0x1234, Aborted: true}, &Counted{}},
+	} {
+		p, err := tc.in.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(p) > MaxPayload {
+			t.Errorf("%T is %d bytes, longer than a frame can carry", tc.in, len(p))
+		}
+
+		if err := tc.out.UnmarshalBinary(p); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := reflect.ValueOf(tc.out).Elem().Interface(); got != tc.in {
+			t.Errorf("got %+v, want %+v", got, tc.in)
+		}
+
+		if err := tc.out.UnmarshalBinary(append(p, 0)); err == nil {
+			t.Errorf("%T took a payload that's too long", tc.out)
+		}
+	}
+}
+
+// FuzzDecode checks that Decode never panics or reads past what it's
+// given, and that anything it decodes encodes back to the same bytes.
+func FuzzDecode(f *testing.F) {
+	for _, fr := range []Frame{
+		{Address: CommandAddress, Kind: Hello, Seq: 1, Payload: []byte{Version, 0, 0, 0}},
+		{Address: ResponseAddress, Kind: Report, Seq: 0, Payload: []byte{1, 2, 3, 4, 1}},
+		{Address: ResponseAddress, Kind: Nak, Seq: 255, Payload: []byte{byte(BadCRC)}},
+	} {
+		buf, _ := fr.Encode()
+		f.Add(buf)
+		f.Add(buf[:len(buf)-1])
+	}
+	f.Add([]byte{Sync, Sync, CommandAddress})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		fr, n, err := Decode(buf)
+		if err != nil {
+			if n != 0 {
+				t.Fatalf("error %s with %d bytes used", err, n)
+			}
+			if !errors.Is(err, ErrShort) && !errors.Is(err, ErrNotFrame) {
+				t.Fatalf("unexpected error %s", err)
+			}
+			return
+		}
+
+		if n < headerSize+1 || n > len(buf) {
+			t.Fatalf("used %d of %d bytes", n, len(buf))
+		}
+
+		enc, err := fr.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(enc, buf[:n]) {
+			t.Fatalf("decoded %x as %+v, which encodes to %x", buf[:n], fr, enc)
+		}
+	})
+}
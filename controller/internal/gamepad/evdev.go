@@ -0,0 +1,114 @@
+package gamepad
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// event types and the codes of the axes and buttons gamepads have, from
+// linux/input-event-codes.h
+const (
+	evKey uint16 = 0x01
+	evAbs uint16 = 0x03
+)
+
+var (
+	axes = map[string]uint16{
+		"ABS_X":     0x00,
+		"ABS_Y":     0x01,
+		"ABS_Z":     0x02,
+		"ABS_RX":    0x03,
+		"ABS_RY":    0x04,
+		"ABS_RZ":    0x05,
+		"ABS_HAT0X": 0x10,
+		"ABS_HAT0Y": 0x11,
+	}
+
+	buttons = map[string]uint16{
+		"BTN_TRIGGER": 0x120,
+		"BTN_THUMB":   0x121,
+		"BTN_THUMB2":  0x122,
+		"BTN_TOP":     0x123,
+		"BTN_TOP2":    0x124,
+		"BTN_PINKIE":  0x125,
+		"BTN_BASE":    0x126,
+		"BTN_BASE2":   0x127,
+		"BTN_SOUTH":   0x130,
+		"BTN_EAST":    0x131,
+		"BTN_C":       0x132,
+		"BTN_NORTH":   0x133,
+		"BTN_WEST":    0x134,
+		"BTN_Z":       0x135,
+		"BTN_TL":      0x136,
+		"BTN_TR":      0x137,
+		"BTN_TL2":     0x138,
+		"BTN_TR2":     0x139,
+		"BTN_SELECT":  0x13a,
+		"BTN_START":   0x13b,
+		"BTN_MODE":    0x13c,
+		"BTN_THUMBL":  0x13d,
+		"BTN_THUMBR":  0x13e,
+	}
+)
+
+// eventSize is the size of a struct input_event: a timeval of two longs
+// then the type, code and value.
+var eventSize = 2*strconv.IntSize/8 + 8
+
+type (
+	event struct {
+		kind  uint16
+		code  uint16
+		value int32
+	}
+
+	// span is the range of values an absolute axis reports.
+	span struct {
+		min, max int32
+	}
+)
+
+// readEvent reads the next event from r, a device or a recording of one
+// (the bytes read from a device, as cat /dev/input/eventN > file saves
+// them).
+func readEvent(r io.Reader, buf []byte) (event, error) {
+	if _, err := io.ReadFull(r, buf[:eventSize]); err != nil {
+		return event{}, err
+	}
+
+	b := buf[eventSize-8 : eventSize]
+	return event{
+		kind:  binary.LittleEndian.Uint16(b[0:2]),
+		code:  binary.LittleEndian.Uint16(b[2:4]),
+		value: int32(binary.LittleEndian.Uint32(b[4:8])),
+	}, nil
+}
+
+// absInfo asks the device for the range of an absolute axis
+// (EVIOCGABS).
+func absInfo(f *os.File, code uint16) (span, error) {
+	// struct input_absinfo: value, minimum, maximum, fuzz, flat and
+	// resolution
+	var info [6]int32
+	req := 2<<30 | uintptr(unsafe.Sizeof(info))<<16 | 'E'<<8 | uintptr(0x40+code)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&info))); errno != 0 {
+		return span{}, fmt.Errorf("unable to get range of axis %#x: %w", code, errno)
+	}
+
+	return span{min: info[1], max: info[2]}, nil
+}
+
+// scale maps v onto -1 to 1 with the middle of the range at 0.
+func (s span) scale(v int32) float64 {
+	if s.max <= s.min {
+		return 0
+	}
+
+	x := 2*float64(v-s.min)/float64(s.max-s.min) - 1
+	return max(-1, min(1, x))
+}
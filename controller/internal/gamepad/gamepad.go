@@ -0,0 +1,315 @@
+// Package gamepad drives the mount from usb gamepads and joysticks read
+// through linux evdev: sticks move the axes and buttons pick the speed,
+// stop, park and goto the next object.
+package gamepad
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/config"
+)
+
+const (
+	// scanInterval is how often the device glob is checked for pads
+	// that have been plugged in.
+	scanInterval = 2 * time.Second
+
+	// levels is how finely a stick's travel is divided.  The mount is
+	// only told about a stick when it moves to a different level, not
+	// on every jitter.
+	levels = 10
+)
+
+// actions are what buttons can do, other than pick one of the speed
+// presets.
+var actions = map[string]bool{
+	"stop":   true,
+	"park":   true,
+	"next":   true,
+	"guide":  true,
+	"center": true,
+	"find":   true,
+	"max":    true,
+}
+
+// defaultSpan is used for sticks whose range can't be read from the
+// device.
+var defaultSpan = span{min: -32768, max: 32767}
+
+type (
+	// Mount is the part of mount.Mount a gamepad works.
+	Mount interface {
+		Move(axis string, rpm float64) error
+		Preset(axis, speed string) (float64, error)
+		Stop()
+		Park() error
+	}
+
+	// Pad reads the gamepads config.Gamepad.Device matches and works
+	// the mount with them.
+	Pad struct {
+		cfg     config.Gamepad
+		mount   Mount
+		next    func() error
+		sticks  map[uint16]stick
+		buttons map[uint16]string
+	}
+
+	// stick is the mount axis an absolute axis moves and which way.
+	stick struct {
+		axis string
+		sign float64
+	}
+
+	// reading is the state of one device: the speed its sticks move the
+	// axes at full travel, the range of each stick and where each mount
+	// axis was last sent.
+	reading struct {
+		speed string
+		spans map[uint16]span
+		level map[string]float64
+	}
+)
+
+// New returns a pad that works m as cfg says, calling next to goto the
+// next object.
+func New(cfg config.Gamepad, m Mount, next func() error) (*Pad, error) {
+	if _, err := filepath.Match(cfg.Device, ""); err != nil {
+		return nil, fmt.Errorf("bad gamepad.device %q: %w", cfg.Device, err)
+	}
+
+	p := &Pad{
+		cfg:     cfg,
+		mount:   m,
+		next:    next,
+		sticks:  map[uint16]stick{},
+		buttons: map[uint16]string{},
+	}
+
+	var errs []error
+	for _, s := range []struct {
+		axis  string
+		stick config.Stick
+	}{{"ra", cfg.RA}, {"dec", cfg.Dec}} {
+		code, ok := axes[s.stick.Axis]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown gamepad.%s.axis %q", s.axis, s.stick.Axis))
+			continue
+		}
+
+		if _, ok := p.sticks[code]; ok {
+			errs = append(errs, fmt.Errorf("gamepad.ra.axis and gamepad.dec.axis must differ, both are %s", s.stick.Axis))
+			continue
+		}
+
+		sign := 1.0
+		if s.stick.Invert {
+			sign = -1
+		}
+		p.sticks[code] = stick{axis: s.axis, sign: sign}
+	}
+
+	for action, name := range cfg.Buttons {
+		if !actions[action] {
+			errs = append(errs, fmt.Errorf("unknown gamepad action %q", action))
+			continue
+		}
+
+		if name == "" {
+			continue
+		}
+
+		code, ok := buttons[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown button %q for gamepad action %s", name, action))
+			continue
+		}
+
+		if other, ok := p.buttons[code]; ok {
+			errs = append(errs, fmt.Errorf("%s is mapped to both %s and %s", name, other, action))
+			continue
+		}
+		p.buttons[code] = action
+	}
+
+	return p, errors.Join(errs...)
+}
+
+// Watch reads every device that matches the configured glob, checking
+// for new ones every scanInterval.  It doesn't return.
+func (p *Pad) Watch() {
+	open := map[string]bool{}
+	closed := make(chan string)
+	tick := time.NewTicker(scanInterval)
+	defer tick.Stop()
+
+	p.scan(open, closed)
+	for {
+		select {
+		case pth := <-closed:
+			delete(open, pth)
+		case <-tick.C:
+			p.scan(open, closed)
+		}
+	}
+}
+
+func (p *Pad) scan(open map[string]bool, closed chan<- string) {
+	paths, _ := filepath.Glob(p.cfg.Device) // the pattern is checked by New
+	for _, pth := range paths {
+		if open[pth] {
+			continue
+		}
+
+		open[pth] = true
+		go func() {
+			p.serve(pth)
+			closed <- pth
+		}()
+	}
+}
+
+// serve reads the device at pth until it's unplugged.
+func (p *Pad) serve(pth string) {
+	f, err := os.Open(pth)
+	if err != nil {
+		log.Printf("unable to open gamepad %s: %s", pth, err)
+		return
+	}
+	defer f.Close()
+
+	spans := map[uint16]span{}
+	for code := range p.sticks {
+		s, err := absInfo(f, code)
+		if err != nil {
+			log.Printf("gamepad %s: %s, assuming %d to %d", pth, err, defaultSpan.min, defaultSpan.max)
+			s = defaultSpan
+		}
+		spans[code] = s
+	}
+
+	log.Printf("gamepad %s connected", pth)
+	err = p.read(bufio.NewReaderSize(f, 64*eventSize), spans)
+	log.Printf("gamepad %s disconnected: %s", pth, err)
+}
+
+// read works the mount with the events from r until r runs out, then
+// stops any axis a stick left moving.  spans are the ranges of the
+// sticks.
+func (p *Pad) read(r io.Reader, spans map[uint16]span) error {
+	rd := reading{
+		speed: "center",
+		spans: spans,
+		level: map[string]float64{},
+	}
+	defer p.release(&rd)
+
+	buf := make([]byte, eventSize)
+	for {
+		evt, err := readEvent(r, buf)
+		if err != nil {
+			return err
+		}
+
+		switch evt.kind {
+		case evKey:
+			if evt.value == 1 { // 0 is a release and 2 a repeat
+				p.press(&rd, evt.code)
+			}
+		case evAbs:
+			p.tilt(&rd, evt.code, evt.value)
+		}
+	}
+}
+
+func (p *Pad) press(rd *reading, code uint16) {
+	action, ok := p.buttons[code]
+	if !ok {
+		return
+	}
+
+	var err error
+	switch action {
+	case "stop":
+		p.mount.Stop()
+		clear(rd.level)
+	case "park":
+		err = p.mount.Park()
+	case "next":
+		err = p.next()
+	default:
+		rd.speed = action
+		for axis, level := range rd.level {
+			if level != 0 {
+				p.move(rd, axis)
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("gamepad %s: %s", action, err)
+	}
+}
+
+// tilt moves the axis a stick is mapped to at a rate proportional to how
+// far the stick is pushed past the deadzone.
+func (p *Pad) tilt(rd *reading, code uint16, value int32) {
+	s, ok := p.sticks[code]
+	if !ok {
+		return
+	}
+
+	sp, ok := rd.spans[code]
+	if !ok {
+		sp = defaultSpan
+	}
+
+	x := sp.scale(value) * s.sign
+	dz := p.cfg.Deadzone
+	if math.Abs(x) <= dz {
+		x = 0
+	} else {
+		x = math.Copysign((math.Abs(x)-dz)/(1-dz), x)
+	}
+
+	level := math.Round(x*levels) / levels
+	if level == rd.level[s.axis] {
+		return
+	}
+
+	rd.level[s.axis] = level
+	p.move(rd, s.axis)
+}
+
+func (p *Pad) move(rd *reading, axis string) {
+	rpm, err := p.mount.Preset(axis, rd.speed)
+	if err == nil {
+		err = p.mount.Move(axis, rd.level[axis]*rpm)
+	}
+
+	if err != nil {
+		log.Printf("gamepad unable to move %s: %s", axis, err)
+	}
+}
+
+// release stops the axes a device's sticks were moving when it went
+// away.
+func (p *Pad) release(rd *reading) {
+	for axis, level := range rd.level {
+		if level == 0 {
+			continue
+		}
+
+		if err := p.mount.Move(axis, 0); err != nil {
+			log.Printf("gamepad unable to stop %s: %s", axis, err)
+		}
+	}
+}
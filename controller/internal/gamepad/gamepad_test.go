@@ -0,0 +1,241 @@
+package gamepad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cswank/geq/controller/internal/config"
+)
+
+// fakeMount records what a pad tells it to do.  Each speed preset is ten
+// times the one before it.
+type fakeMount struct {
+	calls []string
+}
+
+var presets = map[string]float64{"guide": 1, "center": 10, "find": 100, "max": 1000}
+
+func (f *fakeMount) Move(axis string, rpm float64) error {
+	f.calls = append(f.calls, fmt.Sprintf("move %s %.1f", axis, rpm))
+	return nil
+}
+
+func (f *fakeMount) Preset(axis, speed string) (float64, error) {
+	return presets[speed], nil
+}
+
+func (f *fakeMount) Stop() {
+	f.calls = append(f.calls, "stop")
+}
+
+func (f *fakeMount) Park() error {
+	f.calls = append(f.calls, "park")
+	return nil
+}
+
+// recording is what reading a device that sent evts would give.
+func recording(evts ...event) *bytes.Reader {
+	var buf bytes.Buffer
+	for _, e := range evts {
+		b := make([]byte, eventSize) // a zero timestamp
+		binary.LittleEndian.PutUint16(b[eventSize-8:], e.kind)
+		binary.LittleEndian.PutUint16(b[eventSize-6:], e.code)
+		binary.LittleEndian.PutUint32(b[eventSize-4:], uint32(e.value))
+		buf.Write(b)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func stickAt(axis string, v int32) event {
+	return event{kind: evAbs, code: axes[axis], value: v}
+}
+
+func button(name string, v int32) event {
+	return event{kind: evKey, code: buttons[name], value: v}
+}
+
+func TestRead(t *testing.T) {
+	testCases := []struct {
+		name   string
+		events []event
+		want   []string
+	}{
+		{
+			name: "stick",
+			events: []event{
+				stickAt("ABS_X", 100),
+				stickAt("ABS_X", 99), // the same level
+				stickAt("ABS_Y", 100),
+				stickAt("ABS_X", 0),
+			},
+			// dec is inverted and left pushed when the device goes away
+			want: []string{"move ra 10.0", "move dec -10.0", "move ra 0.0", "move dec 0.0"},
+		},
+		{
+			name: "part way",
+			events: []event{
+				stickAt("ABS_X", 50),
+				stickAt("ABS_X", -20),
+				stickAt("ABS_X", 0),
+			},
+			// past the deadzone of 0.15 the rest of the travel is 0 to 1
+			want: []string{"move ra 4.0", "move ra -1.0", "move ra 0.0"},
+		},
+		{
+			name: "deadzone",
+			events: []event{
+				stickAt("ABS_X", 5),
+				stickAt("ABS_X", -10),
+				stickAt("ABS_X", 15),
+				stickAt("ABS_Y", -15),
+				stickAt("ABS_X", 0),
+			},
+		},
+		{
+			name: "unmapped",
+			events: []event{
+				stickAt("ABS_RX", 100),
+				button("BTN_THUMBL", 1),
+				{kind: 0x00, code: 0, value: 0}, // EV_SYN
+			},
+		},
+		{
+			name: "speed",
+			events: []event{
+				stickAt("ABS_X", 100),
+				button("BTN_TR", 1),
+				button("BTN_TR", 2), // a repeat
+				button("BTN_TR", 0), // a release
+				button("BTN_WEST", 1),
+			},
+			want: []string{"move ra 10.0", "move ra 1000.0", "move ra 1.0", "move ra 0.0"},
+		},
+		{
+			name: "speed before the stick",
+			events: []event{
+				button("BTN_EAST", 1),
+				stickAt("ABS_Y", -100),
+			},
+			want: []string{"move dec 100.0", "move dec 0.0"},
+		},
+		{
+			name: "stop",
+			events: []event{
+				stickAt("ABS_X", 100),
+				button("BTN_SOUTH", 1),
+				button("BTN_SOUTH", 0),
+			},
+			// the mount has stopped, so there's nothing to release
+			want: []string{"move ra 10.0", "stop"},
+		},
+		{
+			name: "park and next",
+			events: []event{
+				button("BTN_SELECT", 1),
+				button("BTN_START", 1),
+				button("BTN_START", 2),
+			},
+			want: []string{"park", "next"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &fakeMount{}
+			p, err := New(config.Default().Gamepad, m, func() error {
+				m.calls = append(m.calls, "next")
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			spans := map[uint16]span{axes["ABS_X"]: {min: -100, max: 100}, axes["ABS_Y"]: {min: -100, max: 100}}
+			p.read(recording(tc.events...), spans)
+			if !slices.Equal(m.calls, tc.want) {
+				t.Errorf("got %q, want %q", m.calls, tc.want)
+			}
+		})
+	}
+}
+
+// TestReadPartial checks that a device going away part way through an
+// event still releases the sticks.
+func TestReadPartial(t *testing.T) {
+	m := &fakeMount{}
+	p, err := New(config.Default().Gamepad, m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := recording(stickAt("ABS_X", 32767), stickAt("ABS_X", 0))
+	b := make([]byte, eventSize+3)
+	r.Read(b)
+
+	// without spans the default range is assumed
+	p.read(bytes.NewReader(b), nil)
+	want := []string{"move ra 10.0", "move ra 0.0"}
+	if !slices.Equal(m.calls, want) {
+		t.Errorf("got %q, want %q", m.calls, want)
+	}
+}
+
+func TestScale(t *testing.T) {
+	testCases := []struct {
+		span span
+		v    int32
+		want float64
+	}{
+		{span{min: -32768, max: 32767}, -32768, -1},
+		{span{min: -32768, max: 32767}, 32767, 1},
+		{span{min: 0, max: 255}, 0, -1},
+		{span{min: 0, max: 255}, 255, 1},
+		{span{min: 0, max: 200}, 100, 0},
+		{span{min: 0, max: 200}, 300, 1}, // past the reported range
+		{span{min: 0, max: 0}, 10, 0},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.span.scale(tc.v); got != tc.want {
+			t.Errorf("%v.scale(%d) = %f, want %f", tc.span, tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name string
+		edit func(*config.Gamepad)
+		want string
+	}{
+		{name: "default", edit: func(*config.Gamepad) {}},
+		{name: "bad glob", edit: func(c *config.Gamepad) { c.Device = "/dev/input/[" }, want: "bad gamepad.device"},
+		{name: "unknown axis", edit: func(c *config.Gamepad) { c.RA.Axis = "ABS_WHEEL" }, want: `unknown gamepad.ra.axis "ABS_WHEEL"`},
+		{name: "same axis", edit: func(c *config.Gamepad) { c.Dec.Axis = "ABS_X" }, want: "must differ"},
+		{name: "unknown action", edit: func(c *config.Gamepad) { c.Buttons["slew"] = "BTN_TL" }, want: `unknown gamepad action "slew"`},
+		{name: "unknown button", edit: func(c *config.Gamepad) { c.Buttons["stop"] = "BTN_X" }, want: `unknown button "BTN_X"`},
+		{name: "same button", edit: func(c *config.Gamepad) { c.Buttons["park"] = "BTN_START" }, want: "BTN_START is mapped to both"},
+		{name: "unmapped", edit: func(c *config.Gamepad) { c.Buttons["park"] = "" }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default().Gamepad
+			cfg.Buttons = maps.Clone(cfg.Buttons)
+			tc.edit(&cfg)
+			_, err := New(cfg, &fakeMount{}, nil)
+			if tc.want == "" && err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+				t.Errorf("got error %v, want one with %q", err, tc.want)
+			}
+		})
+	}
+}
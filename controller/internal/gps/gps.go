@@ -0,0 +1,199 @@
+// Package gps reads the time and where the site is from an nmea gps
+// receiver, on a serial port or through gpsd.
+package gps
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/config"
+	"go.bug.st/serial"
+)
+
+// retryInterval is how long to wait before opening the receiver again
+// after it goes away.
+const retryInterval = 5 * time.Second
+
+type (
+	// Fix is what the receiver last said.  Quality is the GGA fix
+	// quality (0 for none, 1 for gps, 2 for dgps...).  Time is the utc
+	// time of the last RMC sentence and Received when that sentence
+	// arrived by the local clock, so the two differ by the clock error
+	// plus the receiver's latency (a few tenths of a second).
+	Fix struct {
+		Valid      bool      `json:"valid"`
+		Quality    int       `json:"quality"`
+		Satellites int       `json:"satellites"`
+		Latitude   float64   `json:"latitude"`
+		Longitude  float64   `json:"longitude"`
+		Elevation  float64   `json:"elevation"`
+		Time       time.Time `json:"time"`
+		Received   time.Time `json:"received"`
+	}
+
+	// Status is the receiver's connection and its last fix.
+	Status struct {
+		Source    string `json:"source"`
+		Connected bool   `json:"connected"`
+		Error     string `json:"error,omitempty"`
+		Fix       Fix    `json:"fix"`
+	}
+
+	Receiver struct {
+		open  func() (io.ReadCloser, error)
+		fixed func(Fix)
+
+		lock   sync.Mutex
+		status Status
+	}
+)
+
+// New returns a receiver for the gps cfg describes that calls fixed with
+// every valid fix.
+func New(cfg config.GPS, fixed func(Fix)) *Receiver {
+	r := &Receiver{fixed: fixed}
+	if cfg.Gpsd != "" {
+		r.status.Source = "gpsd " + cfg.Gpsd
+		r.open = func() (io.ReadCloser, error) { return gpsd(cfg.Gpsd) }
+	} else {
+		r.status.Source = cfg.Serial
+		r.open = func() (io.ReadCloser, error) {
+			return serial.Open(cfg.Serial, &serial.Mode{BaudRate: cfg.Baud})
+		}
+	}
+
+	return r
+}
+
+// gpsd connects to the gpsd at addr and asks it to pass on the nmea it
+// gets from its receivers.
+func gpsd(addr string) (io.ReadCloser, error) {
+	c, err := net.DialTimeout("tcp", addr, retryInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(c, `?WATCH={"enable":true,"nmea":true};`+"\n"); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("unable to watch gpsd: %w", err)
+	}
+
+	return c, nil
+}
+
+// Status returns the connection and last fix.
+func (r *Receiver) Status() Status {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.status
+}
+
+// Run reads the receiver, opening it again whenever it goes away.  It
+// doesn't return.
+func (r *Receiver) Run() {
+	for {
+		rc, err := r.open()
+		if err == nil {
+			r.connected(true, nil)
+			log.Printf("gps %s connected", r.status.Source)
+			err = r.read(rc)
+			rc.Close()
+		}
+
+		r.connected(false, err)
+		log.Printf("gps %s: %s, trying again in %s", r.status.Source, err, retryInterval)
+		time.Sleep(retryInterval)
+	}
+}
+
+func (r *Receiver) connected(up bool, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.status.Connected = up
+	r.status.Error = ""
+	if err != nil {
+		r.status.Error = err.Error()
+	}
+
+	if !up {
+		r.status.Fix.Valid = false
+	}
+}
+
+// read takes fixes from the nmea in rd (a receiver or a recording of
+// one) until it runs out.  Lines that aren't nmea, like the json gpsd
+// sends, are skipped.
+func (r *Receiver) read(rd io.Reader) error {
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		s, err := parse(sc.Text())
+		if errors.Is(err, errNotNMEA) {
+			continue
+		}
+
+		if err == nil {
+			err = r.take(s, time.Now())
+		}
+
+		if err != nil {
+			log.Printf("gps: %s", err)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+// take updates the fix with s, which arrived at now.  The position and
+// time are taken from RMC sentences and the quality and elevation from
+// GGA.
+func (r *Receiver) take(s sentence, now time.Time) error {
+	var fix *Fix
+	switch s.kind {
+	case "GGA":
+		g, err := s.gga()
+		if err != nil {
+			return err
+		}
+
+		r.lock.Lock()
+		r.status.Fix.Quality = g.quality
+		r.status.Fix.Satellites = g.satellites
+		if g.quality > 0 {
+			r.status.Fix.Elevation = g.altitude
+		}
+		r.lock.Unlock()
+	case "RMC":
+		m, err := s.rmc()
+		if err != nil {
+			return err
+		}
+
+		r.lock.Lock()
+		r.status.Fix.Valid = m.valid
+		if m.valid {
+			r.status.Fix.Latitude = m.latitude
+			r.status.Fix.Longitude = m.longitude
+			r.status.Fix.Time = m.time
+			r.status.Fix.Received = now
+			f := r.status.Fix
+			fix = &f
+		}
+		r.lock.Unlock()
+	}
+
+	if fix != nil && r.fixed != nil {
+		r.fixed(*fix)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package gps
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recording is what a receiver sends while it gets a fix and then loses
+// it, as read through gpsd, with a sentence garbled on the way.
+const recording = `{"class":"VERSION","release":"3.25","rev":"3.25","proto_major":3,"proto_minor":15}
+{"class":"WATCH","enable":true,"json":false,"nmea":true}
+$GPGGA,041500.00,,,,,0,00,99.99,,,,,,*66
+$GPRMC,041500.00,V,,,,,,,190826,,,N*79
+$GNGGA,041502.00,4000.1234,N,10515.6000,W,1,08,1.01,1655.3,M,-21.4,M,,*42
+$GNRMC,041502.00,A,4000.1234,N,10515.6000,W,0.012,,190826,,,A*7F
+$GNGSV,1,1,01,02,45,120,38*5D
+$GNRMC,041503.50,A,4000.1240,N,10515.6010,W,0.010,,190826,,,A*7B
+$GNRMC,041504.00,A,3300.0000,S,01500.0000,E,0.010,,190826,,,A*77
+$GNRMC,041504.00,A,3300.0000,S,01500.0000,E,0.010,,190826,,,A
+$GNRMC,041505.00,V,,,,,,,190826,,,N*62
+$GNGGA,041505.00,,,,,0,03,,,,,,,*50
+`
+
+func TestRead(t *testing.T) {
+	var fixes []Fix
+	r := &Receiver{fixed: func(f Fix) { fixes = append(fixes, f) }}
+
+	start := time.Now()
+	if err := r.read(strings.NewReader(recording)); !errors.Is(err, io.EOF) {
+		t.Fatalf("read returned %v, want io.EOF", err)
+	}
+
+	// the garbled sentences, and the ones without a fix, aren't fixes
+	want := []Fix{
+		{
+			Valid:      true,
+			Quality:    1,
+			Satellites: 8,
+			Latitude:   40 + 0.1234/60,
+			Longitude:  -(105 + 15.6/60),
+			Elevation:  1655.3,
+			Time:       time.Date(2026, 8, 19, 4, 15, 2, 0, time.UTC),
+		},
+		{
+			Valid:      true,
+			Quality:    1,
+			Satellites: 8,
+			Latitude:   40 + 0.124/60,
+			Longitude:  -(105 + 15.601/60),
+			Elevation:  1655.3,
+			Time:       time.Date(2026, 8, 19, 4, 15, 3, 5e8, time.UTC),
+		},
+	}
+
+	if len(fixes) != len(want) {
+		t.Fatalf("got %d fixes, want %d: %+v", len(fixes), len(want), fixes)
+	}
+
+	for i, f := range fixes {
+		if f.Received.Before(start) || f.Received.After(time.Now()) {
+			t.Errorf("fix %d received at %s, not while it was read", i, f.Received)
+		}
+
+		f.Received = time.Time{}
+		if !same(f, want[i]) {
+			t.Errorf("fix %d is %+v, want %+v", i, f, want[i])
+		}
+	}
+
+	// the last sentences say the fix is lost, which keeps where it was
+	st := r.Status()
+	if st.Fix.Valid || st.Fix.Quality != 0 {
+		t.Errorf("fix is valid %t, quality %d after it was lost", st.Fix.Valid, st.Fix.Quality)
+	}
+
+	if st.Fix.Elevation != 1655.3 || !st.Fix.Time.Equal(want[1].Time) {
+		t.Errorf("lost fix is %+v, want the last one kept", st.Fix)
+	}
+}
+
+// TestReadNoFix checks that a receiver that never gets a fix reports
+// none.
+func TestReadNoFix(t *testing.T) {
+	r := &Receiver{fixed: func(f Fix) { t.Errorf("fixed called with %+v", f) }}
+	r.read(strings.NewReader(strings.Repeat("$GPGGA,041500.00,,,,,0,00,99.99,,,,,,*66\r\n$GPRMC,041500.00,V,,,,,,,190826,,,N*79\r\n", 3)))
+
+	if st := r.Status(); st.Fix != (Fix{}) {
+		t.Errorf("got %+v, want no fix", st.Fix)
+	}
+}
+
+func same(a, b Fix) bool {
+	close := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.Valid == b.Valid && a.Quality == b.Quality && a.Satellites == b.Satellites &&
+		close(a.Latitude, b.Latitude) && close(a.Longitude, b.Longitude) && close(a.Elevation, b.Elevation) &&
+		a.Time.Equal(b.Time) && a.Received.Equal(b.Received)
+}
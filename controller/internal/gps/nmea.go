@@ -0,0 +1,177 @@
+package gps
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNotNMEA = errors.New("not an nmea sentence")
+
+// sentence is an nmea sentence with its talker (GP, GN, GL...) and
+// checksum stripped: the type (GGA, RMC...) and its fields.
+type sentence struct {
+	kind   string
+	fields []string
+}
+
+// parse checks and splits one line of nmea, such as
+//
+//	$GPRMC,081836.00,A,3751.65,S,14507.36,E,000.0,360.0,130998,011.3,E*4C
+func parse(line string) (sentence, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "$") {
+		return sentence{}, errNotNMEA
+	}
+
+	body, sum, ok := strings.Cut(line[1:], "*")
+	if !ok {
+		return sentence{}, fmt.Errorf("nmea sentence without a checksum: %q", line)
+	}
+
+	want, err := strconv.ParseUint(sum, 16, 8)
+	if err != nil {
+		return sentence{}, fmt.Errorf("bad nmea checksum in %q: %w", line, err)
+	}
+
+	var got byte
+	for i := range len(body) {
+		got ^= body[i]
+	}
+
+	if got != byte(want) {
+		return sentence{}, fmt.Errorf("nmea checksum %02X doesn't match %02X: %q", got, want, line)
+	}
+
+	fields := strings.Split(body, ",")
+	if len(fields[0]) != 5 {
+		return sentence{}, fmt.Errorf("bad nmea address in %q", line)
+	}
+
+	return sentence{kind: fields[0][2:], fields: fields[1:]}, nil
+}
+
+// gga is a fix: $--GGA,time,lat,N,lon,E,quality,satellites,hdop,altitude,M,...
+type gga struct {
+	quality    int
+	satellites int
+	latitude   float64
+	longitude  float64
+	altitude   float64
+}
+
+func (s sentence) gga() (g gga, err error) {
+	if len(s.fields) < 10 {
+		return g, fmt.Errorf("short GGA sentence (%d fields)", len(s.fields))
+	}
+
+	if g.quality, err = strconv.Atoi(s.fields[5]); err != nil {
+		return g, fmt.Errorf("bad GGA fix quality: %w", err)
+	}
+
+	if g.quality == 0 {
+		return g, nil
+	}
+
+	if g.satellites, err = strconv.Atoi(s.fields[6]); err != nil {
+		return g, fmt.Errorf("bad GGA satellites: %w", err)
+	}
+
+	if g.latitude, g.longitude, err = position(s.fields[1:5]); err != nil {
+		return g, err
+	}
+
+	if g.altitude, err = strconv.ParseFloat(s.fields[8], 64); err != nil {
+		return g, fmt.Errorf("bad GGA altitude: %w", err)
+	}
+
+	return g, nil
+}
+
+// rmc is the recommended minimum: $--RMC,time,status,lat,N,lon,E,speed,course,date,...
+type rmc struct {
+	valid     bool
+	time      time.Time
+	latitude  float64
+	longitude float64
+}
+
+func (s sentence) rmc() (r rmc, err error) {
+	if len(s.fields) < 9 {
+		return r, fmt.Errorf("short RMC sentence (%d fields)", len(s.fields))
+	}
+
+	if r.valid = s.fields[1] == "A"; !r.valid {
+		return r, nil
+	}
+
+	if r.time, err = utc(s.fields[8], s.fields[0]); err != nil {
+		return r, err
+	}
+
+	r.latitude, r.longitude, err = position(s.fields[2:6])
+	return r, err
+}
+
+// position reads latitude and longitude (ddmm.mmmm,N,dddmm.mmmm,E) in
+// degrees, east and north positive.
+func position(f []string) (lat, lon float64, err error) {
+	if lat, err = degrees(f[0], 2); err != nil {
+		return 0, 0, fmt.Errorf("bad latitude: %w", err)
+	}
+
+	if lon, err = degrees(f[2], 3); err != nil {
+		return 0, 0, fmt.Errorf("bad longitude: %w", err)
+	}
+
+	if f[1] == "S" {
+		lat = -lat
+	}
+
+	if f[3] == "W" {
+		lon = -lon
+	}
+
+	return lat, lon, nil
+}
+
+// degrees reads the degrees and minutes of s, which has n digits of
+// degrees.
+func degrees(s string, n int) (float64, error) {
+	if len(s) < n+2 {
+		return 0, fmt.Errorf("%q is too short", s)
+	}
+
+	d, err := strconv.Atoi(s[:n])
+	if err != nil {
+		return 0, err
+	}
+
+	m, err := strconv.ParseFloat(s[n:], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(d) + m/60, nil
+}
+
+// utc reads an RMC date (ddmmyy) and time (hhmmss.ss).
+func utc(date, clock string) (time.Time, error) {
+	whole, frac, ok := strings.Cut(clock, ".")
+	t, err := time.Parse("020106150405", date+whole)
+	if err != nil {
+		return t, fmt.Errorf("bad RMC time: %w", err)
+	}
+
+	if ok {
+		f, err := strconv.ParseFloat("0."+frac, 64)
+		if err != nil {
+			return t, fmt.Errorf("bad RMC time: %w", err)
+		}
+		t = t.Add(time.Duration(f * float64(time.Second)))
+	}
+
+	return t, nil
+}
@@ -0,0 +1,111 @@
+package gps
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		line   string
+		kind   string
+		fields int
+		err    string
+	}{
+		{line: "$GPRMC,081836.00,A,3751.65,S,14507.36,E,000.0,360.0,130998,011.3,E*4C", kind: "RMC", fields: 11},
+		{line: "$GNGSV,1,1,01,02,45,120,38*5D\r\n", kind: "GSV", fields: 7},
+		{line: "$GNGSV,1,1,01,02,45,120,38*5d", kind: "GSV", fields: 7},
+		{line: `{"class":"TPV"}`, err: errNotNMEA.Error()},
+		{line: "", err: errNotNMEA.Error()},
+		{line: "$GNGSV,1,1,01,02,45,120,38", err: "without a checksum"},
+		{line: "$GNGSV,1,1,01,02,45,120,38*5C", err: "doesn't match"},
+		{line: "$GNGSV,1,1,01,02,45,120,38*ZZ", err: "bad nmea checksum"},
+		{line: "$GSV,1*5F", err: "bad nmea address"},
+	}
+
+	for _, tc := range testCases {
+		s, err := parse(tc.line)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("parse(%q) returned %v, want an error with %q", tc.line, err, tc.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parse(%q): %s", tc.line, err)
+			continue
+		}
+
+		if s.kind != tc.kind || len(s.fields) != tc.fields {
+			t.Errorf("parse(%q) is %s with %d fields, want %s with %d", tc.line, s.kind, len(s.fields), tc.kind, tc.fields)
+		}
+	}
+
+	if _, err := parse("hello"); !errors.Is(err, errNotNMEA) {
+		t.Errorf("parse of a line that isn't nmea returned %v", err)
+	}
+}
+
+func TestSentences(t *testing.T) {
+	testCases := []struct {
+		line string
+		err  string
+	}{
+		{line: "$GPGGA,041500.00,,,,,0,00,99.99,,,,,,*66"},
+		{line: "$GNGGA,041502.00,4000.1234,N,10515.6000,W,1,08,1.01,1655.3,M,-21.4,M,,*42"},
+		{line: "$GNGGA,041502.00,4000.1234,N*28", err: "short GGA"},
+		{line: "$GNGGA,041502.00,4000.1234,N,10515.6000,W,x,08,1.01,1655.3,M,-21.4,M,,*0B", err: "bad GGA fix quality"},
+		{line: "$GNGGA,041502.00,40,N,10515.6000,W,1,08,1.01,1655.3,M,-21.4,M,,*68", err: "bad latitude"},
+		{line: "$GPRMC,041500.00,V,,,,,,,190826,,,N*79"},
+		{line: "$GNRMC,041502.00,A,4000.1234,N,10515.6000,W,0.012,,190826,,,A*7F"},
+		{line: "$GNRMC,041502.00,A,4000.1234*3A", err: "short RMC"},
+		{line: "$GNRMC,041502.00,A,4000.1234,N,10515.6000,W,0.012,,193026,,,A*74", err: "bad RMC time"},
+	}
+
+	for _, tc := range testCases {
+		s, err := parse(tc.line)
+		if err != nil {
+			t.Fatalf("parse(%q): %s", tc.line, err)
+		}
+
+		switch s.kind {
+		case "GGA":
+			_, err = s.gga()
+		case "RMC":
+			_, err = s.rmc()
+		}
+
+		if tc.err == "" && err != nil {
+			t.Errorf("%q: %s", tc.line, err)
+		}
+
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%q returned %v, want an error with %q", tc.line, err, tc.err)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	testCases := []struct {
+		fields   []string
+		lat, lon float64
+	}{
+		{[]string{"3751.65", "S", "14507.36", "E"}, -(37 + 51.65/60), 145 + 7.36/60},
+		{[]string{"4000.1234", "N", "10515.6000", "W"}, 40 + 0.1234/60, -(105 + 15.6/60)},
+		{[]string{"0000.0000", "N", "00000.0000", "E"}, 0, 0},
+	}
+
+	for _, tc := range testCases {
+		lat, lon, err := position(tc.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if math.Abs(lat-tc.lat) > 1e-12 || math.Abs(lon-tc.lon) > 1e-12 {
+			t.Errorf("position(%q) = %f, %f, want %f, %f", tc.fields, lat, lon, tc.lat, tc.lon)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package handset reads a hand controller of push buttons wired to gpio
+// lines: direction buttons move the axes for as long as they're held and
+// speed buttons pick how fast.
+package handset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/config"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+var (
+	// directions are the axis each direction button moves and which
+	// way: west is the way tracking turns ra.
+	directions = map[string]struct {
+		axis string
+		sign float64
+	}{
+		"north": {"dec", 1},
+		"south": {"dec", -1},
+		"east":  {"ra", -1},
+		"west":  {"ra", 1},
+	}
+
+	// speeds are the jog presets the speed button steps through.
+	speeds = []string{"guide", "center", "find", "max"}
+)
+
+type (
+	// Mount is the part of mount.Mount a handset works.
+	Mount interface {
+		Move(axis string, rpm float64) error
+		Preset(axis, speed string) (float64, error)
+	}
+
+	Handset struct {
+		mount Mount
+		lines []io.Closer
+
+		lock sync.Mutex
+		// speed is the preset the axes move at, held the direction
+		// buttons that are down and moving which way each axis was
+		// last sent.
+		speed  string
+		held   map[string]bool
+		moving map[string]float64
+	}
+
+	// request asks for the line at pin, calling f with its (debounced)
+	// edges: gpiocdev.RequestLine, or a fake line source.
+	request func(pin int, f func(gpiocdev.LineEvent)) (io.Closer, error)
+)
+
+// New returns a handset that works m with the buttons cfg puts on chip.
+func New(cfg config.Handset, chip string, m Mount) (*Handset, error) {
+	debounce := time.Duration(cfg.Debounce) * time.Millisecond
+	return newHandset(cfg, m, func(pin int, f func(gpiocdev.LineEvent)) (io.Closer, error) {
+		return gpiocdev.RequestLine(chip, pin, gpiocdev.WithPullUp, gpiocdev.AsActiveLow, gpiocdev.WithBothEdges, gpiocdev.WithDebounce(debounce), gpiocdev.WithEventHandler(f))
+	})
+}
+
+func newHandset(cfg config.Handset, m Mount, req request) (*Handset, error) {
+	h := &Handset{
+		mount:  m,
+		speed:  "center",
+		held:   map[string]bool{},
+		moving: map[string]float64{},
+	}
+
+	for action, pin := range cfg.Pins {
+		if pin < 0 {
+			continue
+		}
+
+		l, err := req(pin, h.edge(action))
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("unable to request the %s button (line %d): %w", action, pin, err), h.Close())
+		}
+		h.lines = append(h.lines, l)
+	}
+
+	return h, nil
+}
+
+// Close releases the button lines and stops any axis that was moving.
+func (h *Handset) Close() error {
+	var errs []error
+	for _, l := range h.lines {
+		errs = append(errs, l.Close())
+	}
+
+	h.lock.Lock()
+	clear(h.held)
+	h.update(false)
+	h.lock.Unlock()
+
+	return errors.Join(errs...)
+}
+
+func (h *Handset) edge(action string) func(gpiocdev.LineEvent) {
+	return func(evt gpiocdev.LineEvent) {
+		h.press(action, evt.Type == gpiocdev.LineEventRisingEdge)
+	}
+}
+
+// press handles a button going down or (if !down) coming back up.
+func (h *Handset) press(action string, down bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := directions[action]; ok {
+		h.held[action] = down
+		h.update(false)
+		return
+	}
+
+	if !down {
+		return
+	}
+
+	if action == "speed" {
+		for i, s := range speeds {
+			if s == h.speed {
+				action = speeds[(i+1)%len(speeds)]
+				break
+			}
+		}
+	}
+
+	log.Printf("handset speed %s", action)
+	h.speed = action
+	h.update(true)
+}
+
+// update sends each axis the direction its held buttons add up to (a
+// button and its opposite cancel out) if it's changed, or if it's moving
+// and again is set.
+func (h *Handset) update(again bool) {
+	for _, axis := range []string{"ra", "dec"} {
+		var dir float64
+		for name, d := range directions {
+			if d.axis == axis && h.held[name] {
+				dir += d.sign
+			}
+		}
+
+		if dir == h.moving[axis] && (dir == 0 || !again) {
+			continue
+		}
+
+		h.moving[axis] = dir
+		rpm, err := h.mount.Preset(axis, h.speed)
+		if err == nil {
+			err = h.mount.Move(axis, dir*rpm)
+		}
+
+		if err != nil {
+			log.Printf("handset unable to move %s: %s", axis, err)
+		}
+	}
+}
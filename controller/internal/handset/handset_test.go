@@ -0,0 +1,182 @@
+package handset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/cswank/geq/controller/internal/config"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// fakeMount records what a handset tells it to do.  Each speed preset is
+// ten times the one before it.
+type fakeMount struct {
+	calls []string
+}
+
+var presets = map[string]float64{"guide": 1, "center": 10, "find": 100, "max": 1000}
+
+func (f *fakeMount) Move(axis string, rpm float64) error {
+	f.calls = append(f.calls, fmt.Sprintf("move %s %.0f", axis, rpm))
+	return nil
+}
+
+func (f *fakeMount) Preset(axis, speed string) (float64, error) {
+	return presets[speed], nil
+}
+
+// fakeLines is a line source whose buttons are pressed by the test.
+type fakeLines struct {
+	handlers map[int]func(gpiocdev.LineEvent)
+	closed   []int
+	fail     int
+}
+
+type fakeLine struct {
+	pin   int
+	lines *fakeLines
+}
+
+func (l fakeLine) Close() error {
+	l.lines.closed = append(l.lines.closed, l.pin)
+	return nil
+}
+
+func (f *fakeLines) request(pin int, h func(gpiocdev.LineEvent)) (io.Closer, error) {
+	if pin == f.fail {
+		return nil, errors.New("device or resource busy")
+	}
+
+	f.handlers[pin] = h
+	return fakeLine{pin: pin, lines: f}, nil
+}
+
+// pins are the lines of the buttons in the tests.
+var pins = map[string]int{
+	"north": 1, "south": 2, "east": 3, "west": 4,
+	"guide": 5, "center": 6, "find": 7, "max": 8,
+	"speed": 9,
+}
+
+func newFake(t *testing.T) (*Handset, *fakeMount, *fakeLines) {
+	t.Helper()
+
+	m := &fakeMount{}
+	lines := &fakeLines{handlers: map[int]func(gpiocdev.LineEvent){}, fail: -1}
+	h, err := newHandset(config.Handset{Pins: pins}, m, lines.request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, m, lines
+}
+
+// press is a button going down, or (if its name starts with ^) coming
+// back up.
+func (f *fakeLines) press(names ...string) {
+	for _, name := range names {
+		typ := gpiocdev.LineEventRisingEdge
+		if name[0] == '^' {
+			name, typ = name[1:], gpiocdev.LineEventFallingEdge
+		}
+		f.handlers[pins[name]](gpiocdev.LineEvent{Offset: pins[name], Type: typ})
+	}
+}
+
+func TestPress(t *testing.T) {
+	testCases := []struct {
+		name    string
+		presses []string
+		want    []string
+	}{
+		{
+			name:    "hold",
+			presses: []string{"west", "^west", "north", "^north"},
+			want:    []string{"move ra 10", "move ra 0", "move dec 10", "move dec 0"},
+		},
+		{
+			name:    "directions",
+			presses: []string{"east", "south", "^east", "^south"},
+			want:    []string{"move ra -10", "move dec -10", "move ra 0", "move dec 0"},
+		},
+		{
+			name:    "opposites cancel",
+			presses: []string{"east", "west", "^east", "^west"},
+			want:    []string{"move ra -10", "move ra 0", "move ra 10", "move ra 0"},
+		},
+		{
+			name:    "preset while held",
+			presses: []string{"north", "max", "^max", "guide", "^north"},
+			want:    []string{"move dec 10", "move dec 1000", "move dec 1", "move dec 0"},
+		},
+		{
+			name:    "preset then hold",
+			presses: []string{"find", "^find", "west", "^west"},
+			want:    []string{"move ra 100", "move ra 0"},
+		},
+		{
+			name:    "speed steps through the presets",
+			presses: []string{"speed", "speed", "speed", "west", "speed", "^west"},
+			want:    []string{"move ra 1", "move ra 10", "move ra 0"},
+		},
+		{
+			name:    "bounce",
+			presses: []string{"^west", "west", "west"},
+			want:    []string{"move ra 10"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, m, lines := newFake(t)
+			lines.press(tc.presses...)
+			if !slices.Equal(m.calls, tc.want) {
+				t.Errorf("got %q, want %q", m.calls, tc.want)
+			}
+		})
+	}
+}
+
+// TestClose checks that closing a handset releases its lines and stops
+// what its buttons were moving.
+func TestClose(t *testing.T) {
+	h, m, lines := newFake(t)
+	lines.press("west", "north")
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"move ra 10", "move dec 10", "move ra 0", "move dec 0"}
+	if !slices.Equal(m.calls, want) {
+		t.Errorf("got %q, want %q", m.calls, want)
+	}
+
+	if len(lines.closed) != len(pins) {
+		t.Errorf("%d lines closed, want %d", len(lines.closed), len(pins))
+	}
+}
+
+func TestNewHandset(t *testing.T) {
+	m := &fakeMount{}
+	lines := &fakeLines{handlers: map[int]func(gpiocdev.LineEvent){}, fail: -1}
+	if _, err := newHandset(config.Handset{Pins: map[string]int{"north": 1, "south": -1}}, m, lines.request); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := lines.handlers[-1]; ok || len(lines.handlers) != 1 {
+		t.Errorf("requested lines %v, want only 1", lines.handlers)
+	}
+
+	// a line that can't be had releases the ones that were
+	lines = &fakeLines{handlers: map[int]func(gpiocdev.LineEvent){}, fail: 3}
+	_, err := newHandset(config.Handset{Pins: pins}, m, lines.request)
+	if err == nil {
+		t.Fatal("no error for a line that can't be requested")
+	}
+
+	if len(lines.closed) != len(lines.handlers) {
+		t.Errorf("%d of %d requested lines closed", len(lines.closed), len(lines.handlers))
+	}
+}
@@ -0,0 +1,334 @@
+// Package logbook writes observations out for other logging tools, as
+// OpenAstronomyLog (OAL) 2.1 xml or as csv.
+package logbook
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/repo"
+)
+
+// Entry is an observation and the object it's of.
+type Entry struct {
+	repo.Observation
+	Target repo.Object
+}
+
+// CSV writes the entries with a header row.  Times are rfc3339 in utc
+// and what isn't known is empty.
+func CSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "object", "name", "type", "constellation", "time", "latitude", "longitude", "elevation", "equipment", "aperture", "focal_length", "seeing", "transparency", "rating", "notes"})
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.Object,
+			str(e.Target.Name),
+			e.Target.Type,
+			str(e.Target.Constellation),
+			e.Time.UTC().Format(time.RFC3339),
+			num(&e.Latitude),
+			num(&e.Longitude),
+			num(&e.Elevation),
+			e.Equipment,
+			num(e.Aperture),
+			num(e.FocalLength),
+			integer(e.Seeing),
+			integer(e.Transparency),
+			integer(e.Rating),
+			e.Notes,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type (
+	oalLog struct {
+		XMLName        xml.Name         `xml:"oal:observations"`
+		Version        string           `xml:"version,attr"`
+		OAL            string           `xml:"xmlns:oal,attr"`
+		XSI            string           `xml:"xmlns:xsi,attr"`
+		SchemaLocation string           `xml:"xsi:schemaLocation,attr"`
+		Observers      oalObservers     `xml:"observers"`
+		Sites          oalSites         `xml:"sites"`
+		Sessions       struct{}         `xml:"sessions"`
+		Targets        oalTargets       `xml:"targets"`
+		Scopes         oalScopes        `xml:"scopes"`
+		Eyepieces      struct{}         `xml:"eyepieces"`
+		Lenses         struct{}         `xml:"lenses"`
+		Filters        struct{}         `xml:"filters"`
+		Imagers        struct{}         `xml:"imagers"`
+		Observations   []oalObservation `xml:"observation"`
+	}
+
+	oalObservers struct {
+		Observer []oalObserver `xml:"observer"`
+	}
+
+	oalObserver struct {
+		ID      string `xml:"id,attr"`
+		Name    string `xml:"name"`
+		Surname string `xml:"surname"`
+	}
+
+	oalSites struct {
+		Site []oalSite `xml:"site"`
+	}
+
+	// oalSite's timezone is minutes from utc, which every time is in.
+	oalSite struct {
+		ID        string   `xml:"id,attr"`
+		Name      string   `xml:"name"`
+		Longitude oalAngle `xml:"longitude"`
+		Latitude  oalAngle `xml:"latitude"`
+		Elevation float64  `xml:"elevation"`
+		Timezone  int      `xml:"timezone"`
+	}
+
+	oalAngle struct {
+		Unit  string  `xml:"unit,attr"`
+		Value float64 `xml:",chardata"`
+	}
+
+	oalTargets struct {
+		Target []oalTarget `xml:"target"`
+	}
+
+	oalTarget struct {
+		ID            string      `xml:"id,attr"`
+		Type          string      `xml:"xsi:type,attr"`
+		Datasource    string      `xml:"datasource"`
+		Name          string      `xml:"name"`
+		Alias         []string    `xml:"alias"`
+		Position      oalPosition `xml:"position"`
+		Constellation string      `xml:"constellation,omitempty"`
+	}
+
+	oalPosition struct {
+		RA  oalAngle `xml:"ra"`
+		Dec oalAngle `xml:"dec"`
+	}
+
+	oalScopes struct {
+		Scope []oalScope `xml:"scope"`
+	}
+
+	oalScope struct {
+		ID          string  `xml:"id,attr"`
+		Type        string  `xml:"xsi:type,attr"`
+		Model       string  `xml:"model"`
+		Aperture    float64 `xml:"aperture"`
+		FocalLength float64 `xml:"focalLength"`
+	}
+
+	oalObservation struct {
+		ID       string    `xml:"id,attr"`
+		Observer string    `xml:"observer"`
+		Site     string    `xml:"site"`
+		Target   string    `xml:"target"`
+		Begin    string    `xml:"begin"`
+		Seeing   *int      `xml:"seeing,omitempty"`
+		Scope    string    `xml:"scope,omitempty"`
+		Result   oalResult `xml:"result"`
+	}
+
+	// oalResult's rating is OAL's scale of how easily the object was
+	// seen, which isn't recorded (99 is unknown).
+	oalResult struct {
+		Type        string `xml:"xsi:type,attr"`
+		Lang        string `xml:"lang,attr"`
+		Description string `xml:"description"`
+		Rating      int    `xml:"rating"`
+	}
+)
+
+// oalTypes are the OAL target types of the catalog's types; anything
+// else, stars included, is non-aligned (deepSkyNA).
+var oalTypes = map[string]string{
+	"G":      "oal:deepSkyGX",
+	"GPair":  "oal:deepSkyCG",
+	"GTrpl":  "oal:deepSkyCG",
+	"GGroup": "oal:deepSkyCG",
+	"OCl":    "oal:deepSkyOC",
+	"GCl":    "oal:deepSkyGC",
+	"PN":     "oal:deepSkyPN",
+	"Neb":    "oal:deepSkyGN",
+	"HII":    "oal:deepSkyGN",
+	"EmN":    "oal:deepSkyGN",
+	"RfN":    "oal:deepSkyGN",
+	"SNR":    "oal:deepSkyGN",
+	"Cl+N":   "oal:deepSkyGN",
+	"DrkN":   "oal:deepSkyDN",
+	"**":     "oal:deepSkyDS",
+	"*Ass":   "oal:deepSkyAS",
+}
+
+// OAL writes the entries, seen by observer, as an OpenAstronomyLog.
+// Entries at the same site, of the same object or with the same
+// telescope share a site, target or scope.  A telescope is only a scope
+// if its aperture and focal length are known, which OAL needs; the
+// description has the transparency and rating, which OAL has no place
+// for, and the equipment if it isn't a scope.
+func OAL(w io.Writer, observer string, entries []Entry) error {
+	name, surname := observer, ""
+	if i := strings.LastIndex(observer, " "); i >= 0 {
+		name, surname = observer[:i], observer[i+1:]
+	}
+
+	l := oalLog{
+		Version:        "2.1",
+		OAL:            "http://groups.google.com/group/openastronomylog",
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://groups.google.com/group/openastronomylog oal21.xsd",
+		Observers:      oalObservers{Observer: []oalObserver{{ID: "observer_1", Name: name, Surname: surname}}},
+	}
+
+	sites, targets, scopes := map[[3]float64]string{}, map[string]string{}, map[string]string{}
+	for i, e := range entries {
+		site := [3]float64{e.Latitude, e.Longitude, e.Elevation}
+		if _, ok := sites[site]; !ok {
+			sites[site] = fmt.Sprintf("site_%d", len(sites)+1)
+			l.Sites.Site = append(l.Sites.Site, oalSite{
+				ID:        sites[site],
+				Name:      fmt.Sprintf("%.4f, %.4f", e.Latitude, e.Longitude),
+				Longitude: oalAngle{Unit: "deg", Value: e.Longitude},
+				Latitude:  oalAngle{Unit: "deg", Value: e.Latitude},
+				Elevation: e.Elevation,
+			})
+		}
+
+		if _, ok := targets[e.Object]; !ok {
+			targets[e.Object] = fmt.Sprintf("target_%d", len(targets)+1)
+			l.Targets.Target = append(l.Targets.Target, target(targets[e.Object], e))
+		}
+
+		o := oalObservation{
+			ID:       fmt.Sprintf("observation_%d", i+1),
+			Observer: "observer_1",
+			Site:     sites[site],
+			Target:   targets[e.Object],
+			Begin:    e.Time.UTC().Format(time.RFC3339),
+			Seeing:   e.Seeing,
+			Result: oalResult{
+				Type:        "oal:findingsDeepSkyType",
+				Lang:        "en",
+				Description: description(e),
+				Rating:      99,
+			},
+		}
+
+		if e.Aperture != nil && e.FocalLength != nil {
+			scope := fmt.Sprintf("%s\x00%g\x00%g", e.Equipment, *e.Aperture, *e.FocalLength)
+			if _, ok := scopes[scope]; !ok {
+				scopes[scope] = fmt.Sprintf("scope_%d", len(scopes)+1)
+				model := e.Equipment
+				if model == "" {
+					model = fmt.Sprintf("%gmm f/%.1f", *e.Aperture, *e.FocalLength / *e.Aperture)
+				}
+
+				l.Scopes.Scope = append(l.Scopes.Scope, oalScope{
+					ID:          scopes[scope],
+					Type:        "oal:scopeType",
+					Model:       model,
+					Aperture:    *e.Aperture,
+					FocalLength: *e.FocalLength,
+				})
+			}
+			o.Scope = scopes[scope]
+		}
+
+		l.Observations = append(l.Observations, o)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(l); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func target(id string, e Entry) oalTarget {
+	t := oalTarget{
+		ID:         id,
+		Type:       oalTypes[e.Target.Type],
+		Datasource: "geq",
+		Name:       e.Object,
+		Position: oalPosition{
+			RA:  oalAngle{Unit: "rad", Value: e.Target.RARadians},
+			Dec: oalAngle{Unit: "rad", Value: e.Target.DecRadians},
+		},
+		Constellation: str(e.Target.Constellation),
+	}
+
+	if t.Type == "" {
+		t.Type = "oal:deepSkyNA"
+	}
+
+	if e.Target.M != nil {
+		t.Alias = append(t.Alias, fmt.Sprintf("M %d", *e.Target.M))
+	}
+
+	if e.Target.Name != nil && *e.Target.Name != "" {
+		t.Alias = append(t.Alias, *e.Target.Name)
+	}
+
+	return t
+}
+
+// description is the notes with what OAL has no place for after them.
+func description(e Entry) string {
+	lines := []string{}
+	if e.Notes != "" {
+		lines = append(lines, e.Notes)
+	}
+
+	if e.Equipment != "" && (e.Aperture == nil || e.FocalLength == nil) {
+		lines = append(lines, "Equipment: "+e.Equipment)
+	}
+
+	if e.Transparency != nil {
+		lines = append(lines, fmt.Sprintf("Transparency: %d/5", *e.Transparency))
+	}
+
+	if e.Rating != nil {
+		lines = append(lines, fmt.Sprintf("Rating: %d/5", *e.Rating))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func num(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func integer(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.Itoa(*i)
+}
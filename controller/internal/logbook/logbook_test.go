@@ -0,0 +1,180 @@
+package logbook
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/repo"
+)
+
+func entries() []Entry {
+	f := func(x float64) *float64 { return &x }
+	i := func(x int) *int { return &x }
+	s := func(x string) *string { return &x }
+	m31 := repo.Object{ID: "NGC224", Type: "G", Constellation: s("And"), Name: s("Andromeda Galaxy"), M: i(31), RARadians: 0.186, DecRadians: 0.720}
+	m42 := repo.Object{ID: "NGC1976", Type: "Cl+N", Constellation: s("Ori"), Name: s("Great Orion Nebula"), M: i(42), RARadians: 1.464, DecRadians: -0.095}
+	at := time.Date(2024, 1, 10, 3, 0, 0, 0, time.UTC)
+
+	return []Entry{
+		{
+			Observation: repo.Observation{ID: 1, Object: "M31", Time: at, Latitude: 40, Longitude: -105, Elevation: 1600, Equipment: "8in dob", Aperture: f(200), FocalLength: f(1200), Seeing: i(3), Notes: "faint, large\n\"core\" bright"},
+			Target:      m31,
+		},
+		{
+			Observation: repo.Observation{ID: 2, Object: "M42", Time: at.Add(time.Hour), Latitude: 40, Longitude: -105, Elevation: 1600, Equipment: "8in dob", Aperture: f(200), FocalLength: f(1200), Rating: i(5)},
+			Target:      m42,
+		},
+		{
+			Observation: repo.Observation{ID: 3, Object: "M31", Time: at.Add(24 * time.Hour), Latitude: 39, Longitude: -104, Equipment: "binoculars", Aperture: f(50), Transparency: i(4)},
+			Target:      m31,
+		},
+	}
+}
+
+// TestOAL checks that the log's elements are in the order OAL 2.1's
+// schema has them, and that observations share sites, targets and
+// scopes.
+func TestOAL(t *testing.T) {
+	var buf bytes.Buffer
+	if err := OAL(&buf, "Charles Messier", entries()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the names of the elements in the root and the first observation
+	var root, obs []string
+	dec := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for depth, n := 0, 0; ; {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 2 && (len(root) == 0 || root[len(root)-1] != el.Name.Local):
+				root = append(root, el.Name.Local)
+			case depth == 2:
+				n++
+			case depth == 3 && root[len(root)-1] == "observation" && n == 0:
+				obs = append(obs, el.Name.Local)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	want := []string{"observers", "sites", "sessions", "targets", "scopes", "eyepieces", "lenses", "filters", "imagers", "observation"}
+	if !slices.Equal(root, want) {
+		t.Errorf("the log has %v, want %v", root, want)
+	}
+
+	want = []string{"observer", "site", "target", "begin", "seeing", "scope", "result"}
+	if !slices.Equal(obs, want) {
+		t.Errorf("an observation has %v, want %v", obs, want)
+	}
+
+	var l struct {
+		Version string `xml:"version,attr"`
+		Sites   []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"sites>site"`
+		Targets []struct {
+			ID    string   `xml:"id,attr"`
+			Type  string   `xml:"type,attr"`
+			Alias []string `xml:"alias"`
+		} `xml:"targets>target"`
+		Scopes []struct {
+			ID          string  `xml:"id,attr"`
+			Model       string  `xml:"model"`
+			FocalLength float64 `xml:"focalLength"`
+		} `xml:"scopes>scope"`
+		Observations []struct {
+			Site        string `xml:"site"`
+			Target      string `xml:"target"`
+			Scope       string `xml:"scope"`
+			Description string `xml:"result>description"`
+		} `xml:"observation"`
+	}
+
+	if err := xml.Unmarshal(buf.Bytes(), &l); err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Version != "2.1" || len(l.Sites) != 2 || len(l.Targets) != 2 || len(l.Scopes) != 1 || len(l.Observations) != 3 {
+		t.Fatalf("got version %s, %d sites, %d targets, %d scopes and %d observations, want 2.1, 2, 2, 1 and 3", l.Version, len(l.Sites), len(l.Targets), len(l.Scopes), len(l.Observations))
+	}
+
+	if tg := l.Targets[0]; tg.Type != "oal:deepSkyGX" || !slices.Equal(tg.Alias, []string{"M 31", "Andromeda Galaxy"}) {
+		t.Errorf("M31 is a %s target with aliases %v", tg.Type, tg.Alias)
+	}
+
+	if sc := l.Scopes[0]; sc.ID != "scope_1" || sc.Model != "8in dob" || sc.FocalLength != 1200 {
+		t.Errorf("got scope %+v", sc)
+	}
+
+	for i, want := range [][3]string{
+		{"site_1", "target_1", "scope_1"},
+		{"site_1", "target_2", "scope_1"},
+		// no focal length, so no scope
+		{"site_2", "target_1", ""},
+	} {
+		o := l.Observations[i]
+		if got := [3]string{o.Site, o.Target, o.Scope}; got != want {
+			t.Errorf("observation %d has site, target and scope %q, want %q", i+1, got, want)
+		}
+	}
+
+	if d := l.Observations[2].Description; d != "Equipment: binoculars\nTransparency: 4/5" {
+		t.Errorf("the observation without a scope is described as %q", d)
+	}
+}
+
+// TestCSV checks that notes with commas, quotes and newlines are quoted
+// so that they read back as they were written.
+func TestCSV(t *testing.T) {
+	es := entries()
+	var buf bytes.Buffer
+	if err := CSV(&buf, es); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"faint, large`+"\n"+`""core"" bright"`) {
+		t.Errorf("the notes aren't quoted:\n%s", &buf)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != len(es)+1 {
+		t.Fatalf("got %d rows, want a header and %d entries", len(rows), len(es))
+	}
+
+	header := rows[0]
+	for i, e := range es {
+		row := rows[i+1]
+		if len(row) != len(header) {
+			t.Fatalf("row %d has %d fields, want %d", i+1, len(row), len(header))
+		}
+
+		if notes := row[len(row)-1]; notes != e.Notes {
+			t.Errorf("row %d has notes %q, want %q", i+1, notes, e.Notes)
+		}
+	}
+
+	if got := strings.Join(rows[3], ","); got != "3,M31,Andromeda Galaxy,G,And,2024-01-11T03:00:00Z,39,-104,0,binoculars,50,,,4,," {
+		t.Errorf("got row %s", got)
+	}
+}
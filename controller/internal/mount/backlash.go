@@ -0,0 +1,250 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	// approachMargin is how far (index pulses, on top of the backlash) a
+	// goto goes past its target when it has to come back to it in the
+	// axis' approach direction.  It only has to be enough that the way
+	// back is worth a correction slew; a pulse of dec is almost half a
+	// degree.
+	approachMargin = 2 * minCorrection
+
+	// backlashPreload is how long a backlash measurement drives the axis
+	// forward at slow speed to load the gears before reversing it at
+	// backlashRate times slow speed.
+	backlashPreload = 2 * time.Second
+	backlashRate    = 0.25
+)
+
+type (
+	// backlash is the slack in an axis' gears and which way they're
+	// loaded.
+	backlash struct {
+		steps    uint16
+		approach float64
+		// last is the direction the axis last turned, 0 if that isn't
+		// known.
+		last float64
+	}
+
+	// backlashMeasure is a backlash measurement in progress: the axis is
+	// preloaded, then reversed slowly until the user sees the star move.
+	// Every trial is a sample.
+	backlashMeasure struct {
+		axis    string
+		trial   *backlashTrial
+		samples []float64
+	}
+
+	backlashTrial struct {
+		// jog is the axis' jog.n when the trial started; anything else
+		// that moves the axis changes it and spoils the trial.
+		jog       int
+		reversing time.Time
+		rpm       float64
+	}
+
+	// BacklashMeasurement is what the api shows of a backlash
+	// measurement, in index pulses.
+	BacklashMeasurement struct {
+		Axis      string    `json:"axis"`
+		Measuring bool      `json:"measuring"`
+		Samples   []float64 `json:"samples"`
+		Mean      float64   `json:"mean"`
+	}
+)
+
+// plan adds what it takes to take up the slack to a slew of steps in
+// direction, and how far past the target the slew has to go (overshoot)
+// so that a correction slew can finish it in the approach direction.
+func (b *backlash) plan(steps uint16, direction float64) (total, overshoot uint16) {
+	if steps == 0 {
+		return 0, 0
+	}
+
+	if b.approach != 0 && direction != b.approach {
+		overshoot = b.steps + approachMargin
+	}
+
+	total = steps + overshoot
+	if b.last != 0 && direction != b.last {
+		total += b.steps
+	}
+
+	b.last = direction
+	return total, overshoot
+}
+
+// manual runs an axis at rpm for Move and Jog.  If that reverses the
+// axis it's run at slew speed until the slack is taken up first, which
+// takes as long as the returned duration.
+func (m *Mount) manual(a axisParts, rpm float64) (time.Duration, error) {
+	now := time.Now()
+	a.jog.move(0, now)
+	if rpm == 0 {
+		return 0, a.motor.Move(0)
+	}
+
+	dir := math.Copysign(1, rpm)
+	reversing := a.backlash.last != 0 && dir != a.backlash.last
+	a.backlash.last = dir
+	if !reversing || a.backlash.steps == 0 {
+		a.jog.move(rpm, now)
+		return 0, a.motor.Move(rpm)
+	}
+
+	n := a.jog.n
+	d := time.Duration(float64(a.backlash.steps) / a.mech.pulsesPerSecond(a.mech.slewSpeed) * float64(time.Second))
+	time.AfterFunc(d, func() {
+		select {
+		case m.cmds <- func() {
+			if a.jog.n != n {
+				return
+			}
+			a.jog.move(rpm, time.Now())
+			if err := a.motor.Move(rpm); err != nil {
+				log.Printf("error moving %s motor: %s", a.name, err)
+			}
+		}:
+		case <-m.done:
+		}
+	})
+
+	return d, a.motor.Move(dir * a.mech.slewSpeed)
+}
+
+// MeasureBacklash starts a backlash measurement trial on axis: it drives
+// the axis forward for a moment to load the gears, then reverses it
+// slowly.  Call BacklashMoved the moment the star (or, better, a distant
+// terrestrial target with the drive stopped) starts to move.  Repeat a
+// few times and ApplyBacklash.
+func (m *Mount) MeasureBacklash(axis string) (err error) {
+	m.do(func() {
+		a, ok := m.parts(axis)
+		if !ok {
+			err = fmt.Errorf("%w %q", ErrUnknownAxis, axis)
+			return
+		}
+
+		if m.ra.slewing() || m.dec.slewing() {
+			err = fmt.Errorf("refusing to measure %s backlash while the mount is slewing", axis)
+			return
+		}
+
+		if m.measure.axis != axis {
+			m.measure = backlashMeasure{axis: axis}
+		}
+
+		m.goal = nil
+		*a.state = Ready
+		a.jog.stop(time.Now())
+		t := &backlashTrial{jog: a.jog.n, rpm: -a.mech.slowSpeed * backlashRate}
+		m.measure.trial = t
+		a.backlash.last = 1
+		if err = a.motor.Move(a.mech.slowSpeed); err != nil {
+			return
+		}
+
+		time.AfterFunc(backlashPreload, func() {
+			select {
+			case m.cmds <- func() { m.reverseTrial(a, t) }:
+			case <-m.done:
+			}
+		})
+	})
+	return err
+}
+
+func (m *Mount) reverseTrial(a axisParts, t *backlashTrial) {
+	if m.measure.trial != t || a.jog.n != t.jog {
+		return
+	}
+
+	t.reversing = time.Now()
+	if err := a.motor.Move(t.rpm); err != nil {
+		log.Printf("error reversing %s motor: %s", a.name, err)
+	}
+}
+
+// BacklashMoved ends a trial and returns the slack it measured, in index
+// pulses.
+func (m *Mount) BacklashMoved() (steps float64, err error) {
+	m.do(func() {
+		t := m.measure.trial
+		if t == nil {
+			err = errors.New("no backlash measurement in progress")
+			return
+		}
+
+		a, _ := m.parts(m.measure.axis)
+		m.measure.trial = nil
+		if err = a.motor.Move(0); err != nil {
+			return
+		}
+		*a.state = Idle
+		a.backlash.last = -1
+
+		if a.jog.n != t.jog {
+			err = errors.New("the backlash measurement was interrupted")
+			return
+		}
+
+		if t.reversing.IsZero() {
+			err = errors.New("the axis hadn't started reversing yet")
+			return
+		}
+
+		steps = a.mech.pulsesPerSecond(t.rpm) * time.Since(t.reversing).Seconds()
+		m.measure.samples = append(m.measure.samples, steps)
+	})
+	return steps, err
+}
+
+func (m *Mount) Backlash() (b BacklashMeasurement) {
+	m.do(func() {
+		b = BacklashMeasurement{
+			Axis:      m.measure.axis,
+			Measuring: m.measure.trial != nil,
+			Samples:   append([]float64{}, m.measure.samples...),
+			Mean:      m.measure.mean(),
+		}
+	})
+	return b
+}
+
+// ApplyBacklash sets the measured backlash on its axis and ends the
+// measurement.  It returns the axis and the backlash so it can be saved.
+func (m *Mount) ApplyBacklash() (axis string, steps uint16, err error) {
+	m.do(func() {
+		if len(m.measure.samples) == 0 {
+			err = errors.New("no backlash has been measured")
+			return
+		}
+
+		a, _ := m.parts(m.measure.axis)
+		axis = m.measure.axis
+		steps = uint16(math.Round(m.measure.mean()))
+		a.backlash.steps = steps
+		m.measure = backlashMeasure{}
+	})
+	return axis, steps, err
+}
+
+func (b backlashMeasure) mean() float64 {
+	if len(b.samples) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, s := range b.samples {
+		sum += s
+	}
+	return sum / float64(len(b.samples))
+}
@@ -0,0 +1,218 @@
+package mount
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// minCalibrationAngle is the smallest move (radians) between two
+// centered stars that's used for calibration; on shorter ones centering
+// error swamps the gear ratio error.
+const minCalibrationAngle = 5 * math.Pi / 180
+
+type (
+	// calibration compares how far the mount believes each axis turned
+	// between centering one star and centering the next with how far it
+	// really turned: the difference between the stars' coordinates.
+	calibration struct {
+		active  bool
+		centers int
+		last    calibrationPoint
+		ra      []CalibrationSample
+		dec     []CalibrationSample
+	}
+
+	// calibrationPoint is where a centered star is (ha and dec) and
+	// where the mount believed it pointed at the time.
+	calibrationPoint struct {
+		ha, dec                 float64
+		believedHA, believedDec float64
+	}
+
+	// CalibrationSample is one move between centered stars, in degrees.
+	CalibrationSample struct {
+		Believed float64 `json:"believed"`
+		Actual   float64 `json:"actual"`
+	}
+
+	// Calibration is what the api shows of a calibration.
+	Calibration struct {
+		Active  bool            `json:"active"`
+		Centers int             `json:"centers"`
+		RA      AxisCalibration `json:"ra"`
+		Dec     AxisCalibration `json:"dec"`
+	}
+
+	// AxisCalibration is the full steps per radian an axis is set up with
+	// and what the samples so far say it should be (zero until there's a
+	// sample).
+	AxisCalibration struct {
+		Samples        []CalibrationSample `json:"samples"`
+		StepsPerRadian float64             `json:"steps_per_radian"`
+		Corrected      float64             `json:"corrected"`
+	}
+
+	// jog keeps track of how far manual moves (see Move) have turned an
+	// axis, in motor revolutions.  Nothing else accounts for them.  n
+	// counts changes of speed, so a timer can tell whether anything else
+	// has moved the axis since it was set.  A jog (see Jog) stops at its
+	// deadline; a continuous one's deadline is pushed back by heartbeats
+	// and one of a number of steps has none, the firmware counts it (see
+	// countJog).
+	jog struct {
+		rpm        float64
+		since      time.Time
+		revs       float64
+		n          int
+		deadline   time.Time
+		continuous bool
+	}
+)
+
+// StartCalibration starts over with calibrating steps per radian.  The
+// procedure is to center a star (goto it, then fix it up with Move) and
+// call CalibrationCenter, then do the same with another star well away
+// from it, and so on.  Each pair of stars is a sample for both axes.
+func (m *Mount) StartCalibration() {
+	m.do(func() {
+		m.cal = calibration{active: true}
+	})
+}
+
+// CalibrationCenter says that the star at ra and dec is centered.
+func (m *Mount) CalibrationCenter(ra Target, dec float64) (err error) {
+	m.do(func() {
+		if !m.cal.active {
+			err = errors.New("no calibration in progress")
+			return
+		}
+
+		if m.ra.slewing() || m.dec.slewing() {
+			err = errors.New("wait for the slew to finish before centering")
+			return
+		}
+
+		now := time.Now()
+		p := calibrationPoint{
+			ha:          ra(now),
+			dec:         dec,
+			believedHA:  m.ra.position(now) + m.ra.jog.rads(now, m.ra.mechanics),
+			believedDec: m.dec.dec + m.dec.jog.rads(now, m.dec.mechanics),
+		}
+
+		if m.cal.centers > 0 {
+			m.cal.ra = m.cal.sample(m.cal.ra, p.believedHA-m.cal.last.believedHA, p.ha-m.cal.last.ha)
+			m.cal.dec = m.cal.sample(m.cal.dec, p.believedDec-m.cal.last.believedDec, p.dec-m.cal.last.dec)
+		}
+
+		m.cal.last = p
+		m.cal.centers++
+	})
+	return err
+}
+
+func (m *Mount) Calibration() (c Calibration) {
+	m.do(func() {
+		c = Calibration{
+			Active:  m.cal.active,
+			Centers: m.cal.centers,
+			RA:      m.cal.axis(m.cal.ra, m.ra.mechanics),
+			Dec:     m.cal.axis(m.cal.dec, m.dec.mechanics),
+		}
+	})
+	return c
+}
+
+// ApplyCalibration changes the gear ratio of each axis that has samples
+// to match what they say and ends the calibration.  It returns the new
+// gear ratios, zero for an axis that wasn't changed.
+func (m *Mount) ApplyCalibration() (ra, dec float64, err error) {
+	m.do(func() {
+		if len(m.cal.ra) == 0 && len(m.cal.dec) == 0 {
+			err = errors.New("no calibration samples: center at least two stars more than 5° apart")
+			return
+		}
+
+		if k := fitScale(m.cal.ra); k != 0 {
+			m.ra.gearRatio *= k
+			ra = m.ra.gearRatio
+		}
+
+		if k := fitScale(m.cal.dec); k != 0 {
+			m.dec.gearRatio *= k
+			dec = m.dec.gearRatio
+		}
+
+		m.cal = calibration{}
+	})
+	return ra, dec, err
+}
+
+func (c calibration) sample(samples []CalibrationSample, believed, actual float64) []CalibrationSample {
+	if math.Abs(actual) < minCalibrationAngle {
+		return samples
+	}
+
+	return append(samples, CalibrationSample{
+		Believed: believed * 180 / math.Pi,
+		Actual:   actual * 180 / math.Pi,
+	})
+}
+
+func (c calibration) axis(samples []CalibrationSample, mc mechanics) AxisCalibration {
+	a := AxisCalibration{
+		Samples:        append([]CalibrationSample{}, samples...),
+		StepsPerRadian: mc.stepsPerRadian(),
+	}
+
+	if k := fitScale(samples); k != 0 {
+		a.Corrected = a.StepsPerRadian * k
+	}
+
+	return a
+}
+
+// fitScale is the least squares fit of believed = k * actual.  The axis
+// really takes k times the steps per radian it's set up with.
+func fitScale(samples []CalibrationSample) float64 {
+	var ba, aa float64
+	for _, s := range samples {
+		ba += s.Believed * s.Actual
+		aa += s.Actual * s.Actual
+	}
+
+	if aa == 0 {
+		return 0
+	}
+	return ba / aa
+}
+
+// move records a change of speed, positive rpm turning the axis the same
+// way as a slew in the positive direction.
+func (j *jog) move(rpm float64, now time.Time) {
+	j.revs = j.total(now)
+	j.rpm = rpm
+	j.since = now
+	j.n++
+}
+
+// stop is called when something other than a manual move takes over the
+// axis.
+func (j *jog) stop(now time.Time) {
+	j.move(0, now)
+	j.deadline = time.Time{}
+	j.continuous = false
+}
+
+func (j jog) total(now time.Time) float64 {
+	if j.rpm == 0 {
+		return j.revs
+	}
+	return j.revs + j.rpm/60*now.Sub(j.since).Seconds()
+}
+
+// rads is how far jogging has turned the axis.
+func (j jog) rads(now time.Time, mc mechanics) float64 {
+	return j.total(now) / mc.gearRatio * 2 * math.Pi
+}
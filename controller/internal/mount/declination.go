@@ -2,36 +2,36 @@ package mount
 
 import (
 	"log"
-	"sync"
+	"time"
 
-	"github.com/cswank/tmc2209"
 	"github.com/warthog618/go-gpiocdev"
 )
 
 type (
 	Declination struct {
-		lock       *sync.Mutex
-		motor      *tmc2209.Motor
+		motor      motor
 		line       *gpiocdev.Line
 		address    int
 		state      state
 		dec        float64
 		direction  float64
 		microsteps int
-		gearRatio  float64
+		mechanics
+		readback
+		home     homing
+		jog      jog
+		backlash backlash
+		// moved is when the motor last changed speed
+		moved time.Time
 	}
 )
 
 func (d *Declination) slewing() bool {
-	d.lock.Lock()
-	defer d.lock.Unlock()
-	return d.state == Slew || d.state == SlowSlew
+	return d.state == Slew || d.state == SlowSlew || d.state == Homing
 }
 
 // TODO: make sure motor always moves back across local meridian when slewing
 func (d *Declination) slew(dec float64) (uint16, error) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
 	r := dec - d.dec
 
 	if r < 0 {
@@ -41,10 +41,9 @@ func (d *Declination) slew(dec float64) (uint16, error) {
 		d.direction = 1
 	}
 
-	d.dec = dec
-
-	steps := d.radsToSteps(r)
-	if steps < 100 {
+	steps, overshoot := d.backlash.plan(d.radsToSteps(r), d.direction)
+	d.dec = dec + d.direction*d.stepsToRads(overshoot)
+	if steps < slowSteps {
 		d.state = Slew
 	} else {
 		d.state = Ready
@@ -54,17 +53,16 @@ func (d *Declination) slew(dec float64) (uint16, error) {
 }
 
 func (d *Declination) listen(evt gpiocdev.LineEvent) {
-	d.lock.Lock()
-
+	d.moved = time.Now()
 	switch d.state {
 	case Ready:
 		d.state++
-		if err := d.motor.Move(5 * d.direction); err != nil {
+		if err := d.motor.Move(d.slewSpeed * d.direction); err != nil {
 			log.Printf("error starting motor")
 		}
 	case Slew:
 		d.state++
-		if err := d.motor.Move(1 * d.direction); err != nil {
+		if err := d.motor.Move(d.slowSpeed * d.direction); err != nil {
 			log.Printf("error slowing down motor: %s", err)
 		}
 	default:
@@ -73,10 +71,4 @@ func (d *Declination) listen(evt gpiocdev.LineEvent) {
 			log.Printf("error stopping motor: %s", err)
 		}
 	}
-
-	d.lock.Unlock()
-}
-
-func (d Declination) radsToSteps(r float64) uint16 {
-	return radiansToSteps(r, d.gearRatio)
 }
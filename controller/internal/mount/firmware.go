@@ -0,0 +1,88 @@
+package mount
+
+import (
+	"errors"
+	"io"
+
+	"github.com/cswank/geq/controller/internal/firmware"
+)
+
+// Firmware is what the api shows of the mcu that counts index pulses.
+type Firmware struct {
+	Version string          `json:"version"`
+	Status  firmware.Status `json:"status"`
+}
+
+// Firmware asks the counting mcu what it's doing.
+func (m *Mount) Firmware() (f Firmware, err error) {
+	m.do(func() {
+		f.Version = m.version.String()
+		f.Status, err = m.fw.Status()
+	})
+	return f, err
+}
+
+// read runs in its own goroutine and sorts what comes back over the
+// serial port until it fails: register replies from the tmc2209s go to
+// whoever is waiting in driver.read, and frames from the firmware go to
+// m.fw.  Everything else (the echo of what we sent, firmware logging) is
+// skipped.
+func (m *Mount) read(r io.Reader) error {
+	var buf []byte
+	chunk := make([]byte, 64)
+	for {
+		n, err := r.Read(chunk)
+		if err != nil {
+			return err
+		}
+
+		buf = append(buf, chunk[:n]...)
+		for len(buf) >= 2 {
+			if buf[0] != firmware.Sync {
+				buf = buf[1:]
+				continue
+			}
+
+			if buf[1] == masterAddress {
+				if len(buf) < 8 {
+					break
+				}
+
+				if tmcCRC(buf[:7]) != buf[7] {
+					buf = buf[1:]
+					continue
+				}
+
+				select {
+				case m.replies <- [8]byte(buf[:8]):
+				default:
+				}
+				buf = buf[8:]
+				continue
+			}
+
+			f, size, err := firmware.Decode(buf)
+			if errors.Is(err, firmware.ErrShort) {
+				break
+			}
+
+			if err != nil {
+				buf = buf[1:]
+				continue
+			}
+
+			m.fw.Handle(f)
+			buf = buf[size:]
+		}
+	}
+}
+
+// report hands a firmware report to the loop goroutine.  It runs in its
+// own goroutine so that read never waits on the loop, which may itself be
+// waiting on a reply that read has yet to pass along.
+func (m *Mount) report(rep firmware.Counted) {
+	select {
+	case m.cmds <- func() { m.reconcile(rep); m.settle() }:
+	case <-m.done:
+	}
+}
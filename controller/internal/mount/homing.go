@@ -0,0 +1,287 @@
+package mount
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// homeSpeed is how fast, in motor rpm, an axis is driven toward its
+	// stop when homing.
+	homeSpeed float64 = 2
+
+	// homeThreshold and collisionThreshold are StallGuard thresholds
+	// (see driver.stallGuard).  A collision during a slew should be a
+	// much bigger spike than running into the stop slowly, so it's set
+	// lower to avoid false alarms.
+	homeThreshold      uint8 = 40
+	collisionThreshold uint8 = 20
+
+	// pollInterval is how often StallGuard is read while homing or
+	// slewing with collision detection on.  StallGuard reads high while
+	// a motor gets up to speed, so nothing is read for stallSettle after
+	// a motor starts.
+	pollInterval = 50 * time.Millisecond
+	stallSettle  = 500 * time.Millisecond
+
+	homeTimeout = 5 * time.Minute
+
+	// raHome and decHome are where the axes are assumed to be at startup
+	// and where they're zeroed when homed: counterweight down, pointing
+	// at the pole.
+	raHome  float64 = 0
+	decHome float64 = math.Pi / 2
+)
+
+type (
+	// homing is the per axis state for finding the axis zero: which way
+	// to drive to reach the stop, the axis position (radians) once it's
+	// there, and an optional gpio line (a home switch or the driver's
+	// DIAG output) that goes active at the stop.
+	homing struct {
+		direction float64
+		position  float64
+		pin       int
+		line      *gpiocdev.Line
+		started   time.Time
+	}
+
+	// axisParts are the parts of an axis that homing and collision
+	// detection work with, whichever axis it is.
+	axisParts struct {
+		name     string
+		state    *state
+		motor    motor
+		driver   *driver
+		home     *homing
+		moved    *time.Time
+		jog      *jog
+		backlash *backlash
+		mech     *mechanics
+		zero     func(float64)
+	}
+)
+
+// Home drives axis ("ra" or "dec") toward its mechanical stop and, once
+// StallGuard or the home line says it's there, makes that the axis zero.
+// It returns as soon as the axis starts moving.
+func (m *Mount) Home(axis string) (err error) {
+	m.do(func() {
+		err = m.home(axis)
+	})
+	return err
+}
+
+func (m *Mount) home(axis string) error {
+	a, ok := m.parts(axis)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownAxis, axis)
+	}
+
+	if m.ra.slewing() || m.dec.slewing() {
+		return fmt.Errorf("refusing to home %s while the mount is slewing", axis)
+	}
+
+	if a.driver == nil && a.home.line == nil {
+		return fmt.Errorf("%s has neither StallGuard nor a home line to home with", axis)
+	}
+
+	if a.driver != nil {
+		if err := a.driver.stallGuard(homeThreshold); err != nil {
+			return err
+		}
+	}
+
+	if err := a.motor.Microsteps(1); err != nil {
+		return err
+	}
+
+	m.goal = nil
+	a.jog.stop(time.Now())
+	if axis == "ra" {
+		m.ra.interrupted = false
+	}
+	*a.state = Homing
+	a.home.started = time.Now()
+	*a.moved = a.home.started
+	return a.motor.Move(homeSpeed * a.home.direction)
+}
+
+// homed stops an axis that has reached its stop and zeroes it there.
+func (m *Mount) homed(a axisParts) {
+	if err := a.motor.Move(0); err != nil {
+		log.Printf("error stopping %s motor: %s", a.name, err)
+	}
+
+	*a.state = Idle
+	a.zero(a.home.position)
+	m.restoreDriver(a)
+	log.Printf("%s homed at %f", a.name, a.home.position)
+}
+
+// homeLine handles an edge on an axis' home line.
+func (m *Mount) homeLine(axis string) func(gpiocdev.LineEvent) {
+	return func(evt gpiocdev.LineEvent) {
+		a, _ := m.parts(axis)
+		if *a.state == Homing {
+			m.homed(a)
+		}
+	}
+}
+
+// poll runs on the loop goroutine every pollInterval.  It watches
+// StallGuard on axes that are homing and, if collision detection is on,
+// on axes that are slewing, keeps periodic error correction going and
+// stops jogs whose client has gone quiet.
+func (m *Mount) poll() {
+	now := time.Now()
+	m.pec.tick(m, now)
+	m.expireJogs(now)
+	for _, name := range []string{"ra", "dec"} {
+		a, _ := m.parts(name)
+		homing := *a.state == Homing
+
+		// an axis homing on its home line alone is only stopped here if
+		// the line never goes active
+		if homing && now.Sub(a.home.started) > homeTimeout {
+			log.Printf("%s didn't reach its stop within %s", name, homeTimeout)
+			m.halt()
+			continue
+		}
+
+		slewing := *a.state == Slew || *a.state == SlowSlew
+		if a.driver == nil || !(homing || (slewing && m.collisions)) || now.Sub(*a.moved) < stallSettle {
+			continue
+		}
+
+		load, err := a.driver.load()
+		if err != nil {
+			log.Printf("unable to read %s StallGuard: %s", name, err)
+			continue
+		}
+
+		threshold := collisionThreshold
+		if homing {
+			threshold = homeThreshold
+		}
+
+		if load > 2*uint16(threshold) {
+			continue
+		}
+
+		if homing {
+			m.homed(a)
+			continue
+		}
+
+		m.flag(name, "stallguard", 2*int(threshold), int(load))
+		m.halt()
+	}
+}
+
+// halt stops both axes where they are.  A count in progress is aborted
+// so the firmware is ready for the next one; its report says how far the
+// axes actually got.
+func (m *Mount) halt() {
+	m.goal = nil
+	if m.countedJog != nil {
+		m.countedJog.ended = true
+	}
+
+	if m.pending {
+		if err := m.fw.Abort(); err != nil {
+			log.Printf("unable to abort count: %s", err)
+		}
+	}
+
+	for _, name := range []string{"ra", "dec"} {
+		a, _ := m.parts(name)
+		if err := a.motor.Move(0); err != nil {
+			log.Printf("error stopping %s motor: %s", name, err)
+		}
+		if *a.state == Homing {
+			m.restoreDriver(a)
+		}
+		a.jog.stop(time.Now())
+		*a.state = Idle
+	}
+	m.ra.interrupted = false
+}
+
+// restoreDriver puts a driver back the way slews want it after homing.
+func (m *Mount) restoreDriver(a axisParts) {
+	if a.driver == nil {
+		return
+	}
+
+	var err error
+	if m.collisions {
+		err = a.driver.stallGuard(collisionThreshold)
+	} else {
+		err = a.driver.spreadCycle()
+	}
+
+	if err != nil {
+		log.Printf("unable to restore %s driver: %s", a.name, err)
+	}
+}
+
+// watch sends poll to the loop goroutine until the mount is closed.
+func (m *Mount) watch() {
+	tick := time.NewTicker(pollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-m.done:
+			return
+		}
+
+		select {
+		case m.cmds <- m.poll:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *Mount) parts(axis string) (axisParts, bool) {
+	switch axis {
+	case "ra":
+		return axisParts{
+			name:     axis,
+			state:    &m.ra.state,
+			motor:    m.ra.motor,
+			driver:   m.ra.driver,
+			home:     &m.ra.home,
+			moved:    &m.ra.moved,
+			jog:      &m.ra.jog,
+			backlash: &m.ra.backlash,
+			mech:     &m.ra.mechanics,
+			zero: func(ha float64) {
+				m.ra.ha = ha
+			},
+		}, true
+	case "dec":
+		return axisParts{
+			name:     axis,
+			state:    &m.dec.state,
+			motor:    m.dec.motor,
+			driver:   m.dec.driver,
+			home:     &m.dec.home,
+			moved:    &m.dec.moved,
+			jog:      &m.dec.jog,
+			backlash: &m.dec.backlash,
+			mech:     &m.dec.mechanics,
+			zero: func(dec float64) {
+				m.dec.dec = dec
+			},
+		}, true
+	}
+
+	return axisParts{}, false
+}
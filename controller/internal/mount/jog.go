@@ -0,0 +1,344 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/firmware"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// heartbeatTimeout is how long a continuous jog keeps going without a
+// heartbeat from the client that started it.
+const heartbeatTimeout = 1500 * time.Millisecond
+
+// presets are the named jog speeds, in multiples of the sidereal rate.
+// "max" is the slew speed.
+var presets = map[string]float64{
+	"guide":  0.5,
+	"center": 8,
+	"find":   64,
+}
+
+type (
+	// Jog is a manual move of one axis at one of the speed presets
+	// (guide, center, find or max) in Direction (1 or -1, or 0 to stop).
+	// It stops after Duration or, failing that, after Steps index
+	// pulses, which the firmware counts as it does a slew's.  With
+	// neither it keeps going for as long as heartbeats keep coming (see
+	// Heartbeat).
+	Jog struct {
+		Speed     string
+		Direction float64
+		Duration  time.Duration
+		Steps     float64
+	}
+
+	// countedJog is a jog of a number of steps while the firmware counts
+	// it.  The count includes takeUp, the slack taken up first if the
+	// jog reverses the axis.  edges is how many times the firmware has
+	// toggled the axis' line so far.
+	countedJog struct {
+		axis   string
+		rpm    float64
+		steps  uint16
+		takeUp uint16
+		edges  int
+		ended  bool
+	}
+)
+
+// Jog starts j on axis.
+func (m *Mount) Jog(axis string, j Jog) (err error) {
+	m.do(func() {
+		err = m.jog(axis, j)
+	})
+	return err
+}
+
+func (m *Mount) jog(axis string, j Jog) error {
+	a, ok := m.parts(axis)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownAxis, axis)
+	}
+
+	if j.Direction != 0 && j.Direction != 1 && j.Direction != -1 {
+		return fmt.Errorf("jog direction must be 1, -1 or 0 to stop, got %g", j.Direction)
+	}
+
+	if j.Duration < 0 || j.Steps < 0 || (j.Duration > 0 && j.Steps > 0) {
+		return errors.New("a jog is for a duration or a number of steps, not both")
+	}
+
+	if j.Steps > math.MaxUint16 {
+		return fmt.Errorf("a jog can be at most %d steps, got %g", math.MaxUint16, j.Steps)
+	}
+
+	rpm, err := a.mech.preset(j.Speed)
+	if err != nil && j.Direction != 0 {
+		return err
+	}
+	rpm *= j.Direction
+
+	now := time.Now()
+	if j.Steps > 0 && j.Direction != 0 {
+		return m.countJog(a, rpm, uint16(math.Round(j.Steps)), now)
+	}
+
+	// anything else takes over from a counted jog
+	if cj := m.countedJog; cj != nil && cj.axis == axis && !cj.ended {
+		m.abortJog(a, now)
+		if j.Direction == 0 {
+			return nil
+		}
+	}
+
+	if j.Direction == 0 {
+		return m.endJog(a, now)
+	}
+
+	if *a.state == Homing {
+		m.restoreDriver(a)
+	}
+
+	m.goal = nil
+	a.jog.stop(now)
+	if a.name == "ra" {
+		m.ra.pause(now)
+	}
+	*a.state = Ready
+	takeUp, err := m.manual(a, rpm)
+	if err != nil {
+		return err
+	}
+
+	d := j.Duration
+	if d == 0 {
+		a.jog.continuous = true
+		a.jog.deadline = now.Add(heartbeatTimeout)
+		return nil
+	}
+
+	a.jog.deadline = now.Add(takeUp + d)
+	time.AfterFunc(takeUp+d, func() {
+		select {
+		case m.cmds <- func() { m.expireJogs(time.Now()) }:
+		case <-m.done:
+		}
+	})
+	return nil
+}
+
+// countJog has the firmware count a jog of steps at rpm, plus whatever
+// it takes to take up the slack if it reverses the axis.  The axis is
+// started, slowed and stopped by the firmware's toggles (see jogEdge),
+// like a slew, and the report of what was counted (see jogCounted) says
+// how far it went.
+func (m *Mount) countJog(a axisParts, rpm float64, steps uint16, now time.Time) error {
+	if m.ra.slewing() || m.dec.slewing() {
+		return fmt.Errorf("refusing to jog %s while the mount is slewing", a.name)
+	}
+
+	if m.pending {
+		return fmt.Errorf("refusing to jog %s while waiting for the firmware to report on the last count", a.name)
+	}
+
+	if steps == 0 {
+		return nil
+	}
+
+	dir := math.Copysign(1, rpm)
+	var takeUp uint16
+	if a.backlash.last != 0 && dir != a.backlash.last {
+		takeUp = min(a.backlash.steps, math.MaxUint16-steps)
+	}
+
+	m.goal = nil
+	a.jog.stop(now)
+	if a.name == "ra" {
+		m.ra.pause(now)
+	}
+
+	// Slew keeps anything else that waits for slews from starting
+	*a.state = Slew
+	a.backlash.last = dir
+	m.countedJog = &countedJog{axis: a.name, rpm: rpm, steps: steps, takeUp: takeUp}
+
+	total := steps + takeUp
+	ra, dec := total, uint16(0)
+	if a.name == "dec" {
+		ra, dec = 0, total
+	}
+
+	m.expectReport(ra, dec, a.mech.countTime(total, rpm))
+	if err := m.fw.Count(ra, dec); err != nil {
+		m.pending = false
+		m.countedJog = nil
+		*a.state = Idle
+		return err
+	}
+	return nil
+}
+
+// listen returns the handler of edges on axis' index line.  While a jog
+// is being counted the edges are the jog's (see jogEdge); the other
+// axis' line is toggled too, to start and stop a count of nothing, and
+// is ignored.
+func (m *Mount) listen(axis string) func(gpiocdev.LineEvent) {
+	return func(evt gpiocdev.LineEvent) {
+		if cj := m.countedJog; cj != nil {
+			if cj.axis == axis && !cj.ended {
+				m.jogEdge(time.Now())
+			}
+			return
+		}
+
+		if axis == "ra" {
+			m.ra.listen(evt)
+		} else {
+			m.dec.listen(evt)
+		}
+	}
+}
+
+// jogEdge starts a counted jog's axis at the first toggle, slows it at
+// the one that says the end is near (only counts of more than slowSteps
+// have one) and stops it at the last.
+func (m *Mount) jogEdge(now time.Time) {
+	cj := m.countedJog
+	a, _ := m.parts(cj.axis)
+	*a.moved = now
+	cj.edges++
+
+	last := 2
+	if cj.steps+cj.takeUp > slowSteps {
+		last = 3
+	}
+
+	var err error
+	switch {
+	case cj.edges == 1:
+		err = a.motor.Move(cj.rpm)
+	case cj.edges < last:
+		err = a.motor.Move(math.Copysign(min(math.Abs(cj.rpm), a.mech.slowSpeed), cj.rpm))
+	default:
+		cj.ended = true
+		err = m.endJog(a, now)
+	}
+
+	if err != nil {
+		log.Printf("error moving %s motor: %s", cj.axis, err)
+	}
+}
+
+// abortJog stops a counted jog short.  The firmware still reports what
+// it counted.
+func (m *Mount) abortJog(a axisParts, now time.Time) {
+	m.countedJog.ended = true
+	if err := m.fw.Abort(); err != nil {
+		log.Printf("unable to abort count: %s", err)
+	}
+
+	if err := m.endJog(a, now); err != nil {
+		log.Printf("error stopping %s jog: %s", a.name, err)
+	}
+}
+
+// jogCounted adds how far a counted jog turned its axis, less the slack
+// it took up, to the axis' jog.
+func (m *Mount) jogCounted(rep firmware.Counted) {
+	cj := m.countedJog
+	m.countedJog = nil
+
+	a, _ := m.parts(cj.axis)
+	counted := rep.RA
+	if cj.axis == "dec" {
+		counted = rep.Dec
+	}
+
+	total := cj.steps + cj.takeUp
+	if counted != total && !rep.Aborted {
+		m.flag(cj.axis, "firmware", int(total), int(counted))
+	}
+
+	if moved := float64(counted) - float64(cj.takeUp); moved > 0 {
+		a.jog.revs += math.Copysign(moved*fullStepsPerPulse/a.mech.motorSteps, cj.rpm)
+	}
+}
+
+// forgetJog gives up on the report of a counted jog, stopping the axis
+// if the firmware never said to.
+func (m *Mount) forgetJog(now time.Time) {
+	cj := m.countedJog
+	m.countedJog = nil
+	if cj == nil || cj.ended {
+		return
+	}
+
+	a, _ := m.parts(cj.axis)
+	if err := m.endJog(a, now); err != nil {
+		log.Printf("error stopping %s jog: %s", cj.axis, err)
+	}
+}
+
+// Heartbeat keeps continuous jogs going.
+func (m *Mount) Heartbeat() {
+	m.do(func() {
+		deadline := time.Now().Add(heartbeatTimeout)
+		for _, name := range []string{"ra", "dec"} {
+			a, _ := m.parts(name)
+			if a.jog.continuous {
+				a.jog.deadline = deadline
+			}
+		}
+	})
+}
+
+// expireJogs stops jogs that have run their course or whose client has
+// stopped sending heartbeats.
+func (m *Mount) expireJogs(now time.Time) {
+	for _, name := range []string{"ra", "dec"} {
+		a, _ := m.parts(name)
+		if a.jog.deadline.IsZero() || now.Before(a.jog.deadline) {
+			continue
+		}
+
+		if a.jog.continuous {
+			log.Printf("no heartbeat, stopping %s jog", name)
+		}
+
+		if err := m.endJog(a, now); err != nil {
+			log.Printf("error stopping %s jog: %s", name, err)
+		}
+	}
+}
+
+// endJog stops a jog, and goes back to tracking if it interrupted it.
+func (m *Mount) endJog(a axisParts, now time.Time) error {
+	a.jog.stop(now)
+	if a.name == "ra" && m.ra.interrupted {
+		return m.ra.resume(now)
+	}
+
+	*a.state = Idle
+	_, err := m.manual(a, 0)
+	return err
+}
+
+// preset returns the motor rpm of the named jog speed.
+func (mc mechanics) preset(name string) (float64, error) {
+	if name == "max" {
+		return mc.slewSpeed, nil
+	}
+
+	x, ok := presets[name]
+	if !ok {
+		return 0, fmt.Errorf("%w %q (guide, center, find or max)", ErrUnknownSpeed, name)
+	}
+
+	rpm := x * siderealRate / (2 * math.Pi) * mc.gearRatio * 60
+	return min(rpm, mc.slewSpeed), nil
+}
@@ -0,0 +1,214 @@
+package mount
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/firmware"
+)
+
+const (
+	// minBackoff and maxBackoff bound how long to wait between attempts
+	// to reopen the serial port.
+	minBackoff = 250 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+var errLinkDown = errors.New("serial link is down")
+
+type (
+	// conn is the serial port as the motors, drivers and firmware client
+	// see it.  supervise reopens the port underneath it whenever it fails,
+	// so none of them have to know.
+	conn struct {
+		open func() (io.ReadWriteCloser, error)
+
+		lock   sync.Mutex
+		port   io.ReadWriteCloser
+		health Link
+	}
+
+	// Link is the health of the serial link, as the api shows it.
+	Link struct {
+		Connected bool `json:"connected"`
+		// Since is when the link last came up or went down.
+		Since      time.Time `json:"since"`
+		Reconnects int       `json:"reconnects"`
+		Error      string    `json:"error,omitempty"`
+	}
+)
+
+// Link returns the health of the serial link.
+func (m *Mount) Link() Link {
+	m.conn.lock.Lock()
+	defer m.conn.lock.Unlock()
+	return m.conn.health
+}
+
+// supervise runs in its own goroutine and keeps the serial link up until
+// the mount is closed.  Every time the port is (re)opened the motors are
+// set up again and the mount is resynced with the hardware.
+func (m *Mount) supervise() {
+	backoff := minBackoff
+	for {
+		port, err := m.conn.open()
+		if err == nil {
+			m.conn.up(port)
+			up := time.Now()
+			err = m.serve(port)
+			if time.Since(up) > maxBackoff {
+				backoff = minBackoff
+			}
+		}
+
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+
+		m.conn.down(port, err)
+		log.Printf("serial link down: %s (retrying in %s)", err, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-m.done:
+			return
+		}
+
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// serve sets up the hardware on a freshly opened port and then reads it
+// until it fails.
+func (m *Mount) serve(port io.ReadWriteCloser) error {
+	// the motors are set up before anything else reads the port in case
+	// tmc2209.Motor waits for its own replies
+	if m.setup != nil {
+		if err := m.setup(); err != nil {
+			return err
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- m.read(port) }()
+
+	if err := m.resync(); err != nil {
+		port.Close()
+		<-errs
+		return err
+	}
+
+	return <-errs
+}
+
+// resync brings the hardware back in line with what the mount believes
+// after the link comes (back) up.  Nothing could be told to stop while
+// the link was down, so slews and homing are stopped; the firmware's
+// report on the aborted count corrects the believed position.
+func (m *Mount) resync() (err error) {
+	m.do(func() {
+		if m.version, err = m.fw.Hello(); err != nil {
+			return
+		}
+		log.Printf("firmware %s", m.version)
+
+		if m.collisions {
+			for _, d := range []*driver{m.ra.driver, m.dec.driver} {
+				if d == nil {
+					continue
+				}
+				if err = d.stallGuard(collisionThreshold); err != nil {
+					return
+				}
+			}
+		}
+
+		var st firmware.Status
+		if st, err = m.fw.Status(); err != nil {
+			return
+		}
+
+		switch {
+		case m.ra.slewing() || m.dec.slewing():
+			m.halt()
+		case st.Counting && !m.pending:
+			err = m.fw.Abort()
+		}
+
+		if m.ra.state == Tracking {
+			if err = m.ra.motor.Microsteps(256); err != nil {
+				return
+			}
+			err = m.ra.motor.Move(m.ra.trackingRate())
+		}
+	})
+	return err
+}
+
+func (c *conn) Write(buf []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.port == nil {
+		return 0, errLinkDown
+	}
+
+	n, err := c.port.Write(buf)
+	if err != nil {
+		// closing the port stops read, which hands the port back to
+		// supervise
+		c.port.Close()
+	}
+	return n, err
+}
+
+// Read is only used by tmc2209.Motor during setup; everything else is
+// read by Mount.read.
+func (c *conn) Read(buf []byte) (int, error) {
+	c.lock.Lock()
+	port := c.port
+	c.lock.Unlock()
+	if port == nil {
+		return 0, errLinkDown
+	}
+	return port.Read(buf)
+}
+
+func (c *conn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.port == nil {
+		return nil
+	}
+	return c.port.Close()
+}
+
+func (c *conn) up(port io.ReadWriteCloser) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.port = port
+	if c.health.Since != (time.Time{}) {
+		c.health.Reconnects++
+	}
+	c.health.Connected = true
+	c.health.Since = time.Now()
+	c.health.Error = ""
+}
+
+func (c *conn) down(port io.ReadWriteCloser, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if port != nil {
+		port.Close()
+	}
+	c.port = nil
+	if c.health.Connected || c.health.Since == (time.Time{}) {
+		c.health.Since = time.Now()
+	}
+	c.health.Connected = false
+	c.health.Error = err.Error()
+}
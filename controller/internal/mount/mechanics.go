@@ -0,0 +1,69 @@
+package mount
+
+import (
+	"math"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/config"
+)
+
+type (
+	// mechanics describes how an axis' motor turns it.
+	mechanics struct {
+		gearRatio  float64
+		motorSteps float64
+		slewSpeed  float64
+		slowSpeed  float64
+	}
+)
+
+func newMechanics(a config.Axis) mechanics {
+	return mechanics{
+		gearRatio:  a.GearRatio,
+		motorSteps: float64(a.MotorSteps),
+		slewSpeed:  a.SlewSpeed,
+		slowSpeed:  a.SlowSpeed,
+	}
+}
+
+func (mc mechanics) radsToSteps(rads float64) uint16 {
+	return uint16(((rads / (2 * math.Pi)) * mc.gearRatio * mc.motorSteps) / fullStepsPerPulse) // the firmware counts one index pulse per two full steps
+}
+
+func (mc mechanics) stepsToRads(s uint16) float64 {
+	return (float64(s) * fullStepsPerPulse / (mc.gearRatio * mc.motorSteps)) * (2 * math.Pi)
+}
+
+func (mc mechanics) signedStepsToRads(s int) float64 {
+	r := mc.stepsToRads(uint16(math.Abs(float64(s))))
+	if s < 0 {
+		return -r
+	}
+	return r
+}
+
+// stepsPerRadian is full steps of the motor per radian of the axis.
+func (mc mechanics) stepsPerRadian() float64 {
+	return mc.gearRatio * mc.motorSteps / (2 * math.Pi)
+}
+
+// pulsesPerSecond is how many index pulses the firmware counts with the
+// motor turning at rpm.
+func (mc mechanics) pulsesPerSecond(rpm float64) float64 {
+	return math.Abs(rpm) * mc.motorSteps / fullStepsPerPulse / 60
+}
+
+// slewTime predicts how long the firmware will take to count steps.
+func (mc mechanics) slewTime(steps uint16) time.Duration {
+	return mc.countTime(steps, mc.slewSpeed)
+}
+
+// countTime predicts how long the firmware will take to count steps with
+// the motor at rpm, slowed to no faster than slow speed for the last
+// slowSteps.
+func (mc mechanics) countTime(steps uint16, rpm float64) time.Duration {
+	slow := min(steps, slowSteps)
+	fast := steps - slow
+	s := float64(fast)/mc.pulsesPerSecond(rpm) + float64(slow)/mc.pulsesPerSecond(min(math.Abs(rpm), mc.slowSpeed))
+	return time.Duration(s * float64(time.Second))
+}
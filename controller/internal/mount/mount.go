@@ -1,150 +1,348 @@
 package mount
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
-	"sync"
 	"time"
 
+	"github.com/cswank/geq/controller/internal/clock"
+	"github.com/cswank/geq/controller/internal/config"
+	"github.com/cswank/geq/controller/internal/firmware"
 	"github.com/cswank/tmc2209"
 	"github.com/warthog618/go-gpiocdev"
 	"go.bug.st/serial"
 )
 
 type (
+	// Mount owns the state of both axes.  All of that state is read and
+	// written by a single goroutine (see loop); http handlers, gpio events
+	// and timers hand it work through the cmds channel.
 	Mount struct {
-		port     serial.Port
-		latitude float64
-		ra       RA
-		dec      Declination
+		conn      *conn
+		fw        *firmware.Client
+		clock     *clock.Clock
+		version   firmware.Versions
+		latitude  float64
+		elevation float64
+		ra        RA
+		dec       Declination
+
+		// setup sets up the motors each time the serial port is opened.
+		setup func() error
+
+		// goal is the target of the goto in progress; it's cleared once
+		// the mount has settled on it (see settle).
+		goal        Target
+		decGoal     float64
+		corrections int
+		parking     bool
+
+		// pending is set while waiting for the firmware to report on the
+		// last slew (see readback.go).
+		pending       bool
+		countedJog    *countedJog
+		slews         int
+		discrepancies []Discrepancy
+		replies       chan [8]byte
+		collisions    bool
+		pec           pec
+		guides        int
+		cal           calibration
+		measure       backlashMeasure
+
+		cmds chan func()
+		done chan struct{}
 	}
 
-	message struct {
-		Sync             uint8
-		Address          uint8
-		RASteps          uint16
-		DeclinationSteps uint16
-		CRC              uint8
+	// motor is the part of a tmc2209.Motor that the axes use.
+	motor interface {
+		Move(hz float64) error
+		Microsteps(n int) error
 	}
 
 	state int
 )
 
+var (
+	// ErrUnknownAxis is returned when an axis isn't "ra" or "dec".
+	ErrUnknownAxis = errors.New("unknown axis")
+
+	// ErrUnknownSpeed is returned for a jog speed that isn't guide,
+	// center, find or max.
+	ErrUnknownSpeed = errors.New("unknown jog speed")
+)
+
 const (
 	Idle     state = -1
 	Ready    state = 0
 	Slew     state = 1
 	SlowSlew state = 2
 	Tracking state = 3
-
-	raMotorAddress  = 0
-	decMotorAddress = 1
+	Homing   state = 4
 )
 
-func New(device string, lat, lon float64, raPin, decPin int) (*Mount, error) {
+// New returns a mount built and wired as cfg describes, set up at site.
+// If cfg has no serial device the motors and mcu are simulated.
+func New(cfg config.Mount, site config.Site) (*Mount, error) {
 	mode := &serial.Mode{
-		BaudRate: 115200,
+		BaudRate: cfg.Baud,
 		DataBits: 8,
 		Parity:   serial.NoParity,
 		StopBits: serial.OneStopBit,
 	}
 
-	var (
-		err      error
-		port     serial.Port
-		raMotor  *tmc2209.Motor
-		decMotor *tmc2209.Motor
-	)
-	if device != "" {
-		port, err = serial.Open(device, mode)
-		if err != nil {
-			log.Fatalf("unable to open serial port: %s", err)
-		}
+	m := &Mount{
+		clock:      clock.New(),
+		latitude:   site.Latitude,
+		elevation:  site.Elevation,
+		collisions: cfg.Collisions,
+		ra: RA{
+			state:     Idle,
+			ha:        raHome,
+			longitude: site.Longitude,
+			mechanics: newMechanics(cfg.RA),
+			home:      homing{direction: cfg.RA.HomeDirection, position: raHome, pin: cfg.RA.HomePin},
+			backlash:  backlash{steps: cfg.RA.Backlash, approach: cfg.RA.Approach},
+		},
+		dec: Declination{
+			dec:       decHome,
+			mechanics: newMechanics(cfg.Dec),
+			home:      homing{direction: cfg.Dec.HomeDirection, position: decHome, pin: cfg.Dec.HomePin},
+			backlash:  backlash{steps: cfg.Dec.Backlash, approach: cfg.Dec.Approach},
+		},
+		pec:  pec{file: cfg.PECFile, wormPeriod: cfg.RA.WormPeriod},
+		cmds: make(chan func()),
+		done: make(chan struct{}),
+	}
+
+	if err := m.pec.load(); err != nil {
+		return nil, err
+	}
+
+	m.conn = &conn{open: func() (io.ReadWriteCloser, error) { return serial.Open(cfg.Serial, mode) }}
+	m.fw = firmware.NewClient(m.conn, func(rep firmware.Counted) { go m.report(rep) })
+
+	if cfg.Serial == "" {
+		ra, dec := &simMotor{mechanics: m.ra.mechanics}, &simMotor{mechanics: m.dec.mechanics}
+		m.ra.motor = ra
+		m.dec.motor = dec
+		raAxis := &simAxis{motor: ra, edge: m.event(m.listen("ra"))}
+		decAxis := &simAxis{motor: dec, edge: m.event(m.listen("dec"))}
+		m.conn.open = func() (io.ReadWriteCloser, error) { return newSimFirmware(raAxis, decAxis), nil }
+		go m.loop()
+		go m.watch()
+		go m.supervise()
+		return m, nil
+	}
 
-		raMotor = tmc2209.New(port, raMotorAddress, 200, 1)
+	raMotor := tmc2209.New(m.conn, cfg.RA.Address, cfg.RA.MotorSteps, 1)
+	decMotor := tmc2209.New(m.conn, cfg.Dec.Address, cfg.Dec.MotorSteps, 1)
+	m.ra.motor = raMotor
+	m.dec.motor = decMotor
+	m.setup = func() error {
 		if err := raMotor.Setup(tmc2209.SpreadCycle()...); err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("unable to set up ra motor: %w", err)
 		}
-
-		decMotor = tmc2209.New(port, decMotorAddress, 200, 1)
 		if err := decMotor.Setup(tmc2209.SpreadCycle()...); err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("unable to set up dec motor: %w", err)
 		}
+		return nil
 	}
 
-	var lock sync.Mutex
+	m.replies = make(chan [8]byte, 1)
+	m.ra.driver = &driver{m: m, address: cfg.RA.Address}
+	m.dec.driver = &driver{m: m, address: cfg.Dec.Address}
+
+	go m.loop()
+	go m.watch()
 
-	m := Mount{
-		port:     port,
-		latitude: lat,
-		ra:       RA{lock: &lock, motor: raMotor, state: Idle, ha: 0, longitude: lon, gearRatio: 100},
-		dec:      Declination{dec: math.Pi / 2, lock: &lock, motor: decMotor, gearRatio: 136.0 / 16.0},
+	var err error
+	m.ra.line, err = gpiocdev.RequestLine(cfg.GPIOChip, cfg.RA.IndexPin, gpiocdev.WithPullUp, gpiocdev.WithBothEdges, gpiocdev.WithEventHandler(m.event(m.listen("ra"))))
+	if err != nil {
+		m.Close()
+		return nil, err
 	}
 
-	if device != "" {
-		m.ra.line, err = gpiocdev.RequestLine("gpiochip0", raPin, gpiocdev.WithPullUp, gpiocdev.WithBothEdges, gpiocdev.WithEventHandler(m.ra.listen))
-		if err != nil {
-			return nil, err
+	m.dec.line, err = gpiocdev.RequestLine(cfg.GPIOChip, cfg.Dec.IndexPin, gpiocdev.WithPullUp, gpiocdev.WithBothEdges, gpiocdev.WithEventHandler(m.event(m.listen("dec"))))
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	for _, a := range []struct {
+		name string
+		home *homing
+	}{{"ra", &m.ra.home}, {"dec", &m.dec.home}} {
+		if a.home.pin < 0 {
+			continue
 		}
 
-		m.dec.line, err = gpiocdev.RequestLine("gpiochip0", decPin, gpiocdev.WithPullUp, gpiocdev.WithBothEdges, gpiocdev.WithEventHandler(m.dec.listen))
+		a.home.line, err = gpiocdev.RequestLine(cfg.GPIOChip, a.home.pin, gpiocdev.WithPullUp, gpiocdev.WithFallingEdge, gpiocdev.WithEventHandler(m.event(m.homeLine(a.name))))
 		if err != nil {
+			m.Close()
 			return nil, err
 		}
 	}
 
-	return &m, nil
+	// the serial port is opened (and reopened) in the background so the
+	// mount comes up even if the port isn't there yet
+	go m.supervise()
+	return m, nil
 }
 
-func (m *Mount) Coordinates(lat, lon float64) {
-	m.latitude = lat
-	m.ra.longitude = lon
+// loop runs every command sent to the mount, one at a time, until the
+// mount is closed.
+func (m *Mount) loop() {
+	for {
+		select {
+		case f := <-m.cmds:
+			f()
+		case <-m.done:
+			return
+		}
+	}
 }
 
-func (m *Mount) GetCoordinates() (float64, float64) {
-	return m.latitude, m.ra.longitude
+// do runs f on the loop goroutine and waits for it to finish.  It must
+// never be called from the loop goroutine itself.
+func (m *Mount) do(f func()) {
+	finished := make(chan struct{})
+	select {
+	case m.cmds <- func() { f(); close(finished) }:
+		<-finished
+	case <-m.done:
+	}
 }
 
-func (m *Mount) Move(axis string, hz float64) error {
-	switch axis {
-	case "ra":
-		m.ra.state = Ready
-		return m.ra.motor.Move(hz)
-	case "dec":
-		m.dec.state = Ready
-		return m.dec.motor.Move(hz)
+// event returns a gpio event handler that hands the event to the loop
+// goroutine.  Events are run in the order they arrive.
+func (m *Mount) event(f func(gpiocdev.LineEvent)) func(gpiocdev.LineEvent) {
+	return func(evt gpiocdev.LineEvent) {
+		select {
+		case m.cmds <- func() { f(evt); m.settle() }:
+		case <-m.done:
+		}
 	}
+}
 
-	return nil
+func (m *Mount) Coordinates(lat, lon float64) {
+	m.do(func() {
+		m.latitude = lat
+		m.ra.longitude = lon
+	})
 }
 
-func (m Mount) WithRA(ra float64, ts time.Time) func() (float64, time.Time) {
-	return func() (float64, time.Time) {
-		return hoursToRadians(m.ra.localSiderealTime(ts)) - ra, ts
-	}
+// Elevation sets the site's elevation in meters.
+func (m *Mount) Elevation(e float64) {
+	m.do(func() {
+		m.elevation = e
+	})
 }
 
-func (m Mount) WithHA(ha float64, ts time.Time) func() (float64, time.Time) {
-	return func() (float64, time.Time) {
-		return degreesToRadians(ha), ts
-	}
+func (m *Mount) GetElevation() (e float64) {
+	m.do(func() {
+		e = m.elevation
+	})
+	return e
+}
+
+func (m *Mount) GetCoordinates() (lat, lon float64) {
+	m.do(func() {
+		lat, lon = m.latitude, m.ra.longitude
+	})
+	return lat, lon
+}
+
+// Move runs axis at rpm (negative is backwards) until it's told
+// otherwise.  Moving ra at 0 after moving it while it was tracking goes
+// back to tracking.
+func (m *Mount) Move(axis string, rpm float64) (err error) {
+	m.do(func() {
+		m.goal = nil
+		a, ok := m.parts(axis)
+		if !ok {
+			err = fmt.Errorf("%w %q", ErrUnknownAxis, axis)
+			return
+		}
+
+		if *a.state == Homing {
+			m.restoreDriver(a)
+		}
+
+		now := time.Now()
+		if cj := m.countedJog; cj != nil && cj.axis == axis && !cj.ended {
+			m.abortJog(a, now)
+		}
+
+		a.jog.stop(now)
+		if axis == "ra" {
+			if rpm == 0 && m.ra.interrupted {
+				err = m.ra.resume(now)
+				return
+			}
+			m.ra.pause(now)
+		}
+
+		*a.state = Ready
+		_, err = m.manual(a, rpm)
+	})
+
+	return err
+}
+
+// Preset returns the motor rpm of axis at the named jog speed (see Jog).
+func (m *Mount) Preset(axis, speed string) (rpm float64, err error) {
+	m.do(func() {
+		a, ok := m.parts(axis)
+		if !ok {
+			err = fmt.Errorf("%w %q", ErrUnknownAxis, axis)
+			return
+		}
+
+		rpm, err = a.mech.preset(speed)
+	})
+	return rpm, err
 }
 
-// func (m Mount) WithSteps(steps float64, ts time.Time) func() (float64, time.Time) {
-// 	return func() (float64, time.Time) {
-// 		return rad(ha), ts
-// 	}
-// }
+// Stop stops both axes where they are, abandoning any goto.
+func (m *Mount) Stop() {
+	m.do(m.halt)
+}
 
-func (m *Mount) Goto(ra func() (float64, time.Time), dec float64) error {
+func (m *Mount) Goto(ra Target, dec float64) (err error) {
+	m.do(func() {
+		err = m.gotoPosition(ra, dec)
+	})
+	return err
+}
+
+// Park slews to the home position (see raHome) and stops tracking once
+// it's there.
+func (m *Mount) Park() (err error) {
+	m.do(func() {
+		err = m.gotoPosition(func(time.Time) float64 { return raHome }, decHome)
+		m.parking = err == nil
+	})
+	return err
+}
+
+func (m *Mount) gotoPosition(ra Target, dec float64) error {
 	if m.ra.slewing() || m.dec.slewing() {
 		return fmt.Errorf("refusing to goto object while the mount is slewing")
 	}
 
-	ha, ts := ra()
-	rSteps, err := m.ra.slew(ha, ts)
+	if m.pending {
+		return fmt.Errorf("refusing to goto object while waiting for the firmware to report on the last slew")
+	}
+
+	// the axes are only where slew says they'll be if the firmware takes
+	// the count
+	raWas, decWas := m.ra, m.dec
+
+	rSteps, err := m.ra.slew(ra, time.Now())
 	if err != nil {
 		return err
 	}
@@ -154,63 +352,100 @@ func (m *Mount) Goto(ra func() (float64, time.Time), dec float64) error {
 		return err
 	}
 
-	log.Printf("ha: %f, ra steps: %d, dec steps: %d, dec: %f", ha, rSteps, dSteps, dec)
-	return m.count(rSteps, dSteps)
+	log.Printf("ha: %f, ra steps: %d, dec steps: %d, dec: %f", m.ra.ha, rSteps, dSteps, dec)
+	if err := m.count(rSteps, dSteps); err != nil {
+		m.ra, m.dec = raWas, decWas
+		return err
+	}
+
+	m.goal = ra
+	m.decGoal = dec
+	m.corrections = 0
+	m.parking = false
+	return nil
+}
+
+// Clock is the time the mount goes by (see clock.Clock).
+func (m *Mount) Clock() *clock.Clock {
+	return m.clock
 }
 
+// Now is the mount's clock time.
+func (m *Mount) Now() time.Time {
+	return m.clock.Now()
+}
+
+// HourAngle returns the hour angle (hh:mm) of ra at ts, which is taken
+// as given: pass m.Now() for the hour angle by the mount's clock.
 func (m *Mount) HourAngle(ra float64, ts time.Time) string {
-	ha := m.ra.localSiderealTime(ts) - radiansToHours(ra)
+	var lst float64
+	m.do(func() {
+		lst = m.ra.localSiderealTime(ts)
+	})
+
+	ha := lst - radiansToHours(ra)
 	hah := math.Floor(ha)
 	ham := (ha - hah) * 60
 
 	return fmt.Sprintf("%02d:%02d", int(hah), int(ham))
 }
 
-func (m Mount) LocalSiderealTime(ts time.Time) float64 {
-	return m.ra.localSiderealTime(ts)
+// LocalSiderealTime returns the sidereal time, in hours, at ts, which is
+// taken as given: pass m.Now() for the sidereal time by the mount's clock.
+func (m *Mount) LocalSiderealTime(ts time.Time) (lst float64) {
+	m.do(func() {
+		lst = m.ra.localSiderealTime(ts)
+	})
+	return lst
 }
 
-func (m Mount) Rad(deg float64) float64 {
+func (m *Mount) Rad(deg float64) float64 {
 	return degreesToRadians(deg)
 }
 
-// count sends the ra and decl steps to mcu that actually does the counting
+// count has the mcu count ra and dec steps, starting and stopping the
+// motors as it goes (see listen).
 func (m *Mount) count(ra, dec uint16) error {
-	msg := message{
-		Sync:             0x5,
-		Address:          0x11,
-		RASteps:          ra,
-		DeclinationSteps: dec,
-	}
+	now := time.Now()
+	m.ra.jog.stop(now)
+	m.dec.jog.stop(now)
 
-	buf := make([]byte, 8) // pad to 8 bytes to match firmware's read chunk size
-	_, err := binary.Encode(buf, binary.LittleEndian, msg)
-	if err != nil {
+	m.expectReport(ra, dec, max(m.ra.slewTime(ra), m.dec.slewTime(dec)))
+	if err := m.fw.Count(ra, dec); err != nil {
+		m.pending = false
 		return err
 	}
-
-	var xor uint8
-	for _, b := range buf[:len(buf)-1] {
-		xor ^= b
-	}
-	buf[len(buf)-1] = xor
-
-	_, err = m.port.Write(buf)
-	return err
-
+	return nil
 }
 
-func (m Mount) Close() {
-	m.port.Close()
-	m.ra.line.Close()
-	m.dec.line.Close()
+// Close stops the loop goroutine and releases the serial port and gpio
+// lines.  The mount must not be used after it is closed.
+func (m *Mount) Close() {
+	close(m.done)
+	m.conn.Close()
+	if m.ra.line != nil {
+		m.ra.line.Close()
+	}
+	if m.dec.line != nil {
+		m.dec.line.Close()
+	}
+	for _, l := range []*gpiocdev.Line{m.ra.home.line, m.dec.home.line} {
+		if l != nil {
+			l.Close()
+		}
+	}
 }
 
-func (m Mount) StepsToRads(axis string, s uint16) float64 {
-	if axis == "ra" {
-		return stepsToRadians(s, m.ra.gearRatio)
-	}
-	return stepsToRadians(s, m.dec.gearRatio)
+// StepsToRads returns how far s steps turn axis, in radians.
+func (m *Mount) StepsToRads(axis string, s uint16) (r float64) {
+	m.do(func() {
+		if axis == "ra" {
+			r = m.ra.stepsToRads(s)
+		} else {
+			r = m.dec.stepsToRads(s)
+		}
+	})
+	return r
 }
 
 func radiansToHours(r float64) float64 {
@@ -221,14 +456,6 @@ func hoursToRadians(h float64) float64 {
 	return ((h / 24) * 2 * math.Pi)
 }
 
-func stepsToRadians(s uint16, gearRatio float64) float64 {
-	return (float64(s*2) / (gearRatio * 200)) * (2 * math.Pi)
-}
-
-func radiansToSteps(rads, gearRatio float64) uint16 {
-	return uint16(((rads / (2 * math.Pi)) * gearRatio * 200) / 2) // divide by 2 because tmc2209 produces 2 index pulses per microstep
-}
-
 func degreesToRadians(d float64) float64 {
 	return d * (math.Pi / 180)
 }
@@ -0,0 +1,364 @@
+package mount
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/config"
+)
+
+// newSim returns a simulated mount whose slews take a second or two.
+func newSim(t *testing.T) *Mount {
+	t.Helper()
+
+	cfg := config.Default().Mount
+	cfg.PECFile = ""
+	for _, a := range []*config.Axis{&cfg.RA, &cfg.Dec} {
+		a.SlewSpeed = 600
+		a.SlowSpeed = 60
+	}
+
+	m, err := New(cfg, config.Site{Latitude: 40, Longitude: -105})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the firmware", func() bool { return m.Link().Connected })
+	return m
+}
+
+// waitFor fails the test if ok isn't true within 10 seconds.
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+
+	for end := time.Now().Add(10 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		if ok() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestGoto(t *testing.T) {
+	m := newSim(t)
+	defer m.Close()
+
+	ra, dec := hoursToRadians(m.LocalSiderealTime(m.Now()))-0.3, 0.5
+	if err := m.Goto(m.WithRA(ra), dec); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the goto to settle", func() (settled bool) {
+		m.do(func() { settled = m.goal == nil && m.ra.state == Tracking })
+		return settled
+	})
+
+	// the sim counts in ticks, so a few pulses either way are fine
+	tolerance := 5 * m.StepsToRads("ra", 1)
+	gotRA, gotDec := m.Pointing()
+	if d := math.Abs(gotRA - ra); d > tolerance {
+		t.Errorf("pointing at ra %f, want %f", gotRA, ra)
+	}
+	if d := math.Abs(gotDec - dec); d > 5*m.StepsToRads("dec", 1) {
+		t.Errorf("pointing at dec %f, want %f", gotDec, dec)
+	}
+}
+
+// TestConcurrent drives the mount from many goroutines at once, as the
+// http handlers, gamepad, handset and firmware reports do, and closes it
+// while they're still going.  Run it with -race.
+func TestConcurrent(t *testing.T) {
+	m := newSim(t)
+
+	lst := hoursToRadians(m.LocalSiderealTime(m.Now()))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				switch (i + j) % 7 {
+				case 0:
+					m.Goto(m.WithRA(lst-0.1*float64(i)), 0.1*float64(j%5))
+				case 1:
+					m.Move("ra", float64(i-4))
+				case 2:
+					m.Move("dec", 0)
+				case 3:
+					m.Pointing()
+				case 4:
+					m.Position()
+				case 5:
+					if j%20 == 0 {
+						m.Stop()
+					}
+				case 6:
+					m.StepsToRads("dec", 100)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	time.Sleep(time.Second)
+	m.Close()
+
+	// everything has to return once the mount is closed, and nothing can
+	// block after it
+	m.Pointing()
+	done := make(chan struct{})
+	go func() {
+		close(stop)
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("commands blocked after the mount was closed")
+	}
+}
+
+func TestUnknownAxis(t *testing.T) {
+	m := newSim(t)
+	defer m.Close()
+
+	if err := m.Move("az", 1); !errors.Is(err, ErrUnknownAxis) {
+		t.Errorf("Move returned %v, want ErrUnknownAxis", err)
+	}
+
+	if _, err := m.Preset("az", "find"); !errors.Is(err, ErrUnknownAxis) {
+		t.Errorf("Preset returned %v, want ErrUnknownAxis", err)
+	}
+
+	if err := m.Home("az"); !errors.Is(err, ErrUnknownAxis) {
+		t.Errorf("Home returned %v, want ErrUnknownAxis", err)
+	}
+}
+
+// TestHomeTimeout checks that an axis homing on a home line that never
+// goes active, without StallGuard, is stopped.
+func TestHomeTimeout(t *testing.T) {
+	m := newSim(t)
+	defer m.Close()
+
+	m.do(func() {
+		m.dec.driver = nil
+		m.dec.state = Homing
+		m.dec.home.started = time.Now().Add(-homeTimeout - time.Second)
+		m.dec.moved = m.dec.home.started
+	})
+
+	m.do(m.poll)
+
+	var st state
+	m.do(func() { st = m.dec.state })
+	if st != Idle {
+		t.Errorf("dec is %d after homing for longer than %s, want idle", st, homeTimeout)
+	}
+}
+
+// TestCountedJog checks that a jog of a number of steps is counted by
+// the firmware and goes back to tracking if it interrupted it.
+func TestCountedJog(t *testing.T) {
+	m := newSim(t)
+	defer m.Close()
+
+	ra, dec := hoursToRadians(m.LocalSiderealTime(m.Now()))-0.3, 0.5
+	if err := m.Goto(m.WithRA(ra), dec); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the goto to settle", func() (settled bool) {
+		m.do(func() { settled = m.goal == nil && !m.pending && m.ra.state == Tracking })
+		return settled
+	})
+
+	counted := func() (done bool) {
+		m.do(func() { done = m.countedJog == nil && !m.pending })
+		return done
+	}
+
+	for _, axis := range []string{"dec", "ra"} {
+		if err := m.Jog(axis, Jog{Speed: "max", Direction: 1, Steps: 150}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Jog(axis, Jog{Speed: "max", Direction: 1, Steps: 10}); err == nil {
+			t.Errorf("a %s jog started while another was being counted", axis)
+		}
+
+		waitFor(t, "the "+axis+" jog to be counted", counted)
+
+		var rads, pulse float64
+		var st state
+		m.do(func() {
+			a, _ := m.parts(axis)
+			rads, pulse, st = a.jog.rads(time.Now(), *a.mech), a.mech.stepsToRads(1), *a.state
+		})
+
+		// the sim keeps counting for a moment after the axis is stopped
+		if rads < 150*pulse || rads > 155*pulse {
+			t.Errorf("%s jogged %.1f pulses, want 150", axis, rads/pulse)
+		}
+
+		want := Idle
+		if axis == "ra" {
+			want = Tracking
+		}
+
+		if st != want {
+			t.Errorf("%s is %d after the jog, want %d", axis, st, want)
+		}
+	}
+
+	// stopping a counted jog aborts the count, and what was counted
+	// before it stopped is how far it went
+	var before float64
+	m.do(func() { before = m.dec.jog.rads(time.Now(), m.dec.mechanics) })
+	if err := m.Jog("dec", Jog{Speed: "max", Direction: -1, Steps: 5000}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if err := m.Jog("dec", Jog{Direction: 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the aborted jog to be reported", counted)
+
+	var moved float64
+	var st state
+	m.do(func() { moved, st = before-m.dec.jog.rads(time.Now(), m.dec.mechanics), m.dec.state })
+	if pulses := moved / m.StepsToRads("dec", 1); pulses <= 0 || pulses >= 5000 || st != Idle {
+		t.Errorf("aborted jog moved %.1f pulses and left dec %d", pulses, st)
+	}
+}
+
+// TestJogPointing checks that where the mount believes it points
+// follows jogs after a slew.
+func TestJogPointing(t *testing.T) {
+	m := newSim(t)
+	defer m.Close()
+
+	if err := m.Goto(m.WithRA(hoursToRadians(m.LocalSiderealTime(m.Now()))-0.3), 0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the goto to settle", func() (settled bool) {
+		m.do(func() { settled = m.goal == nil && !m.pending && m.ra.state == Tracking })
+		return settled
+	})
+
+	counted := func() (done bool) {
+		m.do(func() { done = m.countedJog == nil && !m.pending })
+		return done
+	}
+
+	for _, axis := range []string{"dec", "ra"} {
+		ra, dec := m.Pointing()
+		if err := m.Jog(axis, Jog{Speed: "max", Direction: 1, Steps: 150}); err != nil {
+			t.Fatal(err)
+		}
+
+		waitFor(t, "the "+axis+" jog to be counted", counted)
+
+		gotRA, gotDec := m.Pointing()
+
+		// a jog east (the hour angle growing) takes ra down; the sim keeps
+		// counting for a moment after the axis is stopped
+		moved := gotDec - dec
+		if axis == "ra" {
+			moved = ra - gotRA
+		}
+
+		if pulses := moved / m.StepsToRads(axis, 1); pulses < 145 || pulses > 160 {
+			t.Errorf("pointing moved %.1f pulses in %s after a jog of 150", pulses, axis)
+		}
+	}
+}
+
+// TestNudge checks that letting go of a manual move made while tracking
+// (a gamepad stick or handset button) goes back to tracking.
+func TestNudge(t *testing.T) {
+	m := newSim(t)
+	defer m.Close()
+
+	if err := m.Goto(m.WithRA(hoursToRadians(m.LocalSiderealTime(m.Now()))-0.3), 0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the goto to settle", func() (settled bool) {
+		m.do(func() { settled = m.goal == nil && m.ra.state == Tracking })
+		return settled
+	})
+
+	for _, rpm := range []float64{5, 0} {
+		if err := m.Move("ra", rpm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.do(func() {
+		sim := m.ra.motor.(*simMotor)
+		if m.ra.state != Tracking {
+			t.Errorf("ra is in state %d after the nudge, want it tracking", m.ra.state)
+		}
+		if sim.rpm != m.ra.trackingRate() || sim.microsteps != 256 {
+			t.Errorf("ra motor is at %f rpm, 1/%d steps, want %f rpm, 1/256", sim.rpm, sim.microsteps, m.ra.trackingRate())
+		}
+	})
+
+	// a move that didn't interrupt tracking just stops
+	m.Stop()
+	for _, rpm := range []float64{5, 0} {
+		m.Move("ra", rpm)
+	}
+
+	m.do(func() {
+		if m.ra.state != Ready {
+			t.Errorf("ra is in state %d after a move from a stop, want it ready", m.ra.state)
+		}
+	})
+}
+
+// TestTrackingClock checks that while ra tracks, where it's believed to
+// point moves with real time, however the mount's clock is set.
+func TestTrackingClock(t *testing.T) {
+	m := newSim(t)
+	defer m.Close()
+
+	if err := m.Goto(m.WithRA(hoursToRadians(m.LocalSiderealTime(m.Now()))-0.3), 0.5); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the goto to settle", func() (settled bool) {
+		m.do(func() { settled = m.goal == nil && m.ra.state == Tracking })
+		return settled
+	})
+
+	m.Clock().SetRate(0)
+	m.Clock().Set(m.Now().Add(time.Hour), "test")
+
+	var start, ha float64
+	m.do(func() { start = m.ra.position(time.Now()) })
+	time.Sleep(500 * time.Millisecond)
+	m.do(func() { ha = m.ra.position(time.Now()) })
+
+	// the ha has grown by half a second of sky, give or take the time
+	// it takes to ask
+	if d := ha - start; d < siderealRate*0.5 || d > siderealRate*0.6 {
+		t.Errorf("ra moved %f radians in half a second with the clock frozen, want %f", d, siderealRate*0.5)
+	}
+}
@@ -0,0 +1,334 @@
+package mount
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	// pecHarmonics is how many harmonics of the worm period are fit to a
+	// recording.  Anything finer is mostly noise from the guider.
+	pecHarmonics = 4
+
+	// pecInterval is how often the recording is sampled and the playback
+	// rate is updated.
+	pecInterval = 2 * time.Second
+
+	// maxPECRate bounds how far playback will push the tracking rate, as
+	// a fraction of sidereal.
+	maxPECRate = 0.5
+)
+
+type (
+	// pec records the periodic error of the ra worm and plays back a
+	// correction for it.  Worm phase comes from the ra position, so it
+	// stays right across slews (and across restarts once the mount is
+	// homed).
+	pec struct {
+		file string
+
+		// wormPeriod is motor revolutions per worm revolution.
+		wormPeriod float64
+
+		curve   *PECCurve
+		playing bool
+		rec     *pecRecording
+		last    time.Time
+	}
+
+	// PECCurve is the periodic error, in radians, as a function of worm
+	// phase: a Fourier series whose k'th harmonic is Cos[k-1]*cos(2πkφ)
+	// + Sin[k-1]*sin(2πkφ).
+	PECCurve struct {
+		Recorded time.Time `json:"recorded"`
+		Cycles   float64   `json:"cycles"`
+		Cos      []float64 `json:"cos"`
+		Sin      []float64 `json:"sin"`
+	}
+
+	// PEC is what the api shows of periodic error correction.
+	PEC struct {
+		Recording bool `json:"recording"`
+		// Progress is how many worm cycles have been recorded so far.
+		Progress float64 `json:"progress"`
+		Cycles   float64 `json:"cycles"`
+		Playing  bool    `json:"playing"`
+		// Curve is the periodic error (arcseconds) over one worm cycle.
+		Curve []float64 `json:"curve"`
+	}
+
+	pecRecording struct {
+		cycles     float64
+		correction float64
+		samples    []pecSample
+	}
+
+	// pecSample is the total guide correction (radians) applied by the
+	// time the worm had turned worm revolutions.
+	pecSample struct {
+		worm       float64
+		correction float64
+	}
+)
+
+// Guide nudges ra tracking by offset (a fraction of the sidereal rate, so
+// 0.5 tracks at 1.5x) for d.  Guide corrections made while recording are
+// what the periodic error is learned from.
+func (m *Mount) Guide(offset float64, d time.Duration) (err error) {
+	m.do(func() {
+		if m.ra.state != Tracking {
+			err = errors.New("can only guide while tracking")
+			return
+		}
+
+		m.guides++
+		guide := m.guides
+		m.ra.guideRate = offset
+		err = m.ra.motor.Move(m.ra.trackingRate())
+
+		time.AfterFunc(d, func() {
+			select {
+			case m.cmds <- func() { m.guided(guide, offset, d) }:
+			case <-m.done:
+			}
+		})
+	})
+
+	return err
+}
+
+// guided ends a guide pulse.
+func (m *Mount) guided(guide int, offset float64, d time.Duration) {
+	rads := offset * siderealRate * d.Seconds()
+	m.ra.ha += rads
+	if m.pec.rec != nil {
+		m.pec.rec.correction += rads
+	}
+
+	if guide != m.guides || m.ra.state != Tracking {
+		return
+	}
+
+	m.ra.guideRate = 0
+	if err := m.ra.motor.Move(m.ra.trackingRate()); err != nil {
+		log.Printf("error ending guide pulse: %s", err)
+	}
+}
+
+// RecordPEC starts recording periodic error over cycles turns of the
+// worm.  The mount has to be tracking a star that's being kept centered
+// with Guide.
+func (m *Mount) RecordPEC(cycles float64) (err error) {
+	m.do(func() {
+		if m.ra.state != Tracking {
+			err = errors.New("can only record periodic error while tracking")
+			return
+		}
+
+		if cycles < 1 {
+			err = fmt.Errorf("need at least one worm cycle to record, got %g", cycles)
+			return
+		}
+
+		m.pec.playing = false
+		m.ra.pecRate = 0
+		m.pec.rec = &pecRecording{cycles: cycles}
+		err = m.ra.motor.Move(m.ra.trackingRate())
+	})
+
+	return err
+}
+
+// PlayPEC turns playback of the recorded correction on or off.
+func (m *Mount) PlayPEC(on bool) (err error) {
+	m.do(func() {
+		if on && m.pec.curve == nil {
+			err = errors.New("no periodic error has been recorded")
+			return
+		}
+
+		if on && m.pec.rec != nil {
+			err = errors.New("can't play back periodic error correction while recording")
+			return
+		}
+
+		m.pec.playing = on
+		if !on {
+			m.ra.pecRate = 0
+			if m.ra.state == Tracking {
+				err = m.ra.motor.Move(m.ra.trackingRate())
+			}
+		}
+	})
+
+	return err
+}
+
+func (m *Mount) PEC() (p PEC) {
+	m.do(func() {
+		p.Playing = m.pec.playing
+		if r := m.pec.rec; r != nil {
+			p.Recording = true
+			p.Cycles = r.cycles
+			if len(r.samples) > 0 {
+				p.Progress = r.samples[len(r.samples)-1].worm - r.samples[0].worm
+			}
+		}
+
+		if c := m.pec.curve; c != nil {
+			if !p.Recording {
+				p.Cycles = c.Cycles
+			}
+			p.Curve = make([]float64, 64)
+			for i := range p.Curve {
+				p.Curve[i] = c.at(float64(i)/float64(len(p.Curve))) * 180 / math.Pi * 3600
+			}
+		}
+	})
+	return p
+}
+
+// load reads a curve saved by an earlier recording, if there is one.
+func (p *pec) load() error {
+	if p.file == "" {
+		return nil
+	}
+
+	f, err := os.Open(p.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var c PECCurve
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return fmt.Errorf("unable to read periodic error curve %s: %w", p.file, err)
+	}
+
+	p.curve = &c
+	return nil
+}
+
+func (p *pec) save() error {
+	if p.file == "" {
+		return nil
+	}
+
+	f, err := os.Create(p.file)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(p.curve); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// tick runs every pollInterval on the loop goroutine.
+func (p *pec) tick(m *Mount, now time.Time) {
+	if now.Sub(p.last) < pecInterval || m.ra.state != Tracking {
+		return
+	}
+	p.last = now
+
+	worm := m.ra.position(now) * m.ra.gearRatio / (2 * math.Pi) / p.wormPeriod
+
+	if r := p.rec; r != nil {
+		r.samples = append(r.samples, pecSample{worm: worm, correction: r.correction})
+		if math.Abs(worm-r.samples[0].worm) >= r.cycles {
+			p.rec = nil
+			p.curve = r.fit()
+			log.Printf("recorded %g worm cycles of periodic error", r.cycles)
+			if err := p.save(); err != nil {
+				log.Printf("unable to save periodic error curve: %s", err)
+			}
+		}
+		return
+	}
+
+	if !p.playing || p.curve == nil || m.ra.guideRate != 0 {
+		return
+	}
+
+	// the guider cancels the error by adding the opposite of it, so the
+	// rate to add is minus how fast the error is changing, relative to
+	// how fast the sky moves
+	rate := -p.curve.slope(worm-math.Floor(worm)) * m.ra.gearRatio / (2 * math.Pi * p.wormPeriod)
+	m.ra.pecRate = max(-maxPECRate, min(maxPECRate, rate))
+	if err := m.ra.motor.Move(m.ra.trackingRate()); err != nil {
+		log.Printf("error applying periodic error correction: %s", err)
+	}
+}
+
+// fit turns a recording into a curve.  The error is minus the guide
+// corrections; any steady drift (polar misalignment, a tracking rate
+// that's a little off) is removed before fitting the harmonics of the
+// worm period to what's left.
+func (r *pecRecording) fit() *PECCurve {
+	n := float64(len(r.samples))
+
+	// least squares line through error vs worm revolutions
+	var sx, sy, sxx, sxy float64
+	for _, s := range r.samples {
+		sx += s.worm
+		sy += -s.correction
+		sxx += s.worm * s.worm
+		sxy += s.worm * -s.correction
+	}
+
+	var slope float64
+	if d := n*sxx - sx*sx; d != 0 {
+		slope = (n*sxy - sx*sy) / d
+	}
+	intercept := (sy - slope*sx) / n
+
+	c := &PECCurve{
+		Recorded: time.Now(),
+		Cycles:   r.cycles,
+		Cos:      make([]float64, pecHarmonics),
+		Sin:      make([]float64, pecHarmonics),
+	}
+
+	for _, s := range r.samples {
+		e := -s.correction - (intercept + slope*s.worm)
+		for k := range pecHarmonics {
+			a := 2 * math.Pi * float64(k+1) * s.worm
+			c.Cos[k] += 2 / n * e * math.Cos(a)
+			c.Sin[k] += 2 / n * e * math.Sin(a)
+		}
+	}
+
+	return c
+}
+
+// at returns the periodic error at phase (0-1).
+func (c PECCurve) at(phase float64) float64 {
+	var e float64
+	for k := range c.Cos {
+		a := 2 * math.Pi * float64(k+1) * phase
+		e += c.Cos[k]*math.Cos(a) + c.Sin[k]*math.Sin(a)
+	}
+	return e
+}
+
+// slope returns the derivative of the periodic error with respect to
+// phase.
+func (c PECCurve) slope(phase float64) float64 {
+	var d float64
+	for k := range c.Cos {
+		w := 2 * math.Pi * float64(k+1)
+		d += w * (c.Sin[k]*math.Cos(w*phase) - c.Cos[k]*math.Sin(w*phase))
+	}
+	return d
+}
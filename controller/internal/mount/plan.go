@@ -0,0 +1,107 @@
+package mount
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	// slowSteps is how many steps from the end of a slew the firmware
+	// tells the controller to slow down.
+	slowSteps = 100
+
+	// maxPlanIterations bounds how many times the aim point of a slew is
+	// refined against the predicted slew time.
+	maxPlanIterations = 8
+
+	// minCorrection is the smallest error, in steps, worth a correction
+	// slew once a goto ends, and maxCorrections bounds how many are made.
+	minCorrection  = 2
+	maxCorrections = 3
+)
+
+type (
+	// Target returns the hour angle, in radians, of the thing being slewed
+	// to at time t (by the system clock; the sky moves by the mount's
+	// clock).
+	Target func(t time.Time) float64
+)
+
+// WithRA returns a target for an object at right ascension ra.
+func (m *Mount) WithRA(ra float64) Target {
+	return func(t time.Time) float64 {
+		return hoursToRadians(m.ra.localSiderealTime(m.clock.At(t))) - ra
+	}
+}
+
+// WithHA returns a target for whatever was at hour angle ha (degrees) at
+// time ts.
+func (m *Mount) WithHA(ha float64, ts time.Time) Target {
+	return func(t time.Time) float64 {
+		return degreesToRadians(ha) + siderealRate*m.clock.At(t).Sub(m.clock.At(ts)).Seconds()
+	}
+}
+
+// settle runs after every gpio event and firmware report.  Once both axes
+// have finished a goto and the firmware has reported on it, it compares
+// where the target is with where the axes ended up and, if the prediction
+// was off or a slew went past the target to finish in its approach
+// direction (see backlash.plan), makes a short correction slew.
+func (m *Mount) settle() {
+	if m.goal == nil || m.pending || m.ra.state != Tracking || m.dec.state != Idle {
+		return
+	}
+
+	now := time.Now()
+	raOff := m.ra.radsToSteps(math.Abs(m.goal(now) - m.ra.position(now)))
+	decOff := m.dec.radsToSteps(math.Abs(m.decGoal - m.dec.dec))
+	if (raOff < minCorrection && decOff < minCorrection) || m.corrections == maxCorrections {
+		log.Printf("goto settled after %d corrections, %d and %d steps off", m.corrections, raOff, decOff)
+		m.goal = nil
+		if m.parking {
+			m.parked(now)
+		}
+		return
+	}
+
+	m.corrections++
+
+	// ra is slewed even if it's close enough: the firmware toggles its
+	// line either way and a slew of no steps goes straight back to
+	// tracking
+	raSteps, err := m.ra.slew(m.goal, now)
+	if err != nil {
+		log.Printf("unable to plan correction slew: %s", err)
+		m.goal = nil
+		return
+	}
+
+	var decSteps uint16
+	if decOff >= minCorrection {
+		if decSteps, err = m.dec.slew(m.decGoal); err != nil {
+			log.Printf("unable to plan correction slew: %s", err)
+			m.goal = nil
+			return
+		}
+	}
+
+	if err := m.count(raSteps, decSteps); err != nil {
+		log.Printf("unable to send correction slew: %s", err)
+		m.goal = nil
+	}
+}
+
+// parked stops ra tracking at the end of a park.
+func (m *Mount) parked(now time.Time) {
+	m.parking = false
+	a, _ := m.parts("ra")
+	a.jog.stop(now)
+	m.ra.state = Idle
+	if _, err := m.manual(a, 0); err != nil {
+		log.Printf("unable to stop tracking once parked: %s", err)
+		return
+	}
+
+	log.Println("parked")
+}
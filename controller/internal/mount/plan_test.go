@@ -0,0 +1,28 @@
+package mount
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlewAim(t *testing.T) {
+	start := time.Now()
+	for _, ha := range []float64{0.001, 0.1, 1, -2} {
+		r := RA{
+			state:     Idle,
+			mechanics: mechanics{gearRatio: 100, motorSteps: 200, slewSpeed: 5, slowSpeed: 1},
+		}
+		target := func(t time.Time) float64 { return ha + siderealRate*t.Sub(start).Seconds() }
+
+		steps, err := r.slew(target, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the axis has to end up where the target is when the slew ends
+		if off := math.Abs(target(start.Add(r.slewTime(steps))) - r.ha); off > r.stepsToRads(1) {
+			t.Errorf("slew to %f aimed %f radians (%d steps) away from the target", ha, off, r.radsToSteps(off))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package mount
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/firmware"
+)
+
+const (
+	// reportTimeout is how long to wait for the firmware's report once
+	// both axes have stopped.  The firmware keeps counting for 200ms
+	// after it stops the motors.
+	reportTimeout = time.Second
+
+	// fullStepsPerPulse and mscntPerFullStep relate the index pulses the
+	// firmware counts to the tmc2209 microstep counter.
+	fullStepsPerPulse = 2
+	mscntPerFullStep  = 256
+
+	// mscntTolerance is how far (in 1/256 steps) the microstep counter
+	// can be from where it should be before it's flagged.
+	mscntTolerance = 128
+
+	maxDiscrepancies = 20
+)
+
+type (
+	// Position is where the mount believes it points, along with the
+	// times that belief disagreed with the hardware.
+	Position struct {
+		HourAngle     float64       `json:"hour_angle"`
+		Dec           float64       `json:"dec"`
+		Discrepancies []Discrepancy `json:"discrepancies"`
+	}
+
+	// Discrepancy is a disagreement between the steps the mount commanded
+	// and what the firmware (source "firmware", in index pulses) or a
+	// driver's microstep counter (source "mscnt", in 1/256 steps modulo
+	// four full steps) says actually happened.
+	Discrepancy struct {
+		Time     time.Time `json:"time"`
+		Axis     string    `json:"axis"`
+		Source   string    `json:"source"`
+		Expected int       `json:"expected"`
+		Actual   int       `json:"actual"`
+	}
+
+	// readback is the per axis bookkeeping for checking a slew.
+	readback struct {
+		driver    *driver
+		commanded uint16
+		mscnt     uint16
+		hasMscnt  bool
+	}
+)
+
+// Position returns where the mount believes it points.
+func (m *Mount) Position() (p Position) {
+	m.do(func() {
+		p = Position{
+			HourAngle:     m.ra.position(time.Now()),
+			Dec:           m.dec.dec,
+			Discrepancies: append([]Discrepancy{}, m.discrepancies...),
+		}
+	})
+	return p
+}
+
+// Pointing returns the ra and dec (radians) the mount believes it points
+// at now, jogs since the last slew included.
+func (m *Mount) Pointing() (ra, dec float64) {
+	m.do(func() {
+		now := time.Now()
+		ha := m.ra.position(now) + m.ra.jog.rads(now, m.ra.mechanics)
+		ra = math.Mod(hoursToRadians(m.ra.localSiderealTime(m.clock.At(now)))-ha, 2*math.Pi)
+		if ra < 0 {
+			ra += 2 * math.Pi
+		}
+		dec = m.dec.dec + m.dec.jog.rads(now, m.dec.mechanics)
+	})
+	return ra, dec
+}
+
+// expectReport records what is about to be sent to the firmware, which
+// should take d to count it, so the report that follows the slew can be
+// checked against it.  Must be called from the loop goroutine.
+func (m *Mount) expectReport(ra, dec uint16, d time.Duration) {
+	m.ra.readback.begin(ra)
+	m.dec.readback.begin(dec)
+	m.slews++
+	m.pending = true
+
+	slew := m.slews
+	time.AfterFunc(d+reportTimeout, func() {
+		select {
+		case m.cmds <- func() {
+			if m.pending && m.slews == slew {
+				log.Printf("no report from the firmware for slew %d", slew)
+				m.pending = false
+				m.forgetJog(time.Now())
+				m.settle()
+			}
+		}:
+		case <-m.done:
+		}
+	})
+}
+
+// reconcile checks the firmware's report against the steps that were
+// commanded.  Pulses counted past the target are motion the mount didn't
+// plan for (the motor coasting after it was told to stop), so they are
+// added to the believed position and flagged.  An aborted slew comes up
+// short and is corrected the same way.
+func (m *Mount) reconcile(rep firmware.Counted) {
+	if !m.pending {
+		return
+	}
+	m.pending = false
+
+	if m.countedJog != nil {
+		m.jogCounted(rep)
+		return
+	}
+
+	if extra := int(rep.RA) - int(m.ra.commanded); extra != 0 {
+		m.ra.ha += m.ra.direction * m.ra.signedStepsToRads(extra)
+		m.flag("ra", "firmware", int(m.ra.commanded), int(rep.RA))
+	}
+
+	if extra := int(rep.Dec) - int(m.dec.commanded); extra != 0 {
+		m.dec.dec += m.dec.direction * m.dec.signedStepsToRads(extra)
+		m.flag("dec", "firmware", int(m.dec.commanded), int(rep.Dec))
+	}
+
+	// a tracking motor keeps moving the microstep counter, so it can only
+	// be checked on an axis that has stopped
+	if m.ra.state == Idle {
+		m.checkMscnt("ra", &m.ra.readback, rep.RA)
+	}
+
+	if m.dec.state == Idle {
+		m.checkMscnt("dec", &m.dec.readback, rep.Dec)
+	}
+}
+
+func (m *Mount) checkMscnt(axis string, rb *readback, counted uint16) {
+	if !rb.hasMscnt {
+		return
+	}
+
+	end, err := rb.driver.mscnt()
+	if err != nil {
+		log.Printf("unable to read %s microstep counter: %s", axis, err)
+		return
+	}
+
+	// moving a whole number of pulses either leaves the counter where it
+	// was or moves it by half a turn, so the direction doesn't matter
+	expected := int(counted) * fullStepsPerPulse * mscntPerFullStep % 1024
+	actual := (int(end) - int(rb.mscnt) + 1024) % 1024
+	diff := (actual - expected + 1024) % 1024
+	if diff >= 512 {
+		diff -= 1024
+	}
+
+	if diff >= mscntTolerance || diff <= -mscntTolerance {
+		m.flag(axis, "mscnt", expected, actual)
+	}
+}
+
+func (m *Mount) flag(axis, source string, expected, actual int) {
+	d := Discrepancy{Time: time.Now(), Axis: axis, Source: source, Expected: expected, Actual: actual}
+	log.Printf("%s position discrepancy from %s: expected %d, got %d", axis, source, expected, actual)
+
+	m.discrepancies = append(m.discrepancies, d)
+	if len(m.discrepancies) > maxDiscrepancies {
+		m.discrepancies = m.discrepancies[len(m.discrepancies)-maxDiscrepancies:]
+	}
+}
+
+// begin is called right before steps are sent to the firmware.
+func (rb *readback) begin(steps uint16) {
+	rb.commanded = steps
+	rb.hasMscnt = false
+	if rb.driver == nil {
+		return
+	}
+
+	v, err := rb.driver.mscnt()
+	if err != nil {
+		log.Printf("unable to read microstep counter: %s", err)
+		return
+	}
+
+	rb.mscnt = v
+	rb.hasMscnt = true
+}
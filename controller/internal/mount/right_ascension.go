@@ -3,50 +3,71 @@ package mount
 import (
 	"log"
 	"math"
-	"sync"
 	"time"
 
-	"github.com/cswank/tmc2209"
 	"github.com/warthog618/go-gpiocdev"
 )
 
 const (
-	j1970         float64 = 2440587.5
-	trackingSpeed float64 = -100.0 / (24.0 * 60.0)
+	j1970 float64 = 2440587.5
+
+	// siderealRate is how fast the hour angle of a star grows, in radians
+	// per second.
+	siderealRate float64 = 2 * math.Pi / 86164.0905
 )
 
 type (
 	RA struct {
-		lock       *sync.Mutex
-		motor      *tmc2209.Motor
+		motor      motor
 		line       *gpiocdev.Line
 		longitude  float64
 		state      state
 		direction  float64
 		microsteps int
-		gearRatio  float64
+		mechanics
+		readback
+		home     homing
+		jog      jog
+		backlash backlash
+		// moved is when the motor last changed speed
+		moved time.Time
 
 		// start is the time at which tracking began
 		start time.Time
-		// ha is the hour angle of the object being tracked
+		// ha is the hour angle the axis pointed at when tracking began
 		ha float64
+		// interrupted is set while a manual move has taken over from
+		// tracking, which picks up again when the move ends.
+		interrupted bool
+
+		// guideRate and pecRate speed up (or slow down) tracking by a
+		// fraction of the sidereal rate (see Guide and pec.go).
+		guideRate float64
+		pecRate   float64
 	}
 )
 
 func (r *RA) slewing() bool {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	return r.state == Slew || r.state == SlowSlew
+	return r.state == Slew || r.state == SlowSlew || r.state == Homing
 }
 
-func (r *RA) slew(ha float64, t time.Time) (uint16, error) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	rads := ha - r.ha
-	if r.state == Tracking {
-		rads += degreesToRadians(15 * time.Since(r.start).Minutes() / 60)
+// slew points the ra axis at where target will be when the slew ends.
+// The target keeps moving while the motor runs, so the arrival time is
+// predicted from the motion profile (see slewTime) and the aim point is
+// refined until it moves by less than a step.
+func (r *RA) slew(target Target, t time.Time) (uint16, error) {
+	pos := r.position(t)
+	ha := target(t)
+	for range maxPlanIterations {
+		next := target(t.Add(r.slewTime(r.radsToSteps(math.Abs(ha - pos)))))
+		moved := r.radsToSteps(math.Abs(next - ha))
+		ha = next
+		if moved == 0 {
+			break
+		}
 	}
 
+	rads := ha - pos
 	if rads < 0 {
 		r.direction = -1
 		rads *= -1
@@ -54,24 +75,62 @@ func (r *RA) slew(ha float64, t time.Time) (uint16, error) {
 		r.direction = 1
 	}
 
-	steps := r.radsToSteps(rads)
-	log.Printf("ra: current ha: %f, ha: %f, radians: %f, steps: %d, diration: %f\n", r.ha, ha, rads, steps, r.direction)
+	steps, overshoot := r.backlash.plan(r.radsToSteps(rads), r.direction)
+	log.Printf("ra: current ha: %f, ha: %f, radians: %f, steps: %d, diration: %f\n", pos, ha, rads, steps, r.direction)
 
-	r.ha = ha
+	r.ha = ha + r.direction*r.stepsToRads(overshoot)
 	r.start = t
+	r.interrupted = false
 
-	if steps < 100 {
+	if steps < slowSteps {
 		r.state = Slew
 	} else {
 		r.state = Ready
 	}
 
-	//TODO: add more steps based on how long it will take the motor to get to the final position
 	return steps, nil
 }
 
-func (r RA) radsToSteps(rads float64) uint16 {
-	return radiansToSteps(rads, r.gearRatio)
+// position returns the hour angle the ra axis points at.  While tracking
+// it follows the sky westward from where the last slew left it, for as
+// long as the motor has really been running: setting the mount's clock,
+// or freezing it, doesn't turn the axis.
+func (r RA) position(t time.Time) float64 {
+	if r.state != Tracking {
+		return r.ha
+	}
+
+	return r.ha + siderealRate*t.Sub(r.start).Seconds()
+}
+
+// pause stops following the sky for a manual move.  If the axis was
+// tracking, where it got to is kept so that tracking can resume from
+// there.
+func (r *RA) pause(t time.Time) {
+	if r.state != Tracking {
+		return
+	}
+
+	r.ha = r.position(t)
+	r.interrupted = true
+}
+
+// resume goes back to tracking once a manual move that interrupted it
+// ends.
+func (r *RA) resume(t time.Time) error {
+	r.interrupted = false
+	r.state = Tracking
+	r.start = t
+	r.backlash.last = 1
+	if err := r.motor.Microsteps(256); err != nil {
+		return err
+	}
+	return r.motor.Move(r.trackingRate())
+}
+
+// trackingRate is the motor speed, in rpm, that follows the sky.
+func (r RA) trackingRate() float64 {
+	return -r.gearRatio / (24.0 * 60.0) * (1 + r.guideRate + r.pecRate)
 }
 
 func (r RA) localSiderealTime(datetime time.Time) float64 {
@@ -87,28 +146,28 @@ func (r RA) localSiderealTime(datetime time.Time) float64 {
 }
 
 func (r *RA) listen(evt gpiocdev.LineEvent) {
-	r.lock.Lock()
-
+	r.moved = time.Now()
 	switch r.state {
 	case Ready:
 		r.state++
 		if err := r.motor.Microsteps(1); err != nil {
 			log.Printf("error setting microsteps: %s", err)
 		}
-		if err := r.motor.Move(5 * r.direction); err != nil {
+		if err := r.motor.Move(r.slewSpeed * r.direction); err != nil {
 			log.Printf("error starting motor")
 		}
 	case Slew:
 		r.state++
-		if err := r.motor.Move(1 * r.direction); err != nil {
+		if err := r.motor.Move(r.slowSpeed * r.direction); err != nil {
 			log.Printf("error slowing down motor: %s", err)
 		}
 	case SlowSlew:
 		r.state++
+		r.backlash.last = 1 // tracking turns the axis the way the hour angle grows
 		if err := r.motor.Microsteps(256); err != nil {
 			log.Printf("error setting microsteps: %s", err)
 		}
-		if err := r.motor.Move(trackingSpeed); err != nil {
+		if err := r.motor.Move(r.trackingRate()); err != nil {
 			log.Printf("error tracking motor: %s", err)
 		}
 		r.start = time.Now()
@@ -118,8 +177,6 @@ func (r *RA) listen(evt gpiocdev.LineEvent) {
 			log.Printf("error stopping motor: %s", err)
 		}
 	}
-
-	r.lock.Unlock()
 }
 
 func greenwichSiderealTime(datetime time.Time) float64 {
@@ -0,0 +1,289 @@
+package mount
+
+import (
+	"encoding"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/firmware"
+	"github.com/warthog618/go-gpiocdev"
+)
+
+const (
+	// simTick is how often the simulated firmware checks its motors.
+	simTick = 10 * time.Millisecond
+
+	// simSettle is how long the firmware keeps counting after it stops
+	// the motor.
+	simSettle = 200 * time.Millisecond
+)
+
+type (
+	// simMotor stands in for a tmc2209.Motor when there is no mount
+	// attached.  Rates are in motor revolutions per minute, which is the
+	// unit RA.trackingRate is written in.
+	simMotor struct {
+		mechanics
+		lock       sync.Mutex
+		rpm        float64
+		microsteps int
+	}
+
+	// simAxis is one of the firmware's counters: it counts the index
+	// pulses of motor and toggles its output line (edge) to start, slow
+	// down and stop the motor.
+	simAxis struct {
+		motor *simMotor
+		edge  func(gpiocdev.LineEvent)
+
+		lock    sync.Mutex
+		target  uint16
+		counted uint16
+	}
+
+	// simFirmware stands in for the mcu on the other end of the serial
+	// port.  It speaks the same protocol as the real firmware; what it
+	// sends back is read from it like from the serial port.
+	simFirmware struct {
+		lock  sync.Mutex
+		busy  bool
+		abort chan struct{}
+		done  chan struct{}
+		ra    *simAxis
+		dec   *simAxis
+
+		out    chan []byte
+		unread []byte
+	}
+)
+
+func (s *simMotor) Move(rpm float64) error {
+	s.lock.Lock()
+	s.rpm = rpm
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *simMotor) Microsteps(n int) error {
+	s.lock.Lock()
+	s.microsteps = n
+	s.lock.Unlock()
+	return nil
+}
+
+// pulses returns the number of index pulses the motor produces in d at
+// its current rate.
+func (s *simMotor) pulses(d time.Duration) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pulsesPerSecond(s.rpm) * d.Seconds()
+}
+
+func newSimFirmware(ra, dec *simAxis) *simFirmware {
+	return &simFirmware{
+		ra:   ra,
+		dec:  dec,
+		done: make(chan struct{}),
+		out:  make(chan []byte, 16),
+	}
+}
+
+func (s *simFirmware) Write(buf []byte) (int, error) {
+	f, _, err := firmware.Decode(buf)
+	if err != nil || f.Address != firmware.CommandAddress {
+		return len(buf), nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch f.Kind {
+	case firmware.Hello:
+		s.send(firmware.HelloReply, f.Seq, firmware.Versions{Protocol: firmware.Version, Minor: 2})
+	case firmware.Count:
+		var steps firmware.Steps
+		if err := steps.UnmarshalBinary(f.Payload); err != nil {
+			s.nak(f.Seq, firmware.BadLength)
+			break
+		}
+
+		if s.busy {
+			s.nak(f.Seq, firmware.Busy)
+			break
+		}
+
+		s.busy = true
+		s.abort = make(chan struct{})
+		go s.count(steps, s.abort)
+		s.send(firmware.Ack, f.Seq, nil)
+	case firmware.Query:
+		st := firmware.Status{Counting: s.busy}
+		st.RARemaining, st.RACounted = s.ra.progress()
+		st.DecRemaining, st.DecCounted = s.dec.progress()
+		if !s.busy {
+			st.RARemaining, st.DecRemaining = 0, 0
+		}
+		s.send(firmware.QueryReply, f.Seq, st)
+	case firmware.Abort:
+		if s.busy {
+			select {
+			case <-s.abort:
+			default:
+				close(s.abort)
+			}
+		}
+		s.send(firmware.Ack, f.Seq, nil)
+	default:
+		s.nak(f.Seq, firmware.UnknownCommand)
+	}
+
+	return len(buf), nil
+}
+
+func (s *simFirmware) Read(buf []byte) (int, error) {
+	if len(s.unread) == 0 {
+		select {
+		case s.unread = <-s.out:
+		case <-s.done:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(buf, s.unread)
+	s.unread = s.unread[n:]
+	return n, nil
+}
+
+func (s *simFirmware) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return nil
+}
+
+func (s *simFirmware) nak(seq uint8, code firmware.Code) {
+	s.send(firmware.Nak, seq, simPayload{byte(code)})
+}
+
+// send queues a frame for Read.  Like a serial line it drops what nobody
+// reads.
+func (s *simFirmware) send(kind firmware.Kind, seq uint8, payload encoding.BinaryMarshaler) {
+	var p []byte
+	if payload != nil {
+		p, _ = payload.MarshalBinary()
+	}
+
+	buf, err := firmware.Frame{Address: firmware.ResponseAddress, Kind: kind, Seq: seq, Payload: p}.Encode()
+	if err != nil {
+		log.Printf("sim firmware: %s", err)
+		return
+	}
+
+	select {
+	case s.out <- buf:
+	default:
+	}
+}
+
+func (s *simFirmware) count(steps firmware.Steps, abort chan struct{}) {
+	var rep firmware.Counted
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		rep.RA = s.ra.count(steps.RA, abort, s.done)
+		wg.Done()
+	}()
+	go func() {
+		rep.Dec = s.dec.count(steps.Dec, abort, s.done)
+		wg.Done()
+	}()
+	wg.Wait()
+
+	select {
+	case <-abort:
+		rep.Aborted = true
+	default:
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.busy = false
+	s.send(firmware.Report, 0, rep)
+}
+
+// count mirrors the counting loop in the firmware.
+func (a *simAxis) count(target uint16, abort, done chan struct{}) uint16 {
+	a.set(target, 0)
+	a.edge(gpiocdev.LineEvent{}) // start
+
+	tick := time.NewTicker(simTick)
+	defer tick.Stop()
+
+	var i float64
+	slowed := target <= slowSteps
+	aborted := false
+	for !aborted && uint16(math.Min(i, math.MaxUint16)) < target {
+		select {
+		case <-tick.C:
+		case <-abort:
+			aborted = true
+			continue
+		case <-done:
+			return 0
+		}
+
+		i += a.motor.pulses(simTick)
+		a.set(target, i)
+		if !slowed && float64(target)-i <= slowSteps {
+			slowed = true
+			a.edge(gpiocdev.LineEvent{}) // slow down
+		}
+	}
+
+	if !aborted {
+		a.edge(gpiocdev.LineEvent{}) // stop
+	}
+
+	for range simSettle / simTick {
+		select {
+		case <-tick.C:
+		case <-done:
+			return 0
+		}
+		i += a.motor.pulses(simTick)
+		a.set(target, i)
+	}
+
+	return uint16(math.Min(i, math.MaxUint16))
+}
+
+func (a *simAxis) set(target uint16, counted float64) {
+	a.lock.Lock()
+	a.target = target
+	a.counted = uint16(math.Min(counted, math.MaxUint16))
+	a.lock.Unlock()
+}
+
+// progress returns the steps left to count and the steps counted so far.
+func (a *simAxis) progress() (remaining, counted uint16) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.counted < a.target {
+		remaining = a.target - a.counted
+	}
+	return remaining, a.counted
+}
+
+// simPayload is a payload that's already encoded.
+type simPayload []byte
+
+func (p simPayload) MarshalBinary() ([]byte, error) {
+	return p, nil
+}
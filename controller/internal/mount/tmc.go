@@ -0,0 +1,135 @@
+package mount
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// tmc2209 registers that tmc2209.Motor doesn't expose
+	regGCONF     = 0x00
+	regTCOOLTHRS = 0x14
+	regSGTHRS    = 0x40
+	regSGResult  = 0x41
+	regMSCNT     = 0x6a
+
+	// gconfSpreadCycle is the en_SpreadCycle bit of GCONF.  StallGuard
+	// only works with it cleared (StealthChop).
+	gconfSpreadCycle = 1 << 2
+
+	// masterAddress is the address a tmc2209 puts on its replies
+	masterAddress = 0xff
+
+	replyTimeout = 50 * time.Millisecond
+)
+
+type (
+	// driver reads and writes tmc2209 registers over the uart it shares with
+	// the other driver and the counting mcu.  Replies are picked out of
+	// the serial stream by Mount.read.
+	driver struct {
+		m       *Mount
+		address uint8
+	}
+)
+
+// mscnt returns the driver's microstep counter.  It counts 1024 per four
+// full steps no matter how many microsteps are configured, so it's only
+// good for checking position modulo four full steps.
+func (d driver) mscnt() (uint16, error) {
+	v, err := d.read(regMSCNT)
+	return uint16(v & 0x3ff), err
+}
+
+// stallGuard switches the driver to StealthChop, which StallGuard needs,
+// and sets the load at which it reports a stall: SG_RESULT falling below
+// twice threshold.
+func (d driver) stallGuard(threshold uint8) error {
+	gconf, err := d.read(regGCONF)
+	if err != nil {
+		return err
+	}
+
+	if err := d.write(regGCONF, gconf&^gconfSpreadCycle); err != nil {
+		return err
+	}
+
+	if err := d.write(regTCOOLTHRS, 0xfffff); err != nil {
+		return err
+	}
+
+	return d.write(regSGTHRS, uint32(threshold))
+}
+
+// spreadCycle undoes stallGuard.
+func (d driver) spreadCycle() error {
+	gconf, err := d.read(regGCONF)
+	if err != nil {
+		return err
+	}
+
+	if err := d.write(regTCOOLTHRS, 0); err != nil {
+		return err
+	}
+
+	return d.write(regGCONF, gconf|gconfSpreadCycle)
+}
+
+// load returns the StallGuard result: lower means more load.
+func (d driver) load() (uint16, error) {
+	v, err := d.read(regSGResult)
+	return uint16(v & 0x3ff), err
+}
+
+func (d driver) write(reg uint8, v uint32) error {
+	req := []byte{0x5, d.address, reg | 0x80, uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v), 0}
+	req[7] = tmcCRC(req[:7])
+	_, err := d.m.conn.Write(req)
+	return err
+}
+
+// read must only be called from the loop goroutine.
+func (d driver) read(reg uint8) (uint32, error) {
+	select {
+	case <-d.m.replies: // drop anything stale
+	default:
+	}
+
+	req := []byte{0x5, d.address, reg, 0}
+	req[3] = tmcCRC(req[:3])
+	if _, err := d.m.conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	tm := time.NewTimer(replyTimeout)
+	defer tm.Stop()
+
+	for {
+		select {
+		case reply := <-d.m.replies:
+			if reply[2] != reg {
+				continue
+			}
+			return uint32(reply[3])<<24 | uint32(reply[4])<<16 | uint32(reply[5])<<8 | uint32(reply[6]), nil
+		case <-tm.C:
+			return 0, fmt.Errorf("no reply from driver %d for register %#x", d.address, reg)
+		}
+	}
+}
+
+// tmcCRC is the CRC8 (polynomial x^8 + x^2 + x + 1) from the tmc2209
+// datasheet.
+func tmcCRC(buf []byte) uint8 {
+	var crc uint8
+	for _, b := range buf {
+		for range 8 {
+			if (crc>>7)^(b&0x01) != 0 {
+				crc = (crc << 1) ^ 0x07
+			} else {
+				crc = crc << 1
+			}
+			b >>= 1
+		}
+	}
+	return crc
+}
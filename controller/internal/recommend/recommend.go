@@ -0,0 +1,181 @@
+// Package recommend picks what to look at tonight.  The dark part of the
+// night is cut into slots, every object the telescope can show is scored
+// in each by how high it is, how bright it is for the aperture, how much
+// the moon washes it out and how much the observer likes its type, and
+// each object goes in the slot it scores best in.
+package recommend
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/repo"
+	"github.com/cswank/geq/controller/internal/sky"
+)
+
+type (
+	// Preferences are what makes a good target.  Aperture is the
+	// telescope's in millimeters and MinAltitude, in degrees, the lowest
+	// a target is worth looking at.  Types weighs the score of each type
+	// of object (by default 1).  The night is cut into Slot long slots
+	// with up to PerSlot targets each.
+	Preferences struct {
+		Aperture    float64
+		MinAltitude float64
+		Types       map[string]float64
+		Slot        time.Duration
+		PerSlot     int
+	}
+
+	// Plan is the rest of tonight's dark (or, on nights that never get
+	// astronomically dark, the time between sunset and sunrise) and how
+	// much of the moon is lit in the middle of it.
+	Plan struct {
+		Start        time.Time `json:"start"`
+		End          time.Time `json:"end"`
+		Illumination float64   `json:"moon_illumination"`
+		Slots        []Slot    `json:"slots"`
+	}
+
+	// Slot is part of the night, where the moon is in the middle of it
+	// (its altitude in degrees) and the targets that are best in it,
+	// best first.
+	Slot struct {
+		Start        time.Time `json:"start"`
+		End          time.Time `json:"end"`
+		MoonAltitude float64   `json:"moon_altitude"`
+		Targets      []Target  `json:"targets"`
+
+		lst, moonRA, moonDec float64
+	}
+
+	// Target is an object with its score and its altitude, in degrees,
+	// in the middle of its slot.
+	Target struct {
+		repo.Object
+		Score        float64 `json:"score"`
+		SlotAltitude float64 `json:"slot_altitude"`
+	}
+)
+
+// Tonight plans the rest of the night (or the next one, if it's day at
+// now) from the objects opts picks.
+func Tonight(s sky.Site, now time.Time, p Preferences, opts ...repo.QueryOption) (Plan, error) {
+	if p.Slot <= 0 || p.PerSlot <= 0 {
+		return Plan{}, errors.New("slots must be longer than 0 and have room for a target")
+	}
+
+	start, end, ok := s.Night(now, sky.Dark)
+	if !ok {
+		if start, end, ok = s.Night(now, sky.Sunset); !ok {
+			return Plan{}, errors.New("the sun doesn't set tonight")
+		}
+	}
+
+	plan := Plan{Start: start, End: end}
+	if now.After(start) {
+		plan.Start = now
+	}
+
+	mid := plan.Start.Add(plan.End.Sub(plan.Start) / 2)
+	plan.Illumination = sky.Illumination(mid)
+
+	for t := plan.Start; t.Before(plan.End); t = t.Add(p.Slot) {
+		sl := Slot{Start: t, End: t.Add(p.Slot), Targets: []Target{}}
+		if sl.End.After(plan.End) {
+			sl.End = plan.End
+		}
+
+		at := sl.Start.Add(sl.End.Sub(sl.Start) / 2)
+		sl.lst = s.LST(at)
+		sl.moonRA, sl.moonDec = sky.Moon(at)
+		alt, _ := sky.AltAz(sky.HourAngle(sl.moonRA, sl.lst), sl.moonDec, s.Latitude)
+		sl.MoonAltitude = alt * 180 / math.Pi
+		plan.Slots = append(plan.Slots, sl)
+	}
+
+	objs, err := repo.GetObjects(nil, append(opts, repo.Brighter(limitingMagnitude(p.Aperture)))...)
+	if err != nil {
+		return plan, err
+	}
+
+	for _, o := range objs.Objects {
+		ra, dec := o.At(mid)
+		best, slot := Target{Object: o}, -1
+		for i, sl := range plan.Slots {
+			score, alt := p.score(o, ra, dec, s.Latitude, sl, plan.Illumination)
+			if score > best.Score {
+				best.Score, best.SlotAltitude, slot = score, alt, i
+			}
+		}
+
+		if slot >= 0 {
+			plan.Slots[slot].Targets = append(plan.Slots[slot].Targets, best)
+		}
+	}
+
+	for i := range plan.Slots {
+		ts := plan.Slots[i].Targets
+		slices.SortStableFunc(ts, func(a, b Target) int { return cmp.Compare(b.Score, a.Score) })
+		plan.Slots[i].Targets = ts[:min(len(ts), p.PerSlot)]
+	}
+
+	return plan, nil
+}
+
+// score is how good a target o, at ra and dec, is in slot sl: 0 if it's
+// lower than MinAltitude, otherwise its type's weight times its altitude
+// (the sine, so 1 overhead) times how little the moon washes it out
+// times how easily the telescope shows it, which is halfway between how
+// far it is inside the limits for magnitude and for surface brightness.
+// Objects with no surface brightness (stars and others without a size)
+// are easy to see and less bothered by the moon.
+func (p Preferences) score(o repo.Object, ra, dec, lat float64, sl Slot, illumination float64) (score, alt float64) {
+	alt, _ = sky.AltAz(sky.HourAngle(ra, sl.lst), dec, lat)
+	deg := alt * 180 / math.Pi
+	if deg < p.MinAltitude || o.Magnitude == nil {
+		return 0, deg
+	}
+
+	bright := clamp((limitingMagnitude(p.Aperture) - *o.Magnitude) / 5)
+	surface, diffuse := 1.0, 1.0
+	if o.SurfaceBrightness != nil {
+		surface = clamp((surfaceBrightnessLimit(p.Aperture) + 1 - *o.SurfaceBrightness) / 3)
+	} else {
+		diffuse = 0.3
+	}
+
+	moon := 1.0
+	if sl.MoonAltitude > 0 {
+		sep := sky.Separation(ra, dec, sl.moonRA, sl.moonDec)
+		moon -= illumination * (1 + math.Cos(sep)) / 2 * diffuse
+	}
+
+	weight := 1.0
+	if w, ok := p.Types[o.Type]; ok {
+		weight = w
+	}
+
+	return weight * math.Sin(alt) * moon * (bright + surface) / 2, deg
+}
+
+// limitingMagnitude is the faintest star a telescope of aperture
+// millimeters shows under a dark sky.
+func limitingMagnitude(aperture float64) float64 {
+	return 2 + 5*math.Log10(aperture)
+}
+
+// surfaceBrightnessLimit is, roughly, the faintest surface brightness
+// (magnitudes per square arcsecond) an object needs to be to be seen in
+// a telescope of aperture millimeters: a bigger telescope shows a faint
+// object bigger at the same brightness, which makes it easier to see.
+func surfaceBrightnessLimit(aperture float64) float64 {
+	return 21 + 2.5*math.Log10(aperture/50)
+}
+
+func clamp(x float64) float64 {
+	return math.Min(math.Max(x, 0), 1)
+}
@@ -0,0 +1,172 @@
+package recommend
+
+import (
+	"log"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/config"
+	"github.com/cswank/geq/controller/internal/mount"
+	"github.com/cswank/geq/controller/internal/repo"
+	"github.com/cswank/geq/controller/internal/sky"
+)
+
+const rad = math.Pi / 180
+
+// site is 40°N 105°W, keeping mean sidereal time.
+var site = sky.Site{
+	Latitude: 40 * rad,
+	LST: func(t time.Time) float64 {
+		gmst := 280.46061837 + 360.98564736629*(float64(t.UnixMilli())/86400000+2440587.5-2451545)
+		return math.Mod(math.Mod(gmst-105, 360)+360, 360) / 15
+	},
+}
+
+// evening is early on a moonless january night at the site.
+var evening = time.Date(2024, 1, 10, 1, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	cfg := config.Default()
+	mnt, err := mount.New(cfg.Mount, cfg.Site)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := repo.Init(mnt, ""); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	mnt.Close()
+	os.Exit(code)
+}
+
+func prefs(perSlot int) Preferences {
+	return Preferences{Aperture: 200, MinAltitude: 20, Slot: time.Hour, PerSlot: perSlot}
+}
+
+// TestTonight checks that each slot has its best targets, best first,
+// and no more than PerSlot of them.
+func TestTonight(t *testing.T) {
+	all, err := Tonight(site, evening, prefs(10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := Tonight(site, evening, prefs(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Slots) < 10 || len(plan.Slots) != len(all.Slots) {
+		t.Fatalf("a january night has %d slots (%d without a limit)", len(plan.Slots), len(all.Slots))
+	}
+
+	if !plan.Start.After(evening) || plan.End.Sub(plan.Start) < 10*time.Hour {
+		t.Errorf("the night is from %s to %s", plan.Start, plan.End)
+	}
+
+	for i, sl := range plan.Slots {
+		if len(all.Slots[i].Targets) <= 5 {
+			t.Fatalf("slot %d only has %d targets to pick from", i, len(all.Slots[i].Targets))
+		}
+
+		if len(sl.Targets) != 5 {
+			t.Errorf("slot %d has %d targets, want 5", i, len(sl.Targets))
+		}
+
+		for j, tg := range sl.Targets {
+			if tg.ID != all.Slots[i].Targets[j].ID {
+				t.Errorf("target %d of slot %d is %s, want %s", j, i, tg.ID, all.Slots[i].Targets[j].ID)
+			}
+		}
+
+		for j, tg := range all.Slots[i].Targets {
+			if j > 0 && tg.Score > all.Slots[i].Targets[j-1].Score {
+				t.Errorf("%s scores %f in slot %d, more than %s before it", tg.ID, tg.Score, i, all.Slots[i].Targets[j-1].ID)
+			}
+
+			if tg.SlotAltitude < 20 {
+				t.Errorf("%s is in slot %d at %.1f°, lower than 20°", tg.ID, i, tg.SlotAltitude)
+			}
+		}
+	}
+}
+
+// TestSetting checks that an object that sets during the night is only
+// put in a slot it's still up in, and not at all once it's set.
+func TestSetting(t *testing.T) {
+	p := prefs(10)
+
+	// M31 transits at dusk and is below 20° by 2am
+	plan, err := Tonight(site, evening, p, repo.Name("M31"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the name finds what's in M31 too, which isn't needed here
+	m31 := func(sl Slot) (ts []Target) {
+		for _, tg := range sl.Targets {
+			if tg.M != nil && *tg.M == 31 {
+				ts = append(ts, tg)
+			}
+		}
+		return ts
+	}
+
+	var n int
+	for i, sl := range plan.Slots {
+		for _, tg := range m31(sl) {
+			n++
+			ra, dec := tg.At(sl.Start)
+			set := site.Pass(ra, dec, p.MinAltitude*rad, sl.Start).Set
+			if mid := sl.Start.Add(sl.End.Sub(sl.Start) / 2); set == nil || mid.After(*set) {
+				t.Errorf("%s is in slot %d, from %s, after it sets at %v", tg.ID, i, sl.Start.Format(time.TimeOnly), set)
+			}
+		}
+	}
+
+	if n != 1 || len(m31(plan.Slots[0])) != 1 {
+		t.Errorf("M31 is in the plan %d times, want once in the first slot", n)
+	}
+
+	plan, err = Tonight(site, evening.Add(8*time.Hour), p, repo.Name("M31"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, sl := range plan.Slots {
+		if len(m31(sl)) > 0 {
+			t.Errorf("M31 is in slot %d, from %s, after it set", i, sl.Start.Format(time.TimeOnly))
+		}
+	}
+}
+
+// TestTypes checks that a type weighed 0 isn't recommended.
+func TestTypes(t *testing.T) {
+	p := prefs(10000)
+	p.Types = map[string]float64{"G": 0}
+
+	plan, err := Tonight(site, evening, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sl := range plan.Slots {
+		for _, tg := range sl.Targets {
+			if tg.Type == "G" {
+				t.Fatalf("%s is a galaxy, which weigh 0", tg.ID)
+			}
+		}
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	for _, p := range []Preferences{{Slot: 0, PerSlot: 5}, {Slot: time.Hour, PerSlot: 0}} {
+		if _, err := Tonight(site, evening, p); err == nil {
+			t.Errorf("planning with %s slots of %d didn't return an error", p.Slot, p.PerSlot)
+		}
+	}
+}
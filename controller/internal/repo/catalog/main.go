@@ -0,0 +1,1076 @@
+// Command catalog builds the objects database the repo embeds from the
+// OpenNGC csv and an addendum of objects the observing programs need
+// that aren't in it, the messier database, the observing programs' csvs
+// and the bright stars from the HYG database csv
+// (https://github.com/astronexus/HYG-Database), with every object's
+// designations and names indexed for the repo to look objects up by.
+// It's run by go generate in internal/repo:
+//
+//	go run ./catalog -ngc files/NGC.csv -addendum files/addendum.csv -messier files/messier.db -programs files/programs -out files/objects.db
+//
+// The stars are left out until files/hyg.csv, the full HYG csv trimmed to
+// the stars the catalog takes (small enough to commit), is committed:
+//
+//	go run ./catalog -stars hygdata.csv -trim files/hyg.csv
+//
+// and -stars files/hyg.csv is added to the go:generate line in repo.go.
+//
+// The same inputs always produce the same file, so a change to the
+// database in a commit is a change to the catalog.
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cswank/geq/controller/internal/designation"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE objects (
+	id           TEXT PRIMARY KEY,
+	type         TEXT NOT NULL,
+	constelation TEXT,
+	ra           TEXT NOT NULL,
+	dec          TEXT NOT NULL,
+	ra_radians   REAL NOT NULL,
+	dec_radians  REAL NOT NULL,
+	zone         INTEGER NOT NULL,
+	magnitude    REAL,
+	name         TEXT,
+	m            INTEGER,
+	ngc          INTEGER,
+	ic           INTEGER,
+	hr           INTEGER,
+	hip          INTEGER,
+	hd           INTEGER,
+	bayer        TEXT,
+	flamsteed    TEXT,
+	spectral     TEXT,
+	pm_ra        REAL,
+	pm_dec       REAL,
+	maj_ax       REAL,
+	min_ax       REAL,
+	pos_ang      REAL,
+	b_mag        REAL,
+	v_mag        REAL,
+	surf_br      REAL,
+	hubble       TEXT,
+	redshift     REAL,
+	identifiers  TEXT
+);
+CREATE INDEX objects_magnitude ON objects (magnitude);
+CREATE INDEX objects_m ON objects (m);
+CREATE INDEX objects_ngc ON objects (ngc);
+CREATE INDEX objects_ic ON objects (ic);
+CREATE INDEX objects_type ON objects (type);
+CREATE INDEX objects_hr ON objects (hr);
+CREATE INDEX objects_hip ON objects (hip);
+CREATE INDEX objects_maj_ax ON objects (maj_ax);
+CREATE INDEX objects_surf_br ON objects (surf_br);
+CREATE INDEX objects_zone ON objects (zone, ra_radians);
+CREATE TABLE designations (
+	designation TEXT NOT NULL,
+	id          TEXT NOT NULL,
+	PRIMARY KEY (designation, id)
+) WITHOUT ROWID;
+CREATE TABLE programs (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE memberships (
+	program TEXT NOT NULL,
+	entry   TEXT NOT NULL,
+	id      TEXT NOT NULL,
+	number  INTEGER,
+	PRIMARY KEY (program, entry)
+) WITHOUT ROWID;
+CREATE INDEX memberships_id ON memberships (id);
+CREATE VIRTUAL TABLE search USING fts5 (id UNINDEXED, names, designations, tokenize = 'unicode61 remove_diacritics 2');
+`
+
+// zoneHeight is how many degrees of declination each of the zones objects
+// are put in for cone searches covers; the repo has to agree.
+const zoneHeight = 1.0
+
+var (
+	ngcPth      = flag.String("ngc", "files/NGC.csv", "OpenNGC csv")
+	addendumPth = flag.String("addendum", "files/addendum.csv", "csv of more objects, in the same form as OpenNGC's")
+	messierPth  = flag.String("messier", "files/messier.db", "messier database")
+	programsPth = flag.String("programs", "files/programs", "directory of observing program csvs")
+	starsPth    = flag.String("stars", "", "HYG database csv (no stars if empty)")
+	starsMag    = flag.Float64("stars-mag", 6.5, "faintest star to take from the HYG csv")
+	trimPth     = flag.String("trim", "", "write the stars of the HYG csv no fainter than stars-mag to this csv instead of building the database")
+	outPth      = flag.String("out", "files/objects.db", "database to write")
+
+	// whole is the designation of a whole NGC or IC object, not one of
+	// the parts (NGC0001 NED01) or lettered neighbours (IC0186A) OpenNGC
+	// lists.
+	whole = regexp.MustCompile(`^(NGC|IC)(\d{4})$`)
+
+	// number is a cross id of a whole object; OpenNGC cross ids can also
+	// be parts of one (4414A, 3058 NED02).
+	number = regexp.MustCompile(`^\d{4}$`)
+
+	// messierTypes are the messier database's types as OpenNGC types.
+	messierTypes = map[string]string{
+		"OpCl": "OCl",
+		"GlCl": "GCl",
+		"Sp G": "G",
+		"El G": "G",
+		"S0 G": "G",
+		"IrrG": "G",
+		"DifN": "Neb",
+		"Pl N": "PN",
+		"SNR":  "SNR",
+		"MWP":  "*Ass",
+		"2 St": "**",
+		"4 St": "Other",
+	}
+
+	// programs are the observing programs.  Messier's are the objects
+	// with messier numbers and the rest are read from csvs (of number,
+	// designation) in the programs directory.  Objects get a designation
+	// of prefix and their number in a program that has one (C 14).
+	programs = []program{
+		{id: "messier", name: "Messier", prefix: "M"},
+		{id: "caldwell", name: "Caldwell", prefix: "C", file: "caldwell.csv"},
+		{id: "herschel400", name: "Herschel 400", file: "herschel400.csv"},
+	}
+
+	// constellations are OpenNGC's and the messier database's names for
+	// the halves of Serpens.
+	constellations = map[string]string{
+		"Se1":    "Ser",
+		"Se2":    "Ser",
+		"SerCap": "Ser",
+		"SerCau": "Ser",
+	}
+)
+
+type (
+	object struct {
+		id            string
+		typ           string
+		constellation string
+		ra, dec       string
+		raRadians     float64
+		decRadians    float64
+		magnitude     *float64
+		names         []string
+		m, ngc, ic    *int
+
+		// OpenNGC's physical data: the axes in arcminutes, position
+		// angle in degrees, surface brightness in magnitudes per square
+		// arcsecond and other catalogs' ids (PGC 002557, UGC 00454...).
+		majAx, minAx, posAng *float64
+		bMag, vMag           *float64
+		surfBr               *float64
+		hubble               string
+		redshift             *float64
+		identifiers          []string
+
+		// stars have catalog numbers, designations ("Alp CMa" and
+		// "9 CMa"), a spectral type and a proper motion in
+		// milliarcseconds a year (in ra times cos dec).
+		hr, hip, hd      *int
+		bayer, flamsteed string
+		spectral         string
+		pmRA, pmDec      *float64
+
+		memberships []membership
+	}
+
+	program struct {
+		id, name, prefix, file string
+	}
+
+	// membership is an object's entry in a program, as the program
+	// lists it (normalized), and its number in it if the program numbers
+	// its objects.  An object can be more than one entry: the Herschel
+	// 400 has NGC 6882 and NGC 6885, which are the same cluster.
+	membership struct {
+		program *program
+		entry   string
+		number  *int
+	}
+
+	messier struct {
+		m             int
+		ngc           *int
+		typ           string
+		constellation string
+		ra, dec       string
+		magnitude     *float64
+		name          *string
+	}
+)
+
+func main() {
+	flag.Parse()
+
+	if *trimPth != "" {
+		n, err := trimStars(*starsPth, *trimPth, *starsMag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %d stars to %s", n, *trimPth)
+		return
+	}
+
+	objs, err := readNGC(*ngcPth)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	more, err := readNGC(*addendumPth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	objs = append(objs, more...)
+
+	ms, err := readMessier(*messierPth)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	objs, err = crossMessier(objs, ms)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *starsPth != "" {
+		stars, err := readStars(*starsPth, *starsMag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		objs = append(objs, stars...)
+	}
+
+	slices.SortFunc(objs, func(a, b *object) int { return cmp.Compare(a.id, b.id) })
+	if err := readPrograms(*programsPth, objs); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := write(*outPth, objs); err != nil {
+		log.Fatal(err)
+	}
+
+	types := map[string]int{}
+	for _, o := range objs {
+		types[o.typ]++
+	}
+	log.Printf("wrote %d objects to %s: %v", len(objs), *outPth, types)
+}
+
+// readNGC reads the OpenNGC csv.  Objects OpenNGC says don't exist or
+// have no position are left out, and duplicates are folded into the
+// object they duplicate as cross ids.
+func readNGC(pth string) ([]*object, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ';'
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s header: %w", pth, err)
+	}
+
+	col := map[string]int{}
+	for i, name := range header {
+		col[name] = i
+	}
+
+	for _, name := range []string{"Name", "Type", "RA", "Dec", "Const", "MajAx", "MinAx", "PosAng", "V-Mag", "B-Mag", "SurfBr", "Hubble", "Redshift", "M", "NGC", "IC", "Identifiers", "Common names"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("%s has no %s column", pth, name)
+		}
+	}
+
+	var objs []*object
+	byID := map[string]*object{}
+	var dups [][]string
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", pth, err)
+		}
+
+		get := func(name string) string { return strings.TrimSpace(rec[col[name]]) }
+
+		switch get("Type") {
+		case "NonEx":
+			continue
+		case "Dup":
+			dups = append(dups, rec)
+			continue
+		}
+
+		if get("RA") == "" || get("Dec") == "" {
+			continue
+		}
+
+		o := &object{
+			id:            get("Name"),
+			typ:           get("Type"),
+			constellation: constellation(get("Const")),
+			ra:            get("RA"),
+			dec:           get("Dec"),
+			names:         splitNames(get("Common names")),
+			hubble:        get("Hubble"),
+			identifiers:   splitNames(get("Identifiers")),
+		}
+
+		if o.raRadians, err = sexagesimal(o.ra, 15); err != nil {
+			return nil, fmt.Errorf("%s: bad ra: %w", o.id, err)
+		}
+
+		if o.decRadians, err = sexagesimal(o.dec, 1); err != nil {
+			return nil, fmt.Errorf("%s: bad dec: %w", o.id, err)
+		}
+
+		for _, c := range []struct {
+			name string
+			dst  **float64
+		}{{"MajAx", &o.majAx}, {"MinAx", &o.minAx}, {"PosAng", &o.posAng}, {"B-Mag", &o.bMag}, {"V-Mag", &o.vMag}, {"SurfBr", &o.surfBr}, {"Redshift", &o.redshift}} {
+			if *c.dst, err = optFloat(get(c.name)); err != nil {
+				return nil, fmt.Errorf("%s: bad %s: %w", o.id, c.name, err)
+			}
+		}
+
+		o.magnitude = cmp.Or(o.vMag, o.bMag)
+		if o.surfBr == nil {
+			o.surfBr = o.meanSurfaceBrightness()
+		}
+
+		if o.m, err = optInt(get("M")); err != nil {
+			return nil, fmt.Errorf("%s: bad messier number: %w", o.id, err)
+		}
+
+		if err := o.crossIDs(o.id, get("NGC"), get("IC")); err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, o)
+		byID[o.id] = o
+	}
+
+	for _, rec := range dups {
+		get := func(name string) string { return strings.TrimSpace(rec[col[name]]) }
+		id := get("Name")
+
+		// a duplicate names the object it duplicates in the other
+		// catalog's column
+		var target *object
+		if n := get("NGC"); n != "" {
+			target = byID["NGC"+n]
+		} else if n := get("IC"); n != "" {
+			target = byID["IC"+n]
+		}
+
+		if target == nil {
+			continue
+		}
+
+		if err := target.crossIDs(id, "", ""); err != nil {
+			return nil, err
+		}
+
+		// so the duplicate's designation finds the object
+		target.identifiers = append(target.identifiers, id)
+
+		m, err := optInt(get("M"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad messier number: %w", id, err)
+		}
+
+		if target.m == nil {
+			target.m = m
+		}
+		target.names = append(target.names, splitNames(get("Common names"))...)
+		target.identifiers = append(target.identifiers, splitNames(get("Identifiers"))...)
+	}
+
+	return objs, nil
+}
+
+// meanSurfaceBrightness works out a surface brightness for an object
+// OpenNGC doesn't give one (it only has them for galaxies) from its
+// magnitude spread evenly over the ellipse of its axes.  It's only a
+// guide: OpenNGC's are in B inside the 25th magnitude isophote.
+func (o *object) meanSurfaceBrightness() *float64 {
+	if o.magnitude == nil || o.majAx == nil {
+		return nil
+	}
+
+	minAx := cmp.Or(o.minAx, o.majAx)
+	area := math.Pi / 4 * *o.majAx * *minAx * 3600 // square arcseconds
+	if area <= 0 {
+		return nil
+	}
+
+	sb := math.Round((*o.magnitude+2.5*math.Log10(area))*100) / 100
+	return &sb
+}
+
+// crossIDs sets the NGC and IC numbers of o from the designation id and
+// the cross ids ngc and ic, without replacing any it already has.
+func (o *object) crossIDs(id, ngc, ic string) error {
+	if d := whole.FindStringSubmatch(id); d != nil {
+		if d[1] == "NGC" {
+			ngc = cmp.Or(d[2], ngc)
+		} else {
+			ic = cmp.Or(d[2], ic)
+		}
+	}
+
+	for _, c := range []struct {
+		n   string
+		dst **int
+	}{{ngc, &o.ngc}, {ic, &o.ic}} {
+		// there can be more than one cross id, the first is the main one
+		n, _, _ := strings.Cut(c.n, ",")
+		if !number.MatchString(n) || *c.dst != nil {
+			continue
+		}
+
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return fmt.Errorf("%s: bad cross id %q: %w", id, n, err)
+		}
+		*c.dst = &i
+	}
+
+	return nil
+}
+
+func readMessier(pth string) ([]messier, error) {
+	db, err := sql.Open("sqlite", "file:"+pth+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT m, ngc, mtype, constellation, ra, decl, magnitude, name FROM messier ORDER BY m, ngc")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", pth, err)
+	}
+	defer rows.Close()
+
+	var ms []messier
+	for rows.Next() {
+		var m messier
+		if err := rows.Scan(&m.m, &m.ngc, &m.typ, &m.constellation, &m.ra, &m.dec, &m.magnitude, &m.name); err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", pth, err)
+		}
+		ms = append(ms, m)
+	}
+
+	return ms, rows.Err()
+}
+
+// crossMessier gives the messier numbers OpenNGC doesn't to the NGC
+// objects the messier database says they are (M102, which might be
+// M101, goes to the candidate that isn't already a messier object) and
+// adds the ones with no NGC number (M40 and M45) as objects of their
+// own.  Messier objects without a common name get the messier
+// database's.
+func crossMessier(objs []*object, ms []messier) ([]*object, error) {
+	byM := map[int]*object{}
+	byNGC := map[int]*object{}
+	for _, o := range objs {
+		if o.m != nil {
+			byM[*o.m] = o
+		}
+
+		if o.ngc != nil && whole.MatchString(o.id) {
+			byNGC[*o.ngc] = o
+		}
+	}
+
+	for _, m := range ms {
+		o, ok := byM[m.m]
+		if !ok && m.ngc != nil {
+			if c := byNGC[*m.ngc]; c != nil && c.m == nil {
+				o = c
+				o.m = &m.m
+				byM[m.m] = o
+			}
+		}
+
+		if o == nil && m.ngc == nil {
+			var err error
+			if o, err = m.object(); err != nil {
+				return nil, err
+			}
+
+			objs = append(objs, o)
+			byM[m.m] = o
+		}
+
+		if o != nil && len(o.names) == 0 && m.name != nil && (m.ngc == nil || (o.ngc != nil && *o.ngc == *m.ngc)) {
+			o.names = []string{*m.name}
+		}
+	}
+
+	return objs, nil
+}
+
+// object makes an object of a messier database entry, which has its
+// position to a tenth of a minute (hh:mm.m and dd:mm).  The position is
+// written out the way OpenNGC's are.
+func (m messier) object() (*object, error) {
+	typ, ok := messierTypes[m.typ]
+	if !ok {
+		return nil, fmt.Errorf("M%d: unknown type %q", m.m, m.typ)
+	}
+
+	ra, err := sexagesimal(m.ra, 15)
+	if err != nil {
+		return nil, fmt.Errorf("M%d: bad ra: %w", m.m, err)
+	}
+
+	dec, err := sexagesimal(m.dec, 1)
+	if err != nil {
+		return nil, fmt.Errorf("M%d: bad dec: %w", m.m, err)
+	}
+
+	return &object{
+		id:            fmt.Sprintf("M%d", m.m),
+		typ:           typ,
+		constellation: constellation(m.constellation),
+		ra:            sexagesimalString(ra/15, 2, ""),
+		dec:           sexagesimalString(dec, 1, "+"),
+		raRadians:     ra,
+		decRadians:    dec,
+		magnitude:     m.magnitude,
+		m:             &m.m,
+	}, nil
+}
+
+// readStars reads the stars no fainter than limit from the HYG csv, whose
+// positions are J2000 at epoch 2000.  A star is named by its HR number,
+// or its HIP number if it has none (HR2491 is Sirius); the ones with
+// neither (the sun) are left out, as is a component with the same number
+// as a brighter one.
+func readStars(pth string, limit float64) ([]*object, error) {
+	f, err := os.Open(pth)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: download hygdata.csv from https://github.com/astronexus/HYG-Database and trim it with -stars hygdata.csv -trim %s", err, pth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s header: %w", pth, err)
+	}
+
+	col := map[string]int{}
+	for i, name := range header {
+		col[name] = i
+	}
+
+	for _, name := range []string{"hip", "hd", "hr", "proper", "ra", "dec", "pmra", "pmdec", "mag", "spect", "bayer", "flam", "con"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("%s has no %s column", pth, name)
+		}
+	}
+
+	var stars []*object
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", pth, err)
+		}
+
+		get := func(name string) string { return strings.TrimSpace(rec[col[name]]) }
+
+		mag, err := strconv.ParseFloat(get("mag"), 64)
+		if err != nil {
+			line, _ := r.FieldPos(col["mag"])
+			return nil, fmt.Errorf("%s line %d: bad magnitude: %w", pth, line, err)
+		}
+
+		if mag > limit {
+			continue
+		}
+
+		o := &object{
+			typ:           "Star",
+			constellation: constellation(get("con")),
+			magnitude:     &mag,
+			vMag:          &mag,
+			names:         splitNames(get("proper")),
+			spectral:      get("spect"),
+		}
+
+		for _, c := range []struct {
+			name string
+			dst  **int
+		}{{"hr", &o.hr}, {"hip", &o.hip}, {"hd", &o.hd}} {
+			if *c.dst, err = optInt(get(c.name)); err != nil {
+				line, _ := r.FieldPos(col[c.name])
+				return nil, fmt.Errorf("%s line %d: bad %s number: %w", pth, line, c.name, err)
+			}
+		}
+
+		switch {
+		case o.hr != nil:
+			o.id = fmt.Sprintf("HR%d", *o.hr)
+		case o.hip != nil:
+			o.id = fmt.Sprintf("HIP%d", *o.hip)
+		default:
+			continue
+		}
+
+		ra, err := strconv.ParseFloat(get("ra"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad ra: %w", o.id, err)
+		}
+
+		dec, err := strconv.ParseFloat(get("dec"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad dec: %w", o.id, err)
+		}
+
+		o.raRadians = ra * 15 * math.Pi / 180
+		o.decRadians = dec * math.Pi / 180
+		o.ra = sexagesimalString(o.raRadians/15, 2, "")
+		o.dec = sexagesimalString(o.decRadians, 1, "+")
+
+		if o.pmRA, err = optFloat(get("pmra")); err != nil {
+			return nil, fmt.Errorf("%s: bad proper motion: %w", o.id, err)
+		}
+
+		if o.pmDec, err = optFloat(get("pmdec")); err != nil {
+			return nil, fmt.Errorf("%s: bad proper motion: %w", o.id, err)
+		}
+
+		if b := get("bayer"); b != "" && o.constellation != "" {
+			o.bayer = b + " " + o.constellation
+		}
+
+		if n := get("flam"); n != "" && o.constellation != "" {
+			o.flamsteed = n + " " + o.constellation
+		}
+
+		stars = append(stars, o)
+	}
+
+	slices.SortStableFunc(stars, func(a, b *object) int { return cmp.Compare(*a.magnitude, *b.magnitude) })
+
+	seen := map[string]bool{}
+	var out []*object
+	for _, o := range stars {
+		if !seen[o.id] {
+			seen[o.id] = true
+			out = append(out, o)
+		}
+	}
+
+	return out, nil
+}
+
+// trimStars copies the header of the HYG csv at in, and the rows of the
+// stars no fainter than limit, to out.  It returns how many stars it
+// copied.
+func trimStars(in, out string, limit float64) (int, error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %s header: %w", in, err)
+	}
+
+	mag := slices.Index(header, "mag")
+	if mag < 0 {
+		return 0, fmt.Errorf("%s has no mag column", in)
+	}
+
+	o, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer o.Close()
+
+	w := csv.NewWriter(o)
+	w.Write(header)
+	var n int
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return n, fmt.Errorf("unable to read %s: %w", in, err)
+		}
+
+		m, err := strconv.ParseFloat(strings.TrimSpace(rec[mag]), 64)
+		if err != nil {
+			line, _ := r.FieldPos(mag)
+			return n, fmt.Errorf("%s line %d: bad magnitude: %w", in, line, err)
+		}
+
+		if m > limit {
+			continue
+		}
+
+		w.Write(rec)
+		n++
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return n, err
+	}
+	return n, o.Close()
+}
+
+// readPrograms makes the objects members of the programs.  An entry in a
+// program's csv is the object it's a designation of (see Resolve in the
+// repo); entries that aren't in the catalog are left out.
+func readPrograms(dir string, objs []*object) error {
+	byDesignation := map[string][]*object{}
+	for _, o := range objs {
+		for _, d := range o.designations() {
+			byDesignation[d] = append(byDesignation[d], o)
+		}
+	}
+
+	for i := range programs {
+		p := &programs[i]
+		if p.file == "" {
+			for _, o := range objs {
+				if o.m != nil {
+					o.memberships = append(o.memberships, membership{program: p, entry: fmt.Sprintf("M%d", *o.m), number: o.m})
+				}
+			}
+			continue
+		}
+
+		pth := filepath.Join(dir, p.file)
+		f, err := os.Open(pth)
+		if err != nil {
+			return err
+		}
+
+		recs, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", pth, err)
+		}
+
+		if len(recs) == 0 || !slices.Equal(recs[0], []string{"number", "designation"}) {
+			return fmt.Errorf("%s must start with a number,designation header", pth)
+		}
+
+		for _, rec := range recs[1:] {
+			n, err := optInt(rec[0])
+			if err != nil {
+				return fmt.Errorf("%s: bad number for %s: %w", pth, rec[1], err)
+			}
+
+			o := resolve(byDesignation, rec[1])
+			if o == nil {
+				log.Printf("%s: %s isn't in the catalog", p.name, rec[1])
+				continue
+			}
+
+			entry := designation.Normalize(rec[1])
+			if slices.ContainsFunc(o.memberships, func(m membership) bool { return m.program == p && m.entry == entry }) {
+				continue
+			}
+			o.memberships = append(o.memberships, membership{program: p, entry: entry, number: n})
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the object d is a designation of: the one whose id it
+// is, or else the first with it.
+func resolve(byDesignation map[string][]*object, d string) *object {
+	d = designation.Normalize(d)
+	cands := byDesignation[d]
+	for _, o := range cands {
+		if designation.Normalize(o.id) == d {
+			return o
+		}
+	}
+
+	if len(cands) == 0 {
+		return nil
+	}
+	return cands[0]
+}
+
+// write writes objs to a new database at pth.  It's built next to pth
+// and moved over it once it's finished.
+func write(pth string, objs []*object) error {
+	tmp := pth + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+tmp)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(schema); err != nil {
+		return fmt.Errorf("unable to create objects table: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO objects (id, type, constelation, ra, dec, ra_radians, dec_radians, zone, magnitude, name, m, ngc, ic, hr, hip, hd, bayer, flamsteed, spectral, pm_ra, pm_dec, maj_ax, min_ax, pos_ang, b_mag, v_mag, surf_br, hubble, redshift, identifiers) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	des, err := tx.Prepare("INSERT OR IGNORE INTO designations (designation, id) VALUES (?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, p := range programs {
+		if _, err := tx.Exec("INSERT INTO programs (id, name) VALUES (?, ?)", p.id, p.name); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to insert program %s: %w", p.id, err)
+		}
+	}
+
+	member, err := tx.Prepare("INSERT INTO memberships (program, entry, id, number) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	search, err := tx.Prepare("INSERT INTO search (id, names, designations) VALUES (?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, o := range objs {
+		var name, con *string
+		if len(o.names) > 0 {
+			s := strings.Join(unique(o.names), ", ")
+			name = &s
+		}
+
+		if o.constellation != "" {
+			con = &o.constellation
+		}
+
+		if _, err := stmt.Exec(o.id, o.typ, con, o.ra, o.dec, o.raRadians, o.decRadians, zone(o.decRadians), o.magnitude, name, o.m, o.ngc, o.ic, o.hr, o.hip, o.hd, optString(o.bayer), optString(o.flamsteed), optString(o.spectral), o.pmRA, o.pmDec, o.majAx, o.minAx, o.posAng, o.bMag, o.vMag, o.surfBr, optString(o.hubble), o.redshift, optString(strings.Join(unique(o.identifiers), ", "))); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to insert %s: %w", o.id, err)
+		}
+
+		for _, m := range o.memberships {
+			if _, err := member.Exec(m.program.id, m.entry, o.id, m.number); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("unable to insert %s's programs: %w", o.id, err)
+			}
+		}
+
+		ds := o.designations()
+		for _, d := range ds {
+			if _, err := des.Exec(d, o.id); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("unable to insert %s's designations: %w", o.id, err)
+			}
+		}
+
+		if _, err := search.Exec(o.id, strings.Join(unique(o.names), ", "), strings.Join(ds, " ")); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to index %s: %w", o.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// vacuum leaves the pages in the same order for the same rows
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return err
+	}
+
+	if err := db.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, pth)
+}
+
+// designations are all of o's designations, its common names and its
+// ids in other catalogs, normalized (see designation.Normalize) for the
+// repo to look them up by.
+func (o *object) designations() []string {
+	ds := []string{o.id, o.bayer, o.flamsteed}
+	for _, c := range []struct {
+		catalog string
+		n       *int
+	}{{"M", o.m}, {"NGC", o.ngc}, {"IC", o.ic}, {"HR", o.hr}, {"HIP", o.hip}, {"HD", o.hd}} {
+		if c.n != nil {
+			ds = append(ds, fmt.Sprintf("%s %d", c.catalog, *c.n))
+		}
+	}
+	for _, m := range o.memberships {
+		if m.program.prefix != "" && m.number != nil {
+			ds = append(ds, fmt.Sprintf("%s %d", m.program.prefix, *m.number))
+		}
+	}
+	ds = append(ds, o.identifiers...)
+	ds = append(ds, o.names...)
+
+	var out []string
+	for _, d := range ds {
+		if d = designation.Normalize(d); d != "" {
+			out = append(out, d)
+		}
+	}
+	return unique(out)
+}
+
+// zone is the declination zone dec (radians) is in.  The north pole
+// belongs to the zone below it.
+func zone(dec float64) int {
+	return min(int(math.Floor((dec*180/math.Pi+90)/zoneHeight)), int(180/zoneHeight)-1)
+}
+
+func constellation(s string) string {
+	if c, ok := constellations[s]; ok {
+		return c
+	}
+	return s
+}
+
+// unique returns names without repeats, in the order they first appear.
+func unique(names []string) []string {
+	var out []string
+	for _, n := range names {
+		if !slices.Contains(out, n) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func splitNames(s string) []string {
+	var names []string
+	for _, n := range strings.Split(s, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// sexagesimal reads s (h:m:s, d:m:s, h:m.m or d:m, with an optional
+// sign) in radians; scale is degrees per unit of the first field.
+func sexagesimal(s string, scale float64) (float64, error) {
+	sign := 1.0
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+
+	var x float64
+	div := 1.0
+	for _, f := range strings.Split(strings.TrimLeft(s, "+-"), ":") {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return 0, err
+		}
+		x += v / div
+		div *= 60
+	}
+
+	return sign * x * scale * math.Pi / 180, nil
+}
+
+// sexagesimalString writes rads in degrees (or, divided by 15, in hours)
+// the way OpenNGC does, 05:34:31.97 and +22:00:52.1, with digits
+// decimals of seconds and plus as the sign of positive values.
+func sexagesimalString(rads float64, digits int, plus string) string {
+	sign := plus
+	if rads < 0 {
+		sign = "-"
+	}
+
+	x := math.Abs(rads) * 180 / math.Pi
+	// rounded to the last digit first so 59.995 seconds carries
+	p := math.Pow(10, float64(digits))
+	s := math.Round(x*3600*p) / p
+	return fmt.Sprintf("%s%02d:%02d:%0*.*f", sign, int(s/3600), int(math.Mod(s, 3600)/60), digits+3, digits, math.Mod(s, 60))
+}
+
+func optString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func optFloat(s string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	return &f, err
+}
+
+func optInt(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	return &i, err
+}
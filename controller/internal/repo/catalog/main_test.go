@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadStars(t *testing.T) {
+	stars, err := readStars("testdata/hyg.csv", 6.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// brightest first, without the sun, Sirius B (which has Sirius'
+	// numbers) or the star fainter than the limit
+	var ids []string
+	for _, s := range stars {
+		ids = append(ids, s.id)
+	}
+
+	want := []string{"HR2491", "HR7001", "HR4301", "HIP21051"}
+	if !slices.Equal(ids, want) {
+		t.Fatalf("got stars %q, want %q", ids, want)
+	}
+
+	vega := stars[1]
+	if vega.names[0] != "Vega" || vega.constellation != "Lyr" || vega.spectral != "A0Vvar" || *vega.magnitude != 0.03 {
+		t.Errorf("vega is %q in %s, %s, magnitude %f", vega.names, vega.constellation, vega.spectral, *vega.magnitude)
+	}
+
+	if math.Abs(vega.raRadians-4.873563) > 1e-6 || math.Abs(vega.decRadians-0.676903) > 1e-6 {
+		t.Errorf("vega is at %f, %f", vega.raRadians, vega.decRadians)
+	}
+
+	ds := vega.designations()
+	for _, d := range []string{"HR7001", "HIP91262", "HD172167", "ALPLYR", "3LYR", "VEGA"} {
+		if !slices.Contains(ds, d) {
+			t.Errorf("vega's designations %q don't have %s", ds, d)
+		}
+	}
+}
+
+func TestTrimStars(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hyg.csv")
+	n, err := trimStars("testdata/hyg.csv", out, 6.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sun is bright enough, it's readStars that leaves it out
+	if n != 5 {
+		t.Errorf("trimmed to %d stars, want 5", n)
+	}
+
+	// what's trimmed reads the same as what it was trimmed from
+	all, err := readStars("testdata/hyg.csv", 6.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trimmed, err := readStars(out, 6.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trimmed) != len(all) {
+		t.Fatalf("read %d stars from the trimmed csv, want %d", len(trimmed), len(all))
+	}
+
+	for i := range all {
+		if trimmed[i].id != all[i].id || trimmed[i].raRadians != all[i].raRadians {
+			t.Errorf("star %d is %s, want %s", i, trimmed[i].id, all[i].id)
+		}
+	}
+
+	if _, err := trimStars(filepath.Join(t.TempDir(), "missing.csv"), out, 6.5); !os.IsNotExist(err) {
+		t.Errorf("trimming a missing csv returned %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/parsyl/sqrl"
+)
+
+// userSchema is the user's database.  Objects in it are the catalog's
+// ids, which are joined to the catalog when they're read.
+const userSchema = `
+CREATE TABLE IF NOT EXISTS user.lists (
+	id      INTEGER PRIMARY KEY,
+	name    TEXT NOT NULL,
+	notes   TEXT NOT NULL DEFAULT '',
+	created TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS user.list_objects (
+	list     INTEGER NOT NULL,
+	id       TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	notes    TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (list, id)
+);
+`
+
+// ErrNotInList is returned for lists, and objects in lists, that don't
+// exist.
+var ErrNotInList = errors.New("no such list or object in the list")
+
+type (
+	// List is an observing list.  Size is how many objects are in it,
+	// and Entries are the objects (with notes about each), in order, when
+	// it's read by GetList.
+	List struct {
+		ID      int64     `json:"id"`
+		Name    string    `json:"name"`
+		Notes   string    `json:"notes"`
+		Created time.Time `json:"created"`
+		Size    int       `json:"size"`
+		Entries []Entry   `json:"entries,omitempty"`
+	}
+
+	Entry struct {
+		Object Object `json:"object"`
+		Notes  string `json:"notes"`
+	}
+)
+
+// Lists returns the observing lists, oldest first.
+func Lists() ([]List, error) {
+	rows, err := db.Query("SELECT lists.id, name, lists.notes, created, count(list_objects.id) FROM user.lists LEFT JOIN user.list_objects ON list_objects.list = lists.id GROUP BY lists.id ORDER BY created, lists.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ls := []List{}
+	for rows.Next() {
+		var l List
+		if err := rows.Scan(&l.ID, &l.Name, &l.Notes, &l.Created, &l.Size); err != nil {
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+
+	return ls, rows.Err()
+}
+
+// GetList returns the observing list with the id and its objects.
+func GetList(id int64) (l List, err error) {
+	err = db.QueryRow("SELECT id, name, notes, created FROM user.lists WHERE id = ?", id).Scan(&l.ID, &l.Name, &l.Notes, &l.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return l, ErrNotInList
+	}
+	if err != nil {
+		return l, err
+	}
+
+	notes := map[string]string{}
+	rows, err := db.Query("SELECT id, notes FROM user.list_objects WHERE list = ?", id)
+	if err != nil {
+		return l, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var obj, n string
+		if err := rows.Scan(&obj, &n); err != nil {
+			return l, err
+		}
+		notes[obj] = n
+	}
+
+	if err := rows.Err(); err != nil {
+		return l, err
+	}
+
+	objs, err := GetObjects(nil, InList(id))
+	if err != nil {
+		return l, err
+	}
+
+	l.Entries = []Entry{}
+	for _, o := range objs.Objects {
+		l.Entries = append(l.Entries, Entry{Object: o, Notes: notes[o.ID]})
+	}
+	l.Size = len(l.Entries)
+
+	return l, nil
+}
+
+// CreateList makes a new, empty, observing list.
+func CreateList(name, notes string) (List, error) {
+	l := List{Name: name, Notes: notes, Created: mnt.Now()}
+	res, err := db.Exec("INSERT INTO user.lists (name, notes, created) VALUES (?, ?, ?)", l.Name, l.Notes, l.Created)
+	if err != nil {
+		return l, err
+	}
+
+	l.ID, err = res.LastInsertId()
+	return l, err
+}
+
+// UpdateList renames the observing list with the id and replaces its
+// notes.
+func UpdateList(id int64, name, notes string) error {
+	return changed(db.Exec("UPDATE user.lists SET name = ?, notes = ? WHERE id = ?", name, notes, id))
+}
+
+// DeleteList deletes the observing list with the id.
+func DeleteList(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user.list_objects WHERE list = ?", id); err != nil {
+		return err
+	}
+
+	if err := changed(tx.Exec("DELETE FROM user.lists WHERE id = ?", id)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddToList adds the object with the designation obj (see Resolve) to
+// the end of the observing list with the id, or, if it's already in it,
+// replaces its notes.  It returns the object's id.
+func AddToList(id int64, obj, notes string) (string, error) {
+	oid, err := resolve(obj)
+	if err != nil {
+		return "", err
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM user.lists WHERE id = ?)", id).Scan(&exists); err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", ErrNotInList
+	}
+
+	_, err = db.Exec(`INSERT INTO user.list_objects (list, id, position, notes)
+SELECT ?, ?, coalesce(max(position), 0) + 1, ? FROM user.list_objects WHERE list = ?
+ON CONFLICT (list, id) DO UPDATE SET notes = excluded.notes`, id, oid, notes, id)
+	return oid, err
+}
+
+// RemoveFromList takes the object with the designation obj (see Resolve)
+// out of the observing list with the id.
+func RemoveFromList(id int64, obj string) error {
+	oid, err := resolve(obj)
+	if err != nil {
+		return err
+	}
+
+	return changed(db.Exec("DELETE FROM user.list_objects WHERE list = ? AND id = ?", id, oid))
+}
+
+// ReorderList puts the objects in the observing list with the id in the
+// order of objs, designations (see Resolve) of every object in the list.
+func ReorderList(id int64, objs []string) error {
+	ids := make([]string, len(objs))
+	for i, obj := range objs {
+		oid, err := resolve(obj)
+		if err != nil {
+			return err
+		}
+		ids[i] = oid
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow("SELECT count(*) FROM user.list_objects WHERE list = ?", id).Scan(&n); err != nil {
+		return err
+	}
+
+	if n != len(objs) {
+		return fmt.Errorf("%w: the list has %d objects, not %d", ErrInvalid, n, len(objs))
+	}
+
+	seen := map[string]bool{}
+	for i, oid := range ids {
+		if seen[oid] {
+			return fmt.Errorf("%w: %s is in the order twice", ErrInvalid, objs[i])
+		}
+		seen[oid] = true
+
+		if err := changed(tx.Exec("UPDATE user.list_objects SET position = ? WHERE list = ? AND id = ?", i+1, id, oid)); err != nil {
+			return fmt.Errorf("%s: %w", objs[i], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// resolve returns the id of the object obj designates, the first Resolve
+// finds, or sql.ErrNoRows if there's none.
+func resolve(obj string) (string, error) {
+	ids, err := Resolve(obj)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ids) == 0 {
+		return "", fmt.Errorf("there's no object %q: %w", obj, sql.ErrNoRows)
+	}
+	return ids[0], nil
+}
+
+// InList picks the objects in the observing list with the id, in the
+// list's order.
+func InList(id int64) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		sel.Join("(SELECT id, position FROM user.list_objects WHERE list = ?) USING (id)", id).
+			OrderBy("position")
+	}
+}
+
+// changed is ErrNotInList if the statement that returned res didn't
+// change anything.
+func changed(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotInList
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/parsyl/sqrl"
+)
+
+// observationsSchema is the logbook in the user's database.
+const observationsSchema = `
+CREATE TABLE IF NOT EXISTS user.observations (
+	id           INTEGER PRIMARY KEY,
+	object       TEXT NOT NULL,
+	time         TIMESTAMP NOT NULL,
+	latitude     REAL NOT NULL,
+	longitude    REAL NOT NULL,
+	elevation    REAL NOT NULL,
+	equipment    TEXT NOT NULL DEFAULT '',
+	aperture     REAL,
+	focal_length REAL,
+	seeing       INTEGER,
+	transparency INTEGER,
+	rating       INTEGER,
+	notes        TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS user.observations_object ON observations (object, time);
+CREATE INDEX IF NOT EXISTS user.observations_time ON observations (time);
+`
+
+// ErrNoObservation is returned for observations that aren't in the
+// logbook.
+var ErrNoObservation = errors.New("no such observation")
+
+// ErrInvalid is wrapped by the errors for observations, and list
+// orders, that can't be taken as they are.
+var ErrInvalid = errors.New("invalid")
+
+// Observation is an entry in the logbook: the object (its id), when and
+// where (in degrees, east longitude positive, and meters) it was seen
+// from and with what (Aperture and FocalLength in millimeters).  Seeing
+// is on the Antoniadi scale, 1 (perfect) to 5 (very bad), Transparency
+// from 1 (poor) to 5 (excellent) and Rating, how good a view it was,
+// from 1 to 5.
+type Observation struct {
+	ID           int64     `json:"id"`
+	Object       string    `json:"object"`
+	Time         time.Time `json:"time"`
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	Elevation    float64   `json:"elevation"`
+	Equipment    string    `json:"equipment"`
+	Aperture     *float64  `json:"aperture"`
+	FocalLength  *float64  `json:"focal_length"`
+	Seeing       *int      `json:"seeing"`
+	Transparency *int      `json:"transparency"`
+	Rating       *int      `json:"rating"`
+	Notes        string    `json:"notes"`
+}
+
+var observationColumns = []string{"id", "object", "time", "latitude", "longitude", "elevation", "equipment", "aperture", "focal_length", "seeing", "transparency", "rating", "notes"}
+
+func (o *Observation) fields() []any {
+	return []any{&o.ID, &o.Object, &o.Time, &o.Latitude, &o.Longitude, &o.Elevation, &o.Equipment, &o.Aperture, &o.FocalLength, &o.Seeing, &o.Transparency, &o.Rating, &o.Notes}
+}
+
+// Validate checks the scales of o's seeing, transparency and rating.
+func (o Observation) Validate() error {
+	for _, f := range []struct {
+		name string
+		v    *int
+	}{{"seeing", o.Seeing}, {"transparency", o.Transparency}, {"rating", o.Rating}} {
+		if f.v != nil && (*f.v < 1 || *f.v > 5) {
+			return fmt.Errorf("%w: %s must be from 1 to 5, got %d", ErrInvalid, f.name, *f.v)
+		}
+	}
+	return nil
+}
+
+// Observations returns the observations of the object with the
+// designation object (see Resolve), or of every object if it's empty,
+// from from until to (either can be zero for no limit), oldest first.
+func Observations(object string, from, to time.Time) ([]Observation, error) {
+	sel := sqrl.Select(observationColumns...).
+		From("user.observations").
+		OrderBy("time", "id")
+
+	if object != "" {
+		ids, err := Resolve(object)
+		if err != nil {
+			return nil, err
+		}
+
+		// an object that's no longer in the catalog has only its id
+		sel.Where(sqrl.Eq{"object": append(ids, object)})
+	}
+
+	// times are kept in utc so that they sort as text
+	if !from.IsZero() {
+		sel.Where("time >= ?", from.UTC())
+	}
+
+	if !to.IsZero() {
+		sel.Where("time < ?", to.UTC())
+	}
+
+	q, args, _ := sel.ToSql()
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	obs := []Observation{}
+	for rows.Next() {
+		var o Observation
+		if err := rows.Scan(o.fields()...); err != nil {
+			return nil, err
+		}
+		obs = append(obs, o)
+	}
+
+	return obs, rows.Err()
+}
+
+// GetObservation returns the observation with the id.
+func GetObservation(id int64) (o Observation, err error) {
+	q, args, _ := sqrl.Select(observationColumns...).
+		From("user.observations").
+		Where("id = ?", id).
+		ToSql()
+
+	err = db.QueryRow(q, args...).Scan(o.fields()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return o, ErrNoObservation
+	}
+	return o, err
+}
+
+// FirstObserved returns when the object with the id was first observed,
+// or nil if there's no observation of it in the logbook.
+func FirstObserved(id string) (*time.Time, error) {
+	var t time.Time
+	err := db.QueryRow("SELECT time FROM user.observations WHERE object = ? ORDER BY time LIMIT 1", id).Scan(&t)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Log adds o to the logbook, with its object's designation replaced by
+// its id (see Resolve), and returns it with its id.
+func Log(o Observation) (Observation, error) {
+	if err := o.Validate(); err != nil {
+		return o, err
+	}
+
+	ids, err := Resolve(o.Object)
+	if err != nil {
+		return o, err
+	}
+
+	if len(ids) == 0 {
+		return o, fmt.Errorf("there's no object %q: %w", o.Object, sql.ErrNoRows)
+	}
+	o.Object = ids[0]
+	o.Time = o.Time.UTC()
+
+	res, err := db.Exec("INSERT INTO user.observations (object, time, latitude, longitude, elevation, equipment, aperture, focal_length, seeing, transparency, rating, notes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		o.Object, o.Time, o.Latitude, o.Longitude, o.Elevation, o.Equipment, o.Aperture, o.FocalLength, o.Seeing, o.Transparency, o.Rating, o.Notes)
+	if err != nil {
+		return o, err
+	}
+
+	o.ID, err = res.LastInsertId()
+	return o, err
+}
+
+// UpdateObservation replaces the equipment, conditions, rating and notes
+// of the observation with o's id.
+func UpdateObservation(o Observation) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	err := changed(db.Exec("UPDATE user.observations SET equipment = ?, aperture = ?, focal_length = ?, seeing = ?, transparency = ?, rating = ?, notes = ? WHERE id = ?",
+		o.Equipment, o.Aperture, o.FocalLength, o.Seeing, o.Transparency, o.Rating, o.Notes, o.ID))
+	if errors.Is(err, ErrNotInList) {
+		return ErrNoObservation
+	}
+	return err
+}
+
+// DeleteObservation takes the observation with the id out of the
+// logbook.
+func DeleteObservation(id int64) error {
+	err := changed(db.Exec("DELETE FROM user.observations WHERE id = ?", id))
+	if errors.Is(err, ErrNotInList) {
+		return ErrNoObservation
+	}
+	return err
+}
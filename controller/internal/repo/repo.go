@@ -1,19 +1,33 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"embed"
 	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/cswank/geq/controller/internal/designation"
 	"github.com/cswank/geq/controller/internal/mount"
+	"github.com/cswank/geq/controller/internal/sky"
 	"github.com/parsyl/sqrl"
 	"modernc.org/sqlite"
 	_ "modernc.org/sqlite"
 	"modernc.org/sqlite/vfs"
 )
 
+//go:generate go run ./catalog -ngc files/NGC.csv -addendum files/addendum.csv -messier files/messier.db -programs files/programs -out files/objects.db
+
+// zoneHeight is how many degrees of declination each of the zones the
+// catalog puts objects in covers (see Near).
+const zoneHeight = 1.0
+
 var (
 	//go:embed files/objects.db
 	dbf embed.FS
@@ -29,9 +43,31 @@ var (
 		"magnitude",
 		"name",
 		"m",
+		"ngc",
+		"ic",
+		"maj_ax",
+		"min_ax",
+		"pos_ang",
+		"b_mag",
+		"v_mag",
+		"surf_br",
+		"hubble",
+		"redshift",
+		"identifiers",
+		"hr",
+		"hip",
+		"hd",
+		"bayer",
+		"flamsteed",
+		"spectral",
+		"pm_ra",
+		"pm_dec",
 		"hour_angle(ra_radians)",
 	}
 
+	// epoch is when the positions in the database are for, J2000.
+	epoch = time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+
 	fs *vfs.FS
 	db *sql.DB
 
@@ -43,6 +79,7 @@ type (
 		ID            string   `json:"id"`
 		M             *int     `json:"m"`
 		NGC           *int     `json:"ngc"`
+		IC            *int     `json:"ic"`
 		Type          string   `json:"type"`
 		Constellation *string  `json:"constellation"`
 		RA            string   `json:"ra"`
@@ -51,9 +88,49 @@ type (
 		DecRadians    float64  `json:"dec_radians"`
 		Magnitude     *float64 `json:"magnitude"`
 		Name          *string  `json:"name"`
-		HA            float64  `json:"ha"`
-		HourAngle     string   `json:"hour_angle"`
-		Visible       bool     `json:"visible"`
+		// MajorAxis and MinorAxis are in arcminutes, PositionAngle in
+		// degrees and SurfaceBrightness in magnitudes per square
+		// arcsecond (worked out from the magnitude and axes for objects
+		// OpenNGC doesn't give one).
+		MajorAxis         *float64 `json:"major_axis,omitempty"`
+		MinorAxis         *float64 `json:"minor_axis,omitempty"`
+		PositionAngle     *float64 `json:"position_angle,omitempty"`
+		BMag              *float64 `json:"b_mag,omitempty"`
+		VMag              *float64 `json:"v_mag,omitempty"`
+		SurfaceBrightness *float64 `json:"surface_brightness,omitempty"`
+		Hubble            *string  `json:"hubble,omitempty"`
+		Redshift          *float64 `json:"redshift,omitempty"`
+		Identifiers       *string  `json:"identifiers,omitempty"`
+		HR                *int     `json:"hr,omitempty"`
+		HIP               *int     `json:"hip,omitempty"`
+		HD                *int     `json:"hd,omitempty"`
+		Bayer             *string  `json:"bayer,omitempty"`
+		Flamsteed         *string  `json:"flamsteed,omitempty"`
+		Spectral          *string  `json:"spectral,omitempty"`
+		PMRA              *float64 `json:"pm_ra,omitempty"`
+		PMDec             *float64 `json:"pm_dec,omitempty"`
+		// Altitude and Azimuth (east of north) are in degrees, now by
+		// the mount's clock.  Rise, Transit and Set are the pass that's
+		// under way, or the next one if it isn't up: Rise and Set are nil
+		// if it never sets or never rises.  MaxAltitude is how high it
+		// gets tonight, between sunset and sunrise, and nil if there's
+		// no night.
+		Altitude    float64    `json:"altitude"`
+		Azimuth     float64    `json:"azimuth"`
+		Airmass     *float64   `json:"airmass"`
+		Rise        *time.Time `json:"rise"`
+		Transit     time.Time  `json:"transit"`
+		Set         *time.Time `json:"set"`
+		MaxAltitude *float64   `json:"max_altitude"`
+		// Separation is how far, in degrees, the object is from the
+		// middle of a Near search.
+		Separation *float64 `json:"separation,omitempty"`
+		HA         float64  `json:"ha"`
+		HourAngle  string   `json:"hour_angle"`
+		Visible    bool     `json:"visible"`
+		// Programs are the observing programs the object is in, with
+		// its number in them (Caldwell 14), filled in by GetObject.
+		Programs []string `json:"programs,omitempty"`
 	}
 
 	Objects struct {
@@ -61,10 +138,23 @@ type (
 		Total   int      `json:"total"`
 	}
 
+	// Program is an observing program: Total is how many objects are in
+	// it and Observed how many of them have been observed.
+	Program struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Total    int    `json:"total"`
+		Observed int    `json:"observed"`
+	}
+
 	QueryOption func(*sqrl.SelectBuilder)
 )
 
-func Init(m *mount.Mount) (err error) {
+// Init opens the catalog, which is read only, and the user's database
+// at userDB (a new one if there isn't one there, or one that's forgotten
+// on restart if userDB is empty) for the observing lists and the
+// logbook.
+func Init(m *mount.Mount, userDB string) (err error) {
 	mnt = m
 
 	var fn string
@@ -73,44 +163,248 @@ func Init(m *mount.Mount) (err error) {
 		return err
 	}
 
+	// the user's database is attached to every connection to the
+	// catalog so that they can be joined.  It would be opened with the
+	// catalog's vfs if it didn't name the os's.  A uri with a relative
+	// path would have its first directory taken for the authority.
+	user := "file:user?mode=memory&cache=shared"
+	if userDB != "" {
+		pth, err := filepath.Abs(userDB)
+		if err != nil {
+			return err
+		}
+		user = (&url.URL{Scheme: "file", Path: pth, RawQuery: "vfs=unix"}).String()
+	}
+
+	sqlite.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, dsn string) error {
+		if !strings.Contains(dsn, "vfs="+fn) {
+			return nil
+		}
+
+		_, err := conn.ExecContext(context.Background(), "ATTACH DATABASE ? AS user", []driver.NamedValue{{Ordinal: 1, Value: user}})
+		return err
+	})
+
 	if err := sqlite.RegisterScalarFunction("hour_angle", 1, hourAngle); err != nil {
 		return fmt.Errorf("unable to register hour_angle func: %s", err)
 	}
 
-	db, err = sql.Open("sqlite", "file:files/objects.db?vfs="+fn)
+	if err := sqlite.RegisterScalarFunction("altitude", 4, altitude); err != nil {
+		return fmt.Errorf("unable to register altitude func: %s", err)
+	}
+
+	if err := sqlite.RegisterScalarFunction("sets_in", 4, setsIn); err != nil {
+		return fmt.Errorf("unable to register sets_in func: %s", err)
+	}
+
+	if err := sqlite.RegisterScalarFunction("separation", 4, separation); err != nil {
+		return fmt.Errorf("unable to register separation func: %s", err)
+	}
+
+	// sorts that can't use an index need temporary tables, which the
+	// embedded vfs can't make files for
+	db, err = sql.Open("sqlite", "file:files/objects.db?vfs="+fn+"&_pragma=temp_store(memory)&_pragma=busy_timeout(5000)")
 	if err != nil {
 		return err
 	}
 
+	if _, err := db.Exec(userSchema + observationsSchema); err != nil {
+		return fmt.Errorf("unable to set up the user database %s: %w", userDB, err)
+	}
+
 	return nil
 }
 
 func hourAngle(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
 	ra := args[0].(float64)
-	return mnt.HourAngle(ra, time.Now()), nil
+	return mnt.HourAngle(ra, mnt.Now()), nil
+}
+
+// altitude is the altitude, in degrees, of (ra, dec) when the local
+// sidereal time is lst (hours) at latitude lat (radians).
+func altitude(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	ra, dec, lst, lat := args[0].(float64), args[1].(float64), args[2].(float64), args[3].(float64)
+	alt, _ := sky.AltAz(sky.HourAngle(ra, lst), dec, lat)
+	return alt * 180 / math.Pi, nil
+}
+
+// setsIn is how many hours until (ra, dec, lst, lat) sets, or null if it
+// isn't up or never sets.
+func setsIn(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	ra, dec, lst, lat := args[0].(float64), args[1].(float64), args[2].(float64), args[3].(float64)
+	h, ok := sky.SetsIn(ra, dec, lst, lat, 0)
+	if !ok {
+		return nil, nil
+	}
+	return h, nil
 }
 
+// separation is the angle, in radians, between (ra, dec) and (ra0, dec0).
+func separation(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	return sky.Separation(args[0].(float64), args[1].(float64), args[2].(float64), args[3].(float64)), nil
+}
+
+// site is the sky from the mount.
+func site() sky.Site {
+	lat, _ := mnt.GetCoordinates()
+	return sky.Site{Latitude: mnt.Rad(lat), LST: mnt.LocalSiderealTime}
+}
+
+// locate works out where the object is at now, and how high it gets in
+// the night from start to end if ok.
+func (o *Object) locate(s sky.Site, now, start, end time.Time, ok bool) {
+	ra, dec := o.At(now)
+	alt, az := sky.AltAz(sky.HourAngle(ra, s.LST(now)), dec, s.Latitude)
+	o.Altitude, o.Azimuth = alt*180/math.Pi, az*180/math.Pi
+	if alt > 0 {
+		am := sky.Airmass(alt)
+		o.Airmass = &am
+	}
+
+	p := s.Pass(ra, dec, 0, now)
+	o.Rise, o.Transit, o.Set = p.Rise, p.Transit, p.Set
+	if ok {
+		top := s.MaxAltitude(ra, dec, start, end) * 180 / math.Pi
+		o.MaxAltitude = &top
+	}
+}
+
+// At returns the object's ra and dec at t: a star's position moves by its
+// proper motion (in milliarcseconds a year, in ra times cos dec) from
+// the epoch.
+func (o Object) At(t time.Time) (ra, dec float64) {
+	if o.PMRA == nil || o.PMDec == nil {
+		return o.RARadians, o.DecRadians
+	}
+
+	years := t.Sub(epoch).Hours() / (24 * 365.25)
+	mas := math.Pi / (180 * 3600 * 1000)
+	return o.RARadians + *o.PMRA*years*mas/math.Cos(o.DecRadians), o.DecRadians + *o.PMDec*years*mas
+}
+
+// fields are where to scan columns and visible into.
+func (o *Object) fields() []any {
+	return []any{&o.ID, &o.Type, &o.Constellation, &o.RA, &o.Dec, &o.RARadians, &o.DecRadians, &o.Magnitude, &o.Name, &o.M, &o.NGC, &o.IC, &o.MajorAxis, &o.MinorAxis, &o.PositionAngle, &o.BMag, &o.VMag, &o.SurfaceBrightness, &o.Hubble, &o.Redshift, &o.Identifiers, &o.HR, &o.HIP, &o.HD, &o.Bayer, &o.Flamsteed, &o.Spectral, &o.PMRA, &o.PMDec, &o.HourAngle, &o.Visible}
+}
+
+// GetObject returns the object with the designation id (see Resolve),
+// the brightest if more than one has it.
 func GetObject(id string) (o Object, err error) {
+	ids, err := Resolve(id)
+	if err != nil {
+		return o, err
+	}
+
+	if len(ids) == 0 {
+		return o, sql.ErrNoRows
+	}
+
 	sel := sqrl.Select(columns...).
 		From("objects")
 
 	visible(sel)
 
-	sel.Where("id = ?", id)
+	sel.Where("id = ?", ids[0])
 	q, args, _ := sel.ToSql()
+	if err := db.QueryRow(q, args...).Scan(o.fields()...); err != nil {
+		return o, err
+	}
+
+	s, now := site(), mnt.Now()
+	start, end, ok := s.Night(now, sky.Sunset)
+	o.locate(s, now, start, end, ok)
+
+	o.Programs, err = memberships(o.ID)
+	return o, err
+}
+
+// memberships are the programs the object with the id is in.
+func memberships(id string) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT name, number FROM memberships JOIN programs ON programs.id = memberships.program WHERE memberships.id = ? ORDER BY name", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ps []string
+	for rows.Next() {
+		var name string
+		var n *int
+		if err := rows.Scan(&name, &n); err != nil {
+			return nil, err
+		}
+
+		if n != nil {
+			name = fmt.Sprintf("%s %d", name, *n)
+		}
+		ps = append(ps, name)
+	}
+
+	return ps, rows.Err()
+}
+
+// Programs returns the observing programs and how many of each's
+// entries have been observed, that is have an observation in the
+// logbook.
+func Programs() ([]Program, error) {
+	rows, err := db.Query(`SELECT programs.id, name, count(*), count(observed.object) FROM programs
+JOIN memberships ON memberships.program = programs.id
+LEFT JOIN (SELECT DISTINCT object FROM user.observations) AS observed ON observed.object = memberships.id
+GROUP BY programs.id ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ps := []Program{}
+	for rows.Next() {
+		var p Program
+		if err := rows.Scan(&p.ID, &p.Name, &p.Total, &p.Observed); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
 
-	return o, db.QueryRow(q, args...).Scan(&o.ID, &o.Type, &o.Constellation, &o.RA, &o.Dec, &o.RARadians, &o.DecRadians, &o.Magnitude, &o.Name, &o.M, &o.HourAngle, &o.Visible)
+	return ps, rows.Err()
+}
+
+// Resolve returns the ids of the objects with the designation s, however
+// it's written: an id (NGC0224), a catalog number (M 31, NGC 224,
+// Caldwell 14, UGC 454, PGC 2557, HR 2491, HIP 32349), a Bayer or
+// Flamsteed designation (Alpha CMa, 9 CMa) or a common name (Andromeda
+// Galaxy).  The object whose id it is comes first, then the rest
+// brightest first.
+func Resolve(s string) ([]string, error) {
+	rows, err := db.Query("SELECT id FROM designations JOIN objects USING (id) WHERE designation = ? ORDER BY id = ? DESC, magnitude ASC NULLS LAST, id", designation.Normalize(s), s)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
 }
 
 func GetObjects(page QueryOption, opts ...QueryOption) (objs Objects, err error) {
 	cte := sqrl.Select(columns...).
-		From("objects").
-		OrderBy("magnitude ASC NULLS LAST")
+		From("objects")
 	visible(cte)
+	sortable(cte)
 
+	// options that sort (ByAltitude, Near...) come ahead of the default,
+	// brightest first
 	for _, o := range opts {
 		o(cte)
 	}
+	cte.OrderBy("magnitude ASC NULLS LAST", "m")
 
 	q, args, _ := cte.ToSql()
 	count := fmt.Sprintf("WITH objs AS (%s) SELECT count(*) FROM objs", q)
@@ -132,39 +426,85 @@ func GetObjects(page QueryOption, opts ...QueryOption) (objs Objects, err error)
 	if err != nil {
 		return objs, err
 	}
+	defer rows.Close()
+
+	s, now := site(), mnt.Now()
+	start, end, ok := s.Night(now, sky.Sunset)
 
 	objs.Objects = []Object{}
 	for rows.Next() {
 		var o Object
-		if err := rows.Scan(&o.ID, &o.Type, &o.Constellation, &o.RA, &o.Dec, &o.RARadians, &o.DecRadians, &o.Magnitude, &o.Name, &o.M, &o.HourAngle, &o.Visible); err != nil {
+		if err := rows.Scan(o.fields()...); err != nil {
 			return objs, err
 		}
 
+		o.locate(s, now, start, end, ok)
 		objs.Objects = append(objs.Objects, o)
 	}
 
-	return objs, nil
+	return objs, rows.Err()
 }
 
 func visible(sel *sqrl.SelectBuilder) {
-	t := time.Now()
-	lst := mnt.LocalSiderealTime(t)
+	lst := mnt.LocalSiderealTime(mnt.Now())
 	lst = (lst / 24) * 360
 	lat, _ := mnt.GetCoordinates()
 	sel.Column("((dec_radians > ?) OR (cos(? - ra_radians) > (-1*tan(?)*tan(dec_radians)))) AS visible", mnt.Rad(90-lat), mnt.Rad(lst), mnt.Rad(lat))
 }
 
+// sortable adds the altitude and the hours until setting, now, for
+// ByAltitude and BySetting to sort by.
+func sortable(sel *sqrl.SelectBuilder) {
+	lst := mnt.LocalSiderealTime(mnt.Now())
+	lat, _ := mnt.GetCoordinates()
+	sel.Column("altitude(ra_radians, dec_radians, ?, ?) AS altitude", lst, mnt.Rad(lat))
+	sel.Column("sets_in(ra_radians, dec_radians, ?, ?) AS sets_in", lst, mnt.Rad(lat))
+}
+
 func Visible(sel *sqrl.SelectBuilder) {
 	sel.Where("visible IS TRUE")
 }
 
+// ByAltitude sorts objects highest first.
+func ByAltitude(sel *sqrl.SelectBuilder) {
+	sel.OrderBy("altitude DESC")
+}
+
+// BySetting sorts the objects that are up by how soon they set, ahead of
+// the ones that aren't or never set.
+func BySetting(sel *sqrl.SelectBuilder) {
+	sel.OrderBy("sets_in ASC NULLS LAST")
+}
+
 func Named(sel *sqrl.SelectBuilder) {
 	sel.Where("name IS NOT NULL")
 }
 
+// Messier picks the Messier objects, brightest first like any other
+// objects.
 func Messier(sel *sqrl.SelectBuilder) {
-	sel.Where("m IS NOT NULL").
-		OrderBy("m")
+	sel.Where("m IS NOT NULL")
+}
+
+// InProgram picks the objects in the program with the id, in the
+// program's order if it numbers them.
+func InProgram(id string) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		sel.Join("(SELECT DISTINCT id, number FROM memberships WHERE program = ?) USING (id)", id).
+			OrderBy("number ASC NULLS LAST")
+	}
+}
+
+// Observed picks the objects that have an observation in the logbook,
+// or, if not observed, the ones that don't.
+func Observed(observed bool) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		if observed {
+			sel.Where("id IN (SELECT object FROM user.observations)")
+		} else {
+			sel.Where("id NOT IN (SELECT object FROM user.observations)")
+		}
+	}
 }
 
 func Types(types []string) QueryOption {
@@ -181,10 +521,115 @@ func Types(types []string) QueryOption {
 	}
 }
 
+// Brighter leaves out objects fainter than magnitude mag, and ones with
+// no magnitude.
+func Brighter(mag float64) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		sel.Where("magnitude <= ?", mag)
+	}
+}
+
+// Near returns the objects opts pick within radius of ra and dec (all in
+// radians), nearest first, with their separations.  Objects are kept in
+// zones of declination, sorted by ra, so only the ones in the zones and
+// range of ra the circle covers have to be measured.
+func Near(ra, dec, radius float64, page QueryOption, opts ...QueryOption) (Objects, error) {
+	objs, err := GetObjects(page, append([]QueryOption{cone(ra, dec, radius)}, opts...)...)
+	for i, o := range objs.Objects {
+		sep := sky.Separation(ra, dec, o.RARadians, o.DecRadians) * 180 / math.Pi
+		objs.Objects[i].Separation = &sep
+	}
+	return objs, err
+}
+
+func cone(ra, dec, radius float64) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		deg := 180 / math.Pi
+		sel.Where("zone BETWEEN ? AND ?", math.Floor(((dec-radius)*deg+90)/zoneHeight), math.Floor(((dec+radius)*deg+90)/zoneHeight))
+
+		// the widest the circle is in ra, unless it takes in a pole
+		if math.Abs(dec)+radius < math.Pi/2 {
+			dra := math.Asin(math.Sin(radius) / math.Cos(dec))
+			lo, hi := ra-dra, ra+dra
+			switch {
+			case lo < 0:
+				sel.Where("(ra_radians >= ? OR ra_radians <= ?)", lo+2*math.Pi, hi)
+			case hi > 2*math.Pi:
+				sel.Where("(ra_radians >= ? OR ra_radians <= ?)", lo, hi-2*math.Pi)
+			default:
+				sel.Where("ra_radians BETWEEN ? AND ?", lo, hi)
+			}
+		}
+
+		sel.Column("separation(ra_radians, dec_radians, ?, ?) AS separation", ra, dec).
+			Where("separation <= ?", radius).
+			OrderBy("separation")
+	}
+}
+
+// Size leaves out objects whose major axis is under min or over max
+// arcminutes (0 for no limit).  Objects with no size are left out if
+// there's a limit.
+func Size(min, max float64) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		if min > 0 {
+			sel.Where("maj_ax >= ?", min)
+		}
+
+		if max > 0 {
+			sel.Where("maj_ax <= ?", max)
+		}
+	}
+}
+
+// SurfaceBrightness leaves out objects fainter than max magnitudes per
+// square arcsecond.  Objects with no surface brightness (stars and
+// others with no size) are kept.
+func SurfaceBrightness(max float64) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		sel.Where("(surf_br IS NULL OR surf_br <= ?)", max)
+	}
+}
+
+// Not leaves out the object with the id.
+func Not(id string) QueryOption {
+	return func(sel *sqrl.SelectBuilder) {
+		sel.Where("id <> ?", id)
+	}
+}
+
+// Name picks the objects s is a designation of (see Resolve), then the
+// ones whose names and designations have words that start with the words
+// in s, best match first: M3, then M31, M33...
 func Name(s string) QueryOption {
 	return func(sel *sqrl.SelectBuilder) {
-		sel.Where("name LIKE ?", fmt.Sprintf("%%%s%%", s))
+		sel.Join(`(
+	SELECT id, min(rank) AS rank, min(relevance) AS relevance FROM (
+		SELECT id, 0 AS rank, 0 AS relevance FROM designations WHERE designation = ?
+		UNION ALL
+		SELECT id, 1, bm25(search, 0, 10, 1) FROM search WHERE search MATCH ?
+	) GROUP BY id
+) USING (id)`, designation.Normalize(s), match(s)).
+			OrderBy("rank", "relevance")
+	}
+}
+
+// match is a full text query for the objects with a name or designation
+// that has words starting with each of the words in s, or a designation
+// that starts with s (NGC 22 for NGC224).
+func match(s string) string {
+	quote := func(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"*` }
+
+	var words []string
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		words = append(words, quote(w))
+	}
+
+	q := quote(designation.Normalize(s))
+	if len(words) > 0 {
+		q = fmt.Sprintf("(%s) OR %s", strings.Join(words, " AND "), q)
 	}
+	return q
 }
 
 func Page(p, ps int) QueryOption {
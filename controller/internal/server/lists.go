@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cswank/geq/controller/internal/repo"
+)
+
+type (
+	// list is a new observing list, or a list's new name and notes.
+	list struct {
+		Name  string `json:"name"`
+		Notes string `json:"notes"`
+	}
+
+	// listEntry is an object to add to a list, or new notes about one
+	// that's in it.
+	listEntry struct {
+		ID    string `json:"id"`
+		Notes string `json:"notes"`
+	}
+
+	// listOrder is every object in a list, in their new order.
+	listOrder struct {
+		IDs []string `json:"ids"`
+	}
+)
+
+func (s Server) lists(w http.ResponseWriter, r *http.Request) error {
+	ls, err := repo.Lists()
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(ls)
+}
+
+func (s Server) getList(w http.ResponseWriter, r *http.Request) error {
+	id, err := listID(r)
+	if err != nil {
+		return err
+	}
+
+	l, err := repo.GetList(id)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(l)
+}
+
+func (s Server) createList(w http.ResponseWriter, r *http.Request) error {
+	var l list
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		return badRequest{err}
+	}
+
+	if l.Name == "" {
+		return badRequestf("a list needs a name")
+	}
+
+	created, err := repo.CreateList(l.Name, l.Notes)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(created)
+}
+
+func (s Server) updateList(w http.ResponseWriter, r *http.Request) error {
+	id, err := listID(r)
+	if err != nil {
+		return err
+	}
+
+	var l list
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		return badRequest{err}
+	}
+
+	if l.Name == "" {
+		return badRequestf("a list needs a name")
+	}
+
+	return repo.UpdateList(id, l.Name, l.Notes)
+}
+
+func (s Server) deleteList(w http.ResponseWriter, r *http.Request) error {
+	id, err := listID(r)
+	if err != nil {
+		return err
+	}
+
+	return repo.DeleteList(id)
+}
+
+// addToList adds an object to the end of a list, or changes its notes if
+// it's already in it (as does a PUT to the object in the list).
+func (s Server) addToList(w http.ResponseWriter, r *http.Request) error {
+	id, err := listID(r)
+	if err != nil {
+		return err
+	}
+
+	var e listEntry
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		return badRequest{err}
+	}
+
+	if obj := r.PathValue("obj"); obj != "" {
+		e.ID = obj
+	}
+
+	if e.ID, err = repo.AddToList(id, e.ID, e.Notes); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(e)
+}
+
+func (s Server) removeFromList(w http.ResponseWriter, r *http.Request) error {
+	id, err := listID(r)
+	if err != nil {
+		return err
+	}
+
+	return repo.RemoveFromList(id, r.PathValue("obj"))
+}
+
+func (s Server) reorderList(w http.ResponseWriter, r *http.Request) error {
+	id, err := listID(r)
+	if err != nil {
+		return err
+	}
+
+	var o listOrder
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		return badRequest{err}
+	}
+
+	return repo.ReorderList(id, o.IDs)
+}
+
+func listID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("list"), 10, 64)
+	if err != nil {
+		return 0, badRequestf("bad list id: %w", err)
+	}
+	return id, nil
+}
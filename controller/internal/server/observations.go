@@ -0,0 +1,186 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cswank/geq/controller/internal/logbook"
+	"github.com/cswank/geq/controller/internal/repo"
+)
+
+// observations returns the logbook, or the observations of object, from
+// from until to (dates, which take in the whole day, or rfc3339 times),
+// as json, or with format oal or csv as a file for other logging tools.
+func (s Server) observations(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	from, err := logTime(q.Get("from"), 0)
+	if err != nil {
+		return badRequestf("bad from: %w", err)
+	}
+
+	to, err := logTime(q.Get("to"), 1)
+	if err != nil {
+		return badRequestf("bad to: %w", err)
+	}
+
+	obs, err := repo.Observations(q.Get("object"), from, to)
+	if err != nil {
+		return err
+	}
+
+	format := q.Get("format")
+	if format == "" || format == "json" {
+		return json.NewEncoder(w).Encode(obs)
+	}
+
+	entries := make([]logbook.Entry, len(obs))
+	targets := map[string]repo.Object{}
+	for i, o := range obs {
+		t, ok := targets[o.Object]
+		if !ok {
+			t, err = repo.GetObject(o.Object)
+			if errors.Is(err, sql.ErrNoRows) {
+				t, err = repo.Object{ID: o.Object}, nil
+			}
+			if err != nil {
+				return err
+			}
+			targets[o.Object] = t
+		}
+		entries[i] = logbook.Entry{Observation: o, Target: t}
+	}
+
+	switch format {
+	case "oal":
+		w.Header().Add("content-type", "application/xml")
+		w.Header().Add("content-disposition", `attachment; filename="logbook.xml"`)
+		return logbook.OAL(w, s.cfg.Observer.Name, entries)
+	case "csv":
+		w.Header().Add("content-type", "text/csv")
+		w.Header().Add("content-disposition", `attachment; filename="logbook.csv"`)
+		return logbook.CSV(w, entries)
+	default:
+		return badRequestf("can't export the logbook as %q", format)
+	}
+}
+
+func (s Server) getObservation(w http.ResponseWriter, r *http.Request) error {
+	id, err := observationID(r)
+	if err != nil {
+		return err
+	}
+
+	o, err := repo.GetObservation(id)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(o)
+}
+
+// logObservation adds an observation to the logbook of the object in the
+// path, or the object in the body, or else the one last gone to.  It's
+// observed now, from the mount's site, with the configured telescope,
+// unless the body (which can be left out) says otherwise.
+func (s Server) logObservation(w http.ResponseWriter, r *http.Request) error {
+	lat, lon := s.mount.GetCoordinates()
+	aperture, focalLength := s.cfg.Telescope.Aperture, s.cfg.Telescope.FocalLength
+	o := repo.Observation{
+		Time:      s.mount.Now(),
+		Latitude:  lat,
+		Longitude: lon,
+		Elevation: s.mount.GetElevation(),
+		Equipment: s.cfg.Telescope.Name,
+		Aperture:  &aperture,
+	}
+
+	if focalLength > 0 {
+		o.FocalLength = &focalLength
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil && !errors.Is(err, io.EOF) {
+		return badRequest{err}
+	}
+
+	if id := r.PathValue("id"); id != "" {
+		o.Object = id
+	}
+
+	if o.Object == "" {
+		s.visits.lock.Lock()
+		o.Object = s.visits.last
+		s.visits.lock.Unlock()
+	}
+
+	if o.Object == "" {
+		return badRequestf("there's no object to log, nothing has been gone to")
+	}
+
+	o, err := repo.Log(o)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(o)
+}
+
+// updateObservation changes what the body has of an observation's
+// equipment, conditions, rating and notes.
+func (s Server) updateObservation(w http.ResponseWriter, r *http.Request) error {
+	id, err := observationID(r)
+	if err != nil {
+		return err
+	}
+
+	o, err := repo.GetObservation(id)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		return badRequest{err}
+	}
+	o.ID = id
+
+	if err := repo.UpdateObservation(o); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(o)
+}
+
+func (s Server) deleteObservation(w http.ResponseWriter, r *http.Request) error {
+	id, err := observationID(r)
+	if err != nil {
+		return err
+	}
+
+	return repo.DeleteObservation(id)
+}
+
+func observationID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("observation"), 10, 64)
+	if err != nil {
+		return 0, badRequestf("bad observation id: %w", err)
+	}
+	return id, nil
+}
+
+// logTime parses a date, in local time, plus days (so that a date can
+// take in the whole day), or an rfc3339 time.  Empty is the zero time.
+func logTime(s string, days int) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t.AddDate(0, 0, days), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
@@ -4,21 +4,31 @@ import (
 	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
-	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/cswank/geq/controller/internal/config"
+	"github.com/cswank/geq/controller/internal/gps"
 	"github.com/cswank/geq/controller/internal/mount"
+	"github.com/cswank/geq/controller/internal/recommend"
 	"github.com/cswank/geq/controller/internal/repo"
+	"github.com/cswank/geq/controller/internal/sky"
 	_ "modernc.org/sqlite"
 	"modernc.org/sqlite/vfs"
 )
 
+// maxClockError is how far the clock can be from the gps or the browser
+// before it's set.
+const maxClockError = 2 * time.Second
+
 var (
 	//go:embed www/*
 	static embed.FS
@@ -28,8 +38,17 @@ type (
 	setup struct {
 		Latitude  float64 `json:"latitude"`
 		Longitude float64 `json:"longitude"`
-		Time      string  `json:"time"`
-		SetTime   bool    `json:"-"`
+	}
+
+	// clockSetting sets the mount's clock to Time (unix milliseconds)
+	// from Source (browser or user), or its rate (see
+	// clock.Clock.SetRate), or, with Reset, puts it back to the system
+	// clock.
+	clockSetting struct {
+		Time   *int64   `json:"time"`
+		Source string   `json:"source"`
+		Rate   *float64 `json:"rate"`
+		Reset  bool     `json:"reset"`
 	}
 
 	coords struct {
@@ -37,9 +56,34 @@ type (
 		Dec       float64 `json:"dec"`
 	}
 
+	// movement is a jog (see mount.Jog): a duration in milliseconds or
+	// a number of steps, or neither for a jog that's kept going by
+	// heartbeats.
 	movement struct {
-		Hz    float64 `json:"hz"`
-		Steps float64 `json:"steps"`
+		Speed     string  `json:"speed"`
+		Direction float64 `json:"direction"`
+		MS        int     `json:"ms"`
+		Steps     float64 `json:"steps"`
+	}
+
+	guide struct {
+		Offset float64 `json:"offset"`
+		MS     int     `json:"ms"`
+	}
+
+	pecRecord struct {
+		Cycles float64 `json:"cycles"`
+	}
+
+	// suggestion is an object typeahead suggests, with a label that
+	// shows its designations and name.
+	suggestion struct {
+		ID    string `json:"id"`
+		Label string `json:"label"`
+	}
+
+	pecPlayback struct {
+		On bool `json:"on"`
 	}
 
 	index struct {
@@ -47,22 +91,40 @@ type (
 	}
 
 	Server struct {
-		db    *sql.DB
-		f     *vfs.FS
-		mux   *http.ServeMux
-		mount *mount.Mount
-		idx   *template.Template
-		obj   *template.Template
-		set   *template.Template
+		addr   string
+		cfg    config.Config
+		db     *sql.DB
+		f      *vfs.FS
+		mux    *http.ServeMux
+		mount  *mount.Mount
+		idx    *template.Template
+		obj    *template.Template
+		set    *template.Template
+		visits *visits
+		gps    *gps.Receiver
+	}
+
+	// visits are the objects last listed and the one last gone to, so
+	// that Next can work through the list.
+	visits struct {
+		lock    sync.Mutex
+		objects []repo.Object
+		last    string
 	}
 
 	indexInput struct {
 		LocalSiderealTime string
 	}
+
+	// objectPage is an object and when it was observed, if it has been.
+	objectPage struct {
+		repo.Object
+		Observed *time.Time `json:"observed,omitempty"`
+	}
 )
 
-func New(m *mount.Mount) (*Server, error) {
-	if err := repo.Init(m); err != nil {
+func New(m *mount.Mount, cfg config.Config) (*Server, error) {
+	if err := repo.Init(m, cfg.Server.UserDB); err != nil {
 		return nil, err
 	}
 
@@ -72,11 +134,19 @@ func New(m *mount.Mount) (*Server, error) {
 	}
 
 	srv := Server{
-		idx:   idx,
-		obj:   obj,
-		set:   pos,
-		mount: m,
-		mux:   http.NewServeMux(),
+		addr:   cfg.Server.Addr,
+		cfg:    cfg,
+		idx:    idx,
+		obj:    obj,
+		set:    pos,
+		mount:  m,
+		mux:    http.NewServeMux(),
+		visits: &visits{},
+	}
+
+	if cfg.GPS.Serial != "" || cfg.GPS.Gpsd != "" {
+		srv.gps = gps.New(cfg.GPS, srv.gpsFix)
+		go srv.gps.Run()
 	}
 
 	srv.mux.HandleFunc("GET /", handle(srv.index))
@@ -86,30 +156,99 @@ func New(m *mount.Mount) (*Server, error) {
 	srv.mux.HandleFunc("GET /objects", handle(srv.getObjects))
 	srv.mux.HandleFunc("GET /objects/{id}", handle(srv.getObject))
 	srv.mux.HandleFunc("POST /objects/{id}", handle(srv.gotoObject))
+	srv.mux.HandleFunc("GET /objects/{id}/near", handle(srv.nearObject))
+	srv.mux.HandleFunc("POST /objects/{id}/observations", handle(srv.logObservation))
+	srv.mux.HandleFunc("GET /observations", handle(srv.observations))
+	srv.mux.HandleFunc("POST /observations", handle(srv.logObservation))
+	srv.mux.HandleFunc("GET /observations/{observation}", handle(srv.getObservation))
+	srv.mux.HandleFunc("PUT /observations/{observation}", handle(srv.updateObservation))
+	srv.mux.HandleFunc("DELETE /observations/{observation}", handle(srv.deleteObservation))
+	srv.mux.HandleFunc("GET /programs", handle(srv.programs))
+	srv.mux.HandleFunc("GET /lists", handle(srv.lists))
+	srv.mux.HandleFunc("POST /lists", handle(srv.createList))
+	srv.mux.HandleFunc("GET /lists/{list}", handle(srv.getList))
+	srv.mux.HandleFunc("PUT /lists/{list}", handle(srv.updateList))
+	srv.mux.HandleFunc("DELETE /lists/{list}", handle(srv.deleteList))
+	srv.mux.HandleFunc("PUT /lists/{list}/order", handle(srv.reorderList))
+	srv.mux.HandleFunc("POST /lists/{list}/objects", handle(srv.addToList))
+	srv.mux.HandleFunc("PUT /lists/{list}/objects/{obj}", handle(srv.addToList))
+	srv.mux.HandleFunc("DELETE /lists/{list}/objects/{obj}", handle(srv.removeFromList))
+	srv.mux.HandleFunc("GET /near", handle(srv.near))
+	srv.mux.HandleFunc("GET /typeahead", handle(srv.typeahead))
+	srv.mux.HandleFunc("GET /tonight", handle(srv.tonight))
 	srv.mux.HandleFunc("GET /setup", handle(srv.setup))
 	srv.mux.HandleFunc("POST /setup", handle(srv.doSetup))
 	srv.mux.HandleFunc("POST /ra", handle(srv.move))
 	srv.mux.HandleFunc("POST /dec", handle(srv.move))
+	srv.mux.HandleFunc("POST /heartbeat", handle(srv.heartbeat))
+	srv.mux.HandleFunc("POST /stop", handle(srv.stop))
+	srv.mux.HandleFunc("GET /firmware", handle(srv.firmware))
+	srv.mux.HandleFunc("GET /link", handle(srv.link))
+	srv.mux.HandleFunc("GET /gps", handle(srv.gpsStatus))
+	srv.mux.HandleFunc("GET /clock", handle(srv.clock))
+	srv.mux.HandleFunc("POST /clock", handle(srv.setClock))
+	srv.mux.HandleFunc("GET /position", handle(srv.position))
+	srv.mux.HandleFunc("POST /home/{axis}", handle(srv.home))
+	srv.mux.HandleFunc("POST /guide", handle(srv.guide))
+	srv.mux.HandleFunc("GET /pec", handle(srv.pec))
+	srv.mux.HandleFunc("POST /pec/record", handle(srv.recordPEC))
+	srv.mux.HandleFunc("POST /pec/playback", handle(srv.playPEC))
+	srv.mux.HandleFunc("GET /calibration", handle(srv.calibration))
+	srv.mux.HandleFunc("POST /calibration", handle(srv.startCalibration))
+	srv.mux.HandleFunc("POST /calibration/center/{id}", handle(srv.calibrationCenter))
+	srv.mux.HandleFunc("POST /calibration/save", handle(srv.saveCalibration))
+	srv.mux.HandleFunc("GET /backlash", handle(srv.backlash))
+	srv.mux.HandleFunc("POST /backlash/{axis}", handle(srv.measureBacklash))
+	srv.mux.HandleFunc("POST /backlash/moved", handle(srv.backlashMoved))
+	srv.mux.HandleFunc("POST /backlash/save", handle(srv.saveBacklash))
 
 	return &srv, nil
 }
 
 func (s Server) Start() error {
-	log.Println("Server is running on port 3434")
-	return http.ListenAndServe(":3434", s.mux)
+	log.Printf("Server is listening on %s", s.addr)
+	return http.ListenAndServe(s.addr, s.mux)
 }
 
 type handler func(w http.ResponseWriter, r *http.Request) error
 
+// badRequest is an error in what a request asks for, which handle
+// answers with a 400.
+type badRequest struct{ error }
+
+func (e badRequest) Unwrap() error { return e.error }
+
+func badRequestf(format string, a ...any) error {
+	return badRequest{fmt.Errorf(format, a...)}
+}
+
 func handle(f handler) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
 			log.Printf("error: %v", err)
+			if code := status(err); code != http.StatusInternalServerError {
+				http.Error(w, err.Error(), code)
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 	}
 }
 
+// status is what a handler's error is answered with: a 400 for a bad
+// request, an observation or list entry the repo won't take or an axis
+// or jog speed the mount doesn't have, and a 404 for what isn't there.
+func status(err error) int {
+	switch {
+	case errors.As(err, new(badRequest)), errors.Is(err, repo.ErrInvalid), errors.Is(err, mount.ErrUnknownAxis), errors.Is(err, mount.ErrUnknownSpeed):
+		return http.StatusBadRequest
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, repo.ErrNoObservation), errors.Is(err, repo.ErrNotInList):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s Server) index(w http.ResponseWriter, r *http.Request) error {
 	lat, lon := s.mount.GetCoordinates()
 	if lat == 0 && lon == 0 {
@@ -120,11 +259,8 @@ func (s Server) index(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (s Server) setup(w http.ResponseWriter, r *http.Request) error {
-	ts := time.Now()
 	lat, lon := s.mount.GetCoordinates()
 	return s.set.ExecuteTemplate(w, "setup", setup{
-		SetTime:   ts.Year() < 2025, // no internet, need to manually set time
-		Time:      ts.Format("2006-01-02T15:04"),
 		Latitude:  lat,
 		Longitude: lon,
 	})
@@ -133,23 +269,83 @@ func (s Server) setup(w http.ResponseWriter, r *http.Request) error {
 func (s *Server) doSetup(w http.ResponseWriter, r *http.Request) error {
 	var p setup
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		return err
+		return badRequest{err}
 	}
 
 	s.mount.Coordinates(p.Latitude, p.Longitude)
+	return nil
+}
 
-	if time.Now().Year() < 2025 {
-		ts, err := time.Parse("2006-01-02T15:04", p.Time)
-		if err != nil {
-			return err
+func (s Server) clock(w http.ResponseWriter, r *http.Request) error {
+	return json.NewEncoder(w).Encode(s.mount.Clock().Status())
+}
+
+// setClock sets the clock.  The time the browser sends when the setup
+// page loads only counts if nothing better (the gps or the user) has set
+// the clock and it's off by more than maxClockError.
+func (s Server) setClock(w http.ResponseWriter, r *http.Request) error {
+	var cs clockSetting
+	if err := json.NewDecoder(r.Body).Decode(&cs); err != nil {
+		return badRequest{err}
+	}
+
+	c := s.mount.Clock()
+	if cs.Reset {
+		c.Reset()
+	}
+
+	if cs.Time != nil {
+		ts := time.UnixMilli(*cs.Time)
+		st := c.Status()
+		off := st.Now.Sub(ts)
+		switch {
+		case cs.Source == "user":
+			c.Set(ts, cs.Source)
+		case cs.Source != "browser":
+			return badRequestf("unknown clock source %q", cs.Source)
+		case (st.Source == "system" || st.Source == "browser") && (off > maxClockError || off < -maxClockError):
+			log.Printf("clock is %s off from the browser, setting it", off)
+			c.Set(ts, cs.Source)
 		}
-		//date -s '2014-12-25 12:34:56'
-		args := []string{"--set", ts.Format("2006-01-02 15:04:05")}
-		if err := exec.Command("date", args...).Run(); err != nil {
+	}
+
+	if cs.Rate != nil {
+		if err := c.SetRate(*cs.Rate); err != nil {
 			return err
 		}
 	}
-	return nil
+
+	return json.NewEncoder(w).Encode(c.Status())
+}
+
+// gpsFix puts the site where the gps says it is and sets the clock if
+// it's off by more than maxClockError.  A clock that's been frozen or
+// sped up is left alone.
+func (s Server) gpsFix(f gps.Fix) {
+	s.mount.Coordinates(f.Latitude, f.Longitude)
+	if f.Quality > 0 {
+		s.mount.Elevation(f.Elevation)
+	}
+
+	c := s.mount.Clock()
+	st := c.Status()
+	if st.Rate != 1 {
+		return
+	}
+
+	off := c.At(f.Received).Sub(f.Time)
+	if st.Source != "gps" || off > maxClockError || off < -maxClockError {
+		log.Printf("clock is %s off from gps, setting it", off)
+		c.Set(f.Time.Add(time.Since(f.Received)), "gps")
+	}
+}
+
+func (s Server) gpsStatus(w http.ResponseWriter, r *http.Request) error {
+	var st gps.Status
+	if s.gps != nil {
+		st = s.gps.Status()
+	}
+	return json.NewEncoder(w).Encode(st)
 }
 
 func (s Server) object(w http.ResponseWriter, r *http.Request) error {
@@ -158,7 +354,12 @@ func (s Server) object(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return s.obj.ExecuteTemplate(w, "object", o)
+	t, err := repo.FirstObserved(o.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.obj.ExecuteTemplate(w, "object", objectPage{Object: o, Observed: t})
 }
 
 func (s Server) getObjects(w http.ResponseWriter, r *http.Request) error {
@@ -167,6 +368,10 @@ func (s Server) getObjects(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	s.visits.lock.Lock()
+	s.visits.objects = objs.Objects
+	s.visits.lock.Unlock()
+
 	return json.NewEncoder(w).Encode(objs)
 }
 
@@ -185,21 +390,74 @@ func (s Server) gotoObject(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	if err := s.gotoObj(obj); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(obj)
+}
+
+// programs lists the observing programs with how many of their objects
+// have been logged.
+func (s Server) programs(w http.ResponseWriter, r *http.Request) error {
+	ps, err := repo.Programs()
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(ps)
+}
+
+// Next goes to the visible object after the one last gone to in the list
+// of objects last fetched, or the first if that one isn't in the list.
+func (s Server) Next() error {
+	s.visits.lock.Lock()
+	objs, last := s.visits.objects, s.visits.last
+	s.visits.lock.Unlock()
+
+	start := 0
+	for i, o := range objs {
+		if o.ID == last {
+			start = i + 1
+			break
+		}
+	}
+
+	for _, o := range objs[min(start, len(objs)):] {
+		// visibility changes, so it's checked again
+		obj, err := repo.GetObject(o.ID)
+		if err != nil {
+			return err
+		}
+
+		if obj.Visible {
+			return s.gotoObj(obj)
+		}
+	}
+
+	return errors.New("there's no next visible object in the list")
+}
+
+func (s Server) gotoObj(obj repo.Object) error {
 	if !obj.Visible {
 		return fmt.Errorf("refusing to goto object that isn't visible")
 	}
 
-	if err := s.mount.Goto(s.mount.WithRA(obj.RARadians, time.Now()), obj.DecRadians); err != nil {
+	ra, dec := obj.At(s.mount.Now())
+	if err := s.mount.Goto(s.mount.WithRA(ra), dec); err != nil {
 		return err
 	}
 
-	return json.NewEncoder(w).Encode(obj)
+	s.visits.lock.Lock()
+	s.visits.last = obj.ID
+	s.visits.lock.Unlock()
+	return nil
 }
 
 func (s Server) gotoCoords(w http.ResponseWriter, r *http.Request) error {
 	var obj coords
 	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
-		return err
+		return badRequest{err}
 	}
 
 	if err := s.mount.Goto(s.mount.WithHA(obj.HourAngle, time.Now()), s.mount.Rad(obj.Dec)); err != nil {
@@ -212,18 +470,327 @@ func (s Server) gotoCoords(w http.ResponseWriter, r *http.Request) error {
 func (s *Server) move(w http.ResponseWriter, r *http.Request) error {
 	var m movement
 	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		return badRequest{err}
+	}
+
+	return s.mount.Jog(strings.ReplaceAll(r.URL.Path, "/", ""), mount.Jog{
+		Speed:     m.Speed,
+		Direction: m.Direction,
+		Duration:  time.Duration(m.MS) * time.Millisecond,
+		Steps:     m.Steps,
+	})
+}
+
+func (s Server) heartbeat(w http.ResponseWriter, r *http.Request) error {
+	s.mount.Heartbeat()
+	return nil
+}
+
+func (s Server) stop(w http.ResponseWriter, r *http.Request) error {
+	s.mount.Stop()
+	return nil
+}
+
+func (s Server) firmware(w http.ResponseWriter, r *http.Request) error {
+	f, err := s.mount.Firmware()
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(f)
+}
+
+func (s Server) link(w http.ResponseWriter, r *http.Request) error {
+	return json.NewEncoder(w).Encode(s.mount.Link())
+}
+
+func (s Server) position(w http.ResponseWriter, r *http.Request) error {
+	return json.NewEncoder(w).Encode(s.mount.Position())
+}
+
+func (s Server) home(w http.ResponseWriter, r *http.Request) error {
+	return s.mount.Home(r.PathValue("axis"))
+}
+
+func (s Server) guide(w http.ResponseWriter, r *http.Request) error {
+	var g guide
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		return badRequest{err}
+	}
+
+	return s.mount.Guide(g.Offset, time.Duration(g.MS)*time.Millisecond)
+}
+
+func (s Server) pec(w http.ResponseWriter, r *http.Request) error {
+	return json.NewEncoder(w).Encode(s.mount.PEC())
+}
+
+func (s Server) recordPEC(w http.ResponseWriter, r *http.Request) error {
+	var p pecRecord
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return badRequest{err}
+	}
+
+	return s.mount.RecordPEC(p.Cycles)
+}
+
+func (s Server) playPEC(w http.ResponseWriter, r *http.Request) error {
+	var p pecPlayback
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return badRequest{err}
+	}
+
+	return s.mount.PlayPEC(p.On)
+}
+
+func (s Server) calibration(w http.ResponseWriter, r *http.Request) error {
+	return json.NewEncoder(w).Encode(s.mount.Calibration())
+}
+
+func (s Server) startCalibration(w http.ResponseWriter, r *http.Request) error {
+	s.mount.StartCalibration()
+	return nil
+}
+
+// calibrationCenter is called once the object with the id in the path
+// has been centered.
+func (s Server) calibrationCenter(w http.ResponseWriter, r *http.Request) error {
+	obj, err := repo.GetObject(r.PathValue("id"))
+	if err != nil {
 		return err
 	}
 
-	return s.mount.Move(strings.ReplaceAll(r.URL.Path, "/", ""), m.Hz)
+	ra, dec := obj.At(s.mount.Now())
+	if err := s.mount.CalibrationCenter(s.mount.WithRA(ra), dec); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(s.mount.Calibration())
+}
+
+// saveCalibration applies the calibration to the mount and writes the
+// new gear ratios to the config file.
+func (s Server) saveCalibration(w http.ResponseWriter, r *http.Request) error {
+	ra, dec, err := s.mount.ApplyCalibration()
+	if err != nil {
+		return err
+	}
+
+	return s.cfg.Update(func(cfg *config.Config) {
+		if ra != 0 {
+			cfg.Mount.RA.GearRatio = ra
+		}
+		if dec != 0 {
+			cfg.Mount.Dec.GearRatio = dec
+		}
+	})
+}
+
+func (s Server) backlash(w http.ResponseWriter, r *http.Request) error {
+	return json.NewEncoder(w).Encode(s.mount.Backlash())
+}
+
+func (s Server) measureBacklash(w http.ResponseWriter, r *http.Request) error {
+	return s.mount.MeasureBacklash(r.PathValue("axis"))
+}
+
+func (s Server) backlashMoved(w http.ResponseWriter, r *http.Request) error {
+	if _, err := s.mount.BacklashMoved(); err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(s.mount.Backlash())
+}
+
+// saveBacklash applies the measured backlash to its axis and writes it to
+// the config file.
+func (s Server) saveBacklash(w http.ResponseWriter, r *http.Request) error {
+	axis, steps, err := s.mount.ApplyBacklash()
+	if err != nil {
+		return err
+	}
+
+	return s.cfg.Update(func(cfg *config.Config) {
+		if axis == "ra" {
+			cfg.Mount.RA.Backlash = steps
+		} else {
+			cfg.Mount.Dec.Backlash = steps
+		}
+	})
 }
 
 func (s Server) doGetObjects(r *http.Request) (objs repo.Objects, err error) {
-	var opts []repo.QueryOption
+	opts, err := s.objectOptions(r)
+	if err != nil {
+		return objs, err
+	}
+
+	pg, err := page(r)
+	if err != nil {
+		return objs, err
+	}
+
+	return repo.GetObjects(pg, opts...)
+}
+
+// typeahead suggests up to n (10 by default) objects for what's been
+// typed so far, q, best match first.
+func (s Server) typeahead(w http.ResponseWriter, r *http.Request) error {
+	sugs := []suggestion{}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		return json.NewEncoder(w).Encode(sugs)
+	}
+
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			return badRequestf("bad n: %w", err)
+		}
+	}
+
+	objs, err := repo.GetObjects(repo.Page(0, n), repo.Name(q))
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objs.Objects {
+		label := o.ID
+		if o.M != nil && o.ID != fmt.Sprintf("M%d", *o.M) {
+			label = fmt.Sprintf("M%d %s", *o.M, label)
+		}
+
+		if o.Name != nil {
+			label += " " + *o.Name
+		}
+
+		sugs = append(sugs, suggestion{ID: o.ID, Label: label})
+	}
+
+	return json.NewEncoder(w).Encode(sugs)
+}
+
+// near lists the objects the query's filters pick within radius degrees
+// (1 by default) of ra (hours) and dec (degrees), or of where the mount
+// points if they're left out, nearest first.
+func (s Server) near(w http.ResponseWriter, r *http.Request) error {
+	ra, dec := s.mount.Pointing()
+	if r.URL.Query().Has("ra") || r.URL.Query().Has("dec") {
+		h, err := strconv.ParseFloat(r.URL.Query().Get("ra"), 64)
+		if err != nil {
+			return badRequestf("bad ra: %w", err)
+		}
+
+		d, err := strconv.ParseFloat(r.URL.Query().Get("dec"), 64)
+		if err != nil {
+			return badRequestf("bad dec: %w", err)
+		}
+
+		ra, dec = h*math.Pi/12, s.mount.Rad(d)
+	}
+
+	return s.nearby(w, r, ra, dec, "")
+}
+
+// nearObject is near around the object with the id in the path, which
+// is left out.
+func (s Server) nearObject(w http.ResponseWriter, r *http.Request) error {
+	obj, err := repo.GetObject(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	ra, dec := obj.At(s.mount.Now())
+	return s.nearby(w, r, ra, dec, obj.ID)
+}
+
+func (s Server) nearby(w http.ResponseWriter, r *http.Request, ra, dec float64, skip string) error {
+	radius := 1.0
+	if v := r.URL.Query().Get("radius"); v != "" {
+		var err error
+		if radius, err = strconv.ParseFloat(v, 64); err != nil {
+			return badRequestf("bad radius: %w", err)
+		}
+	}
+
+	opts, err := s.objectOptions(r)
+	if err != nil {
+		return err
+	}
+
+	if skip != "" {
+		opts = append(opts, repo.Not(skip))
+	}
+
+	pg, err := page(r)
+	if err != nil {
+		return err
+	}
+
+	objs, err := repo.Near(ra, dec, s.mount.Rad(radius), pg, opts...)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(objs)
+}
+
+// page is the page and pagesize (20 by default) in r's query, or nil
+// for every object.
+func page(r *http.Request) (repo.QueryOption, error) {
+	pageSize := 20
+	if s := r.URL.Query().Get("pagesize"); s != "" {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, badRequestf("bad pagesize: %w", err)
+		}
+		pageSize = i
+	}
+
+	s := r.URL.Query().Get("page")
+	if s == "" {
+		return nil, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, badRequestf("bad page: %w", err)
+	}
+
+	if i < 0 || pageSize < 0 {
+		return nil, badRequestf("page and pagesize can't be negative")
+	}
+
+	return repo.Page(i, pageSize), nil
+}
+
+// objectOptions are the filters and sort order in r's query.
+func (s Server) objectOptions(r *http.Request) (opts []repo.QueryOption, err error) {
 	if r.URL.Query().Get("messier") == "true" {
 		opts = append(opts, repo.Messier)
 	}
 
+	if p := r.URL.Query().Get("program"); p != "" {
+		opts = append(opts, repo.InProgram(p))
+	}
+
+	if l := r.URL.Query().Get("list"); l != "" {
+		id, err := strconv.ParseInt(l, 10, 64)
+		if err != nil {
+			return nil, badRequestf("bad list: %w", err)
+		}
+		opts = append(opts, repo.InList(id))
+	}
+
+	switch obs := r.URL.Query().Get("observed"); obs {
+	case "":
+	case "true", "false":
+		opts = append(opts, repo.Observed(obs == "true"))
+	default:
+		return nil, badRequestf("bad observed %q", obs)
+	}
+
 	if r.URL.Query().Get("named") == "true" {
 		opts = append(opts, repo.Named)
 	}
@@ -240,30 +807,111 @@ func (s Server) doGetObjects(r *http.Request) (objs repo.Objects, err error) {
 		opts = append(opts, repo.Types(types))
 	}
 
-	var pg repo.QueryOption
-	if s := r.URL.Query().Get("page"); s != "" {
-		pageSize := 20
-		if s := r.URL.Query().Get("pagesize"); s != "" {
-			i, err := strconv.Atoi(s)
-			if err != nil {
-				return objs, err
+	switch sort := r.URL.Query().Get("sort"); sort {
+	case "":
+	case "altitude":
+		opts = append(opts, repo.ByAltitude)
+	case "set":
+		opts = append(opts, repo.BySetting)
+	default:
+		return nil, badRequestf("can't sort objects by %q", sort)
+	}
+
+	var size [2]float64
+	for i, k := range []string{"minsize", "maxsize"} {
+		if v := r.URL.Query().Get(k); v != "" {
+			if size[i], err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, badRequestf("bad %s: %w", k, err)
 			}
-			pageSize = i
 		}
+	}
 
-		i, err := strconv.Atoi(s)
+	if size[0] > 0 || size[1] > 0 {
+		opts = append(opts, repo.Size(size[0], size[1]))
+	}
+
+	if v := r.URL.Query().Get("surfbr"); v != "" {
+		sb, err := strconv.ParseFloat(v, 64)
 		if err != nil {
-			return objs, err
+			return nil, badRequestf("bad surfbr: %w", err)
 		}
+		opts = append(opts, repo.SurfaceBrightness(sb))
+	}
 
-		pg = repo.Page(i, pageSize)
+	return opts, nil
+}
+
+// tonight recommends targets for the rest of the night from the objects
+// the query's filters pick (see objectOptions).  prefer=TYPE:WEIGHT
+// weighs the score of a type (WEIGHT defaults to 2); OpenNGC's single
+// stars (*) and unclassified objects (Other) weigh 0 unless they're
+// preferred.  min_alt is the lowest target in degrees, slot how long
+// each slot is in minutes and per_slot how many targets each gets.
+func (s Server) tonight(w http.ResponseWriter, r *http.Request) error {
+	opts, err := s.objectOptions(r)
+	if err != nil {
+		return err
 	}
 
-	return repo.GetObjects(pg, opts...)
+	p := recommend.Preferences{
+		Aperture:    s.cfg.Telescope.Aperture,
+		MinAltitude: 20,
+		Types:       map[string]float64{"*": 0, "Other": 0},
+		Slot:        time.Hour,
+		PerSlot:     10,
+	}
+
+	for _, v := range r.URL.Query()["prefer"] {
+		typ, weight, ok := strings.Cut(v, ":")
+		p.Types[typ] = 2
+		if ok {
+			if p.Types[typ], err = strconv.ParseFloat(weight, 64); err != nil {
+				return badRequestf("bad weight for %s: %w", typ, err)
+			}
+		}
+	}
+
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{{"aperture", &p.Aperture}, {"min_alt", &p.MinAltitude}} {
+		if v := r.URL.Query().Get(f.name); v != "" {
+			if *f.dst, err = strconv.ParseFloat(v, 64); err != nil {
+				return badRequestf("bad %s: %w", f.name, err)
+			}
+		}
+	}
+
+	if v := r.URL.Query().Get("slot"); v != "" {
+		m, err := strconv.Atoi(v)
+		if err != nil {
+			return badRequestf("bad slot: %w", err)
+		}
+		p.Slot = time.Duration(m) * time.Minute
+	}
+
+	if v := r.URL.Query().Get("per_slot"); v != "" {
+		if p.PerSlot, err = strconv.Atoi(v); err != nil {
+			return badRequestf("bad per_slot: %w", err)
+		}
+	}
+
+	if p.Slot <= 0 || p.PerSlot <= 0 {
+		return badRequestf("slot and per_slot must be more than 0")
+	}
+
+	lat, _ := s.mount.GetCoordinates()
+	site := sky.Site{Latitude: s.mount.Rad(lat), LST: s.mount.LocalSiderealTime}
+	plan, err := recommend.Tonight(site, s.mount.Now(), p, opts...)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(plan)
 }
 
 func (s Server) localSiderealTime() string {
-	gstHours := s.mount.LocalSiderealTime(time.Now())
+	gstHours := s.mount.LocalSiderealTime(s.mount.Now())
 	hours := int(gstHours)
 	remainder := (gstHours - float64(hours)) * 60.0
 	minutes := int(remainder)
@@ -304,7 +952,8 @@ func templates() (*template.Template, *template.Template, *template.Template, er
 		return nil, nil, nil, err
 	}
 
-	obj, err := template.New("object").Parse(string(s))
+	// deref is for formatting optional values: {{printf "%.1f" (deref .)}}
+	obj, err := template.New("object").Funcs(template.FuncMap{"deref": func(f *float64) float64 { return *f }}).Parse(string(s))
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cswank/geq/controller/internal/config"
+	"github.com/cswank/geq/controller/internal/mount"
+	"github.com/cswank/geq/controller/internal/repo"
+)
+
+// srv is started, once as the repo can only be set up once, with the
+// default config, which keeps the user's database in the working
+// directory.
+var srv *Server
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "geq")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	code := run(m, dir)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func run(m *testing.M, dir string) int {
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := config.Default()
+	mnt, err := mount.New(cfg.Mount, cfg.Site)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mnt.Close()
+
+	srv, err = New(mnt, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return m.Run()
+}
+
+func do(method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	srv.mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestNew(t *testing.T) {
+	if w := do(http.MethodGet, "/programs", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET /programs returned %d", w.Code)
+	}
+
+	if _, err := os.Stat(config.Default().Server.UserDB); err != nil {
+		t.Errorf("the user database isn't in the working directory: %s", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	testCases := []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodPost, "/observations", `{"object": "M31", "rating": -2}`, http.StatusBadRequest},
+		{http.MethodPost, "/observations", `{"object": "M31", "seeing": 6}`, http.StatusBadRequest},
+		{http.MethodPost, "/observations", `{"object": `, http.StatusBadRequest},
+		{http.MethodPost, "/observations", `{"object": "nothing like it"}`, http.StatusNotFound},
+		{http.MethodGet, "/observations?from=yesterday", "", http.StatusBadRequest},
+		{http.MethodGet, "/observations?format=fits", "", http.StatusBadRequest},
+		{http.MethodGet, "/observations/x", "", http.StatusBadRequest},
+		{http.MethodGet, "/observations/999", "", http.StatusNotFound},
+		{http.MethodPut, "/observations/999", `{"rating": 3}`, http.StatusNotFound},
+		{http.MethodDelete, "/observations/999", "", http.StatusNotFound},
+		{http.MethodGet, "/objects/nothing", "", http.StatusNotFound},
+		{http.MethodGet, "/objects?observed=maybe", "", http.StatusBadRequest},
+		{http.MethodGet, "/objects?pagesize=x", "", http.StatusBadRequest},
+		{http.MethodGet, "/objects?page=x", "", http.StatusBadRequest},
+		{http.MethodGet, "/objects?page=-1", "", http.StatusBadRequest},
+		{http.MethodGet, "/objects?page=0&pagesize=-5", "", http.StatusBadRequest},
+		{http.MethodGet, "/tonight?slot=0", "", http.StatusBadRequest},
+		{http.MethodGet, "/tonight?per_slot=0", "", http.StatusBadRequest},
+		{http.MethodPost, "/ra", `{"speed": "warp", "direction": 1}`, http.StatusBadRequest},
+		{http.MethodGet, "/lists/999", "", http.StatusNotFound},
+		{http.MethodPost, "/lists", `{"notes": "no name"}`, http.StatusBadRequest},
+		{http.MethodPost, "/home/xx", "", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		if w := do(tc.method, tc.target, tc.body); w.Code != tc.code {
+			t.Errorf("%s %s %s returned %d, want %d", tc.method, tc.target, tc.body, w.Code, tc.code)
+		}
+	}
+}
+
+// TestObserved checks that the programs' progress follows the logbook.
+func TestObserved(t *testing.T) {
+	messier := func() int {
+		var ps []repo.Program
+		if err := json.NewDecoder(do(http.MethodGet, "/programs", "").Body).Decode(&ps); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range ps {
+			if p.ID == "messier" {
+				return p.Observed
+			}
+		}
+		t.Fatal("there's no messier program")
+		return 0
+	}
+
+	w := do(http.MethodPost, "/objects/M31/observations", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("logging M31 returned %d", w.Code)
+	}
+
+	var o repo.Observation
+	if err := json.NewDecoder(w.Body).Decode(&o); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := messier(); n != 1 {
+		t.Errorf("%d messier objects observed after logging M31, want 1", n)
+	}
+
+	if w := do(http.MethodGet, "/objects?observed=true", ""); !strings.Contains(w.Body.String(), o.Object) {
+		t.Errorf("%s isn't in the observed objects: %s", o.Object, w.Body)
+	}
+
+	if w := do(http.MethodDelete, fmt.Sprintf("/observations/%d", o.ID), ""); w.Code != http.StatusOK {
+		t.Fatalf("deleting the observation returned %d", w.Code)
+	}
+
+	if n := messier(); n != 0 {
+		t.Errorf("%d messier objects observed after the observation was deleted, want 0", n)
+	}
+}
+
+// TestName checks that looking objects up by name puts the object the
+// name designates first, followed by the ones it's the start of.
+func TestName(t *testing.T) {
+	w := do(http.MethodGet, "/objects?name=M3&pagesize=50", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /objects?name=M3 returned %d", w.Code)
+	}
+
+	var objs repo.Objects
+	if err := json.NewDecoder(w.Body).Decode(&objs); err != nil {
+		t.Fatal(err)
+	}
+
+	var ms []int
+	for _, o := range objs.Objects {
+		if o.M != nil {
+			ms = append(ms, *o.M)
+		}
+	}
+
+	if len(ms) == 0 || ms[0] != 3 || !slices.Contains(ms, 31) || !slices.Contains(ms, 33) {
+		t.Errorf("M3 found messier objects %v, want M3 first then M31 and M33", ms)
+	}
+}
+
+// TestLists checks that the objects in a list can be reordered and
+// removed by any of their designations, as they're added.
+func TestLists(t *testing.T) {
+	w := do(http.MethodPost, "/lists", `{"name": "winter"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating a list returned %d", w.Code)
+	}
+
+	var l repo.List
+	if err := json.NewDecoder(w.Body).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/lists/%d", l.ID)
+	for _, obj := range []string{"M31", "M42"} {
+		if w := do(http.MethodPost, path+"/objects", fmt.Sprintf(`{"id": %q}`, obj)); w.Code != http.StatusOK {
+			t.Fatalf("adding %s returned %d", obj, w.Code)
+		}
+	}
+
+	if w := do(http.MethodPut, path+"/order", `{"ids": ["M42", "NGC 224"]}`); w.Code != http.StatusOK {
+		t.Fatalf("reordering the list returned %d: %s", w.Code, w.Body)
+	}
+
+	messier := func() []int {
+		if err := json.NewDecoder(do(http.MethodGet, path, "").Body).Decode(&l); err != nil {
+			t.Fatal(err)
+		}
+
+		var ms []int
+		for _, e := range l.Entries {
+			ms = append(ms, *e.Object.M)
+		}
+		return ms
+	}
+
+	if ms := messier(); !slices.Equal(ms, []int{42, 31}) {
+		t.Errorf("the reordered list has %v, want M42 then M31", ms)
+	}
+
+	testCases := []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodPut, path + "/order", `{"ids": ["M42", "M42"]}`, http.StatusBadRequest},
+		{http.MethodPut, path + "/order", `{"ids": ["M42", "nothing like it"]}`, http.StatusNotFound},
+		{http.MethodDelete, path + "/objects/nothing%20like%20it", "", http.StatusNotFound},
+		{http.MethodDelete, path + "/objects/M42", "", http.StatusOK},
+		{http.MethodDelete, path + "/objects/M42", "", http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		if w := do(tc.method, tc.target, tc.body); w.Code != tc.code {
+			t.Errorf("%s %s %s returned %d, want %d", tc.method, tc.target, tc.body, w.Code, tc.code)
+		}
+	}
+
+	if ms := messier(); !slices.Equal(ms, []int{31}) {
+		t.Errorf("after removing M42 the list has %v, want M31", ms)
+	}
+}
@@ -0,0 +1,258 @@
+// Package sky works out where things are in the sky from the site: the
+// altitude and azimuth of a position, when it rises, transits and sets,
+// where the sun and moon are and when it's night.  Angles are in radians and
+// sidereal times in hours, as they are in the mount.
+package sky
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// siderealRate is how many sidereal hours pass in an hour.
+	siderealRate = 1.00273790935
+
+	// Sunset is how far the sun's center is below the horizon when its
+	// top edge sets (refraction lifts it half a degree) and Dark how far
+	// it is when astronomical twilight ends.
+	Sunset = -0.833 * math.Pi / 180
+	Dark   = -18 * math.Pi / 180
+
+	// nightStep is how far apart the sun's altitude is checked when
+	// looking for when it crosses the start of night.
+	nightStep = 10 * time.Minute
+)
+
+type (
+	// Site is where the sky is seen from: its latitude and its local
+	// sidereal time (in hours) at t.
+	Site struct {
+		Latitude float64
+		LST      func(t time.Time) float64
+	}
+
+	// Pass is when a position rises above alt, transits and sets again.
+	// Rise and Set are nil if it's always above alt (Up) or never rises
+	// above it.
+	Pass struct {
+		Rise    *time.Time
+		Transit time.Time
+		Set     *time.Time
+		Up      bool
+	}
+)
+
+// HourAngle returns the hour angle of ra when the local sidereal time is
+// lst, between -π and π (negative before transit).
+func HourAngle(ra, lst float64) float64 {
+	ha := math.Mod(lst*math.Pi/12-ra, 2*math.Pi)
+	switch {
+	case ha > math.Pi:
+		ha -= 2 * math.Pi
+	case ha <= -math.Pi:
+		ha += 2 * math.Pi
+	}
+	return ha
+}
+
+// AltAz returns the altitude and azimuth (east of north) of a position
+// at hour angle ha and declination dec seen from latitude lat.
+func AltAz(ha, dec, lat float64) (alt, az float64) {
+	alt = math.Asin(math.Sin(lat)*math.Sin(dec) + math.Cos(lat)*math.Cos(dec)*math.Cos(ha))
+	az = math.Atan2(-math.Cos(dec)*math.Sin(ha), math.Sin(dec)*math.Cos(lat)-math.Cos(dec)*math.Sin(lat)*math.Cos(ha))
+	if az < 0 {
+		az += 2 * math.Pi
+	}
+	return alt, az
+}
+
+// Airmass is how many atmospheres thick the air is at altitude alt
+// (Kasten and Young's formula, which holds down to the horizon).
+func Airmass(alt float64) float64 {
+	deg := alt * 180 / math.Pi
+	return 1 / (math.Sin(alt) + 0.50572*math.Pow(deg+6.07995, -1.6364))
+}
+
+// Crossing returns the hour angle at which a position at dec sets below
+// alt seen from lat (it rises at -ha).  It's false if the position never
+// crosses alt: it's then always above it if its transit is.
+func Crossing(dec, lat, alt float64) (ha float64, ok bool) {
+	c := (math.Sin(alt) - math.Sin(lat)*math.Sin(dec)) / (math.Cos(lat) * math.Cos(dec))
+	if c < -1 || c > 1 {
+		return 0, false
+	}
+	return math.Acos(c), true
+}
+
+// SetsIn returns how many hours until a position at ra and dec, which is
+// above alt, sets below it when the local sidereal time is lst.  It's
+// false if it isn't above alt or never sets.
+func SetsIn(ra, dec, lst, lat, alt float64) (float64, bool) {
+	ha := HourAngle(ra, lst)
+	if a, _ := AltAz(ha, dec, lat); a <= alt {
+		return 0, false
+	}
+
+	set, ok := Crossing(dec, lat, alt)
+	if !ok {
+		return 0, false
+	}
+
+	return (set - ha) * 12 / math.Pi / siderealRate, true
+}
+
+// Altitude returns the altitude of ra and dec at t.
+func (s Site) Altitude(ra, dec float64, t time.Time) float64 {
+	alt, _ := AltAz(HourAngle(ra, s.LST(t)), dec, s.Latitude)
+	return alt
+}
+
+// Pass returns the pass of ra and dec above alt that's under way at t,
+// or the next one if it's below alt at t.
+func (s Site) Pass(ra, dec, alt float64, t time.Time) Pass {
+	ha := HourAngle(ra, s.LST(t))
+	if a, _ := AltAz(ha, dec, s.Latitude); a <= alt && ha > 0 {
+		ha -= 2 * math.Pi // the transit after next is the next pass's
+	}
+
+	p := Pass{Transit: t.Add(hours(-ha))}
+	set, ok := Crossing(dec, s.Latitude, alt)
+	if !ok {
+		top, _ := AltAz(0, dec, s.Latitude)
+		p.Up = top > alt
+		return p
+	}
+
+	rise, end := p.Transit.Add(hours(-set)), p.Transit.Add(hours(set))
+	p.Rise, p.Set = &rise, &end
+	return p
+}
+
+// MaxAltitude returns the highest ra and dec gets between start and end.
+func (s Site) MaxAltitude(ra, dec float64, start, end time.Time) float64 {
+	mid := start.Add(end.Sub(start) / 2)
+	transit := mid.Add(hours(-HourAngle(ra, s.LST(mid))))
+	if !transit.Before(start) && !transit.After(end) {
+		top, _ := AltAz(0, dec, s.Latitude)
+		return top
+	}
+
+	return math.Max(s.Altitude(ra, dec, start), s.Altitude(ra, dec, end))
+}
+
+// Night returns when the night t is in started and will end, or when the
+// next one starts and ends if it's day at t: when the sun goes below alt
+// (Sunset or Dark) and comes back up.  It's false if the sun doesn't
+// cross alt within a day (there's no night, or no day).
+func (s Site) Night(t time.Time, alt float64) (start, end time.Time, ok bool) {
+	dark := func(t time.Time) bool {
+		ra, dec := Sun(t)
+		return s.Altitude(ra, dec, t) < alt
+	}
+
+	if dark(t) {
+		if start, ok = change(t, -nightStep, dark); !ok {
+			return start, end, false
+		}
+		end, ok = change(t, nightStep, dark)
+		return start, end, ok
+	}
+
+	if start, ok = change(t, nightStep, dark); !ok {
+		return start, end, false
+	}
+	end, ok = change(start, nightStep, dark)
+	return start, end, ok
+}
+
+// change steps from t (backwards if step is negative) for up to a day
+// until f changes, and returns the first time (to the second) it has
+// changed.
+func change(t time.Time, step time.Duration, f func(time.Time) bool) (time.Time, bool) {
+	was := f(t)
+	for range int(24 * time.Hour / step.Abs()) {
+		next := t.Add(step)
+		if f(next) == was {
+			t = next
+			continue
+		}
+
+		for next.Sub(t).Abs() > time.Second {
+			mid := t.Add(next.Sub(t) / 2)
+			if f(mid) == was {
+				t = mid
+			} else {
+				next = mid
+			}
+		}
+		return next, true
+	}
+
+	return t, false
+}
+
+// Sun returns the sun's ra and dec at t, good to a hundredth of a degree
+// (the Astronomical Almanac's low precision formulas).
+func Sun(t time.Time) (ra, dec float64) {
+	n := julianDate(t) - 2451545.0
+	rad := math.Pi / 180
+	l := (280.460 + 0.9856474*n) * rad
+	g := (357.528 + 0.9856003*n) * rad
+	lambda := l + (1.915*math.Sin(g)+0.020*math.Sin(2*g))*rad
+	e := (23.439 - 0.0000004*n) * rad
+
+	ra = math.Atan2(math.Cos(e)*math.Sin(lambda), math.Cos(lambda))
+	if ra < 0 {
+		ra += 2 * math.Pi
+	}
+	return ra, math.Asin(math.Sin(e) * math.Sin(lambda))
+}
+
+// Moon returns the moon's ra and dec at t, seen from the center of the
+// earth and good to a few tenths of a degree (the Astronomical Almanac's
+// low precision formulas).  Its parallax can put it up to a degree away
+// from that for the site.
+func Moon(t time.Time) (ra, dec float64) {
+	c := (julianDate(t) - 2451545.0) / 36525
+	rad := math.Pi / 180
+	sin := func(a, b float64) float64 { return math.Sin((a + b*c) * rad) }
+
+	lambda := (218.32 + 481267.881*c +
+		6.29*sin(135.0, 477198.87) - 1.27*sin(259.3, -413335.36) +
+		0.66*sin(235.7, 890534.22) + 0.21*sin(269.9, 954397.74) -
+		0.19*sin(357.5, 35999.05) - 0.11*sin(186.5, 966404.03)) * rad
+	beta := (5.13*sin(93.3, 483202.02) + 0.28*sin(228.2, 960400.89) -
+		0.28*sin(318.3, 6003.15) - 0.17*sin(217.6, -407332.21)) * rad
+	e := 23.439 * rad
+
+	ra = math.Atan2(math.Sin(lambda)*math.Cos(e)-math.Tan(beta)*math.Sin(e), math.Cos(lambda))
+	if ra < 0 {
+		ra += 2 * math.Pi
+	}
+	return ra, math.Asin(math.Sin(beta)*math.Cos(e) + math.Cos(beta)*math.Sin(e)*math.Sin(lambda))
+}
+
+// Illumination returns the fraction of the moon's disk that's lit at t,
+// 0 at new moon and 1 at full.
+func Illumination(t time.Time) float64 {
+	sra, sdec := Sun(t)
+	mra, mdec := Moon(t)
+	return (1 - math.Cos(Separation(sra, sdec, mra, mdec))) / 2
+}
+
+// Separation returns the angle between two positions.
+func Separation(ra1, dec1, ra2, dec2 float64) float64 {
+	// the haversine formula, which holds up for small angles
+	h := math.Pow(math.Sin((dec2-dec1)/2), 2) + math.Cos(dec1)*math.Cos(dec2)*math.Pow(math.Sin((ra2-ra1)/2), 2)
+	return 2 * math.Asin(math.Sqrt(math.Min(h, 1)))
+}
+
+func julianDate(t time.Time) float64 {
+	return float64(t.UnixMilli())/86400000 + 2440587.5
+}
+
+// hours returns how long it takes the hour angle to move by ha.
+func hours(ha float64) time.Duration {
+	return time.Duration(ha * 12 / math.Pi / siderealRate * float64(time.Hour))
+}
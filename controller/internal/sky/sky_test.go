@@ -0,0 +1,263 @@
+package sky
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+const rad = math.Pi / 180
+
+// site returns the site at latitude and longitude (degrees, east
+// positive) keeping mean sidereal time (Meeus, Astronomical Algorithms,
+// 12.4).
+func site(lat, lon float64) Site {
+	return Site{
+		Latitude: lat * rad,
+		LST: func(t time.Time) float64 {
+			gmst := 280.46061837 + 360.98564736629*(julianDate(t)-2451545)
+			return math.Mod(math.Mod(gmst+lon, 360)+360, 360) / 15
+		},
+	}
+}
+
+func TestAltAz(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ha, dec, lat    float64
+		wantAlt, wantAz float64
+	}{
+		// Venus from the US Naval Observatory, 1987 April 10 19:21 UT
+		// (Meeus example 13.b, whose azimuth is from the south)
+		{name: "meeus 13.b", ha: 64.352133, dec: -6.719892, lat: 38.921389, wantAlt: 15.1249, wantAz: 68.0337 + 180},
+		{name: "transit south of the zenith", ha: 0, dec: 10, lat: 40, wantAlt: 60, wantAz: 180},
+		{name: "transit north of the zenith", ha: 0, dec: 70, lat: 40, wantAlt: 60, wantAz: 0},
+		{name: "rising due east", ha: -90, dec: 0, lat: 40, wantAlt: 0, wantAz: 90},
+		{name: "pole", ha: 123, dec: 90, lat: 40, wantAlt: 40, wantAz: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			alt, az := AltAz(tc.ha*rad, tc.dec*rad, tc.lat*rad)
+			if math.Abs(alt/rad-tc.wantAlt) > 0.0001 || math.Abs(math.Remainder(az/rad-tc.wantAz, 360)) > 0.0001 {
+				t.Errorf("got altitude %.4f and azimuth %.4f, want %.4f and %.4f", alt/rad, az/rad, tc.wantAlt, tc.wantAz)
+			}
+		})
+	}
+}
+
+func TestHourAngle(t *testing.T) {
+	testCases := []struct {
+		ra, lst, want float64
+	}{
+		{ra: 90, lst: 8, want: 30},
+		{ra: 90, lst: 4, want: -30},
+		{ra: 350, lst: 1, want: 25},
+		{ra: 10, lst: 23, want: -25},
+		{ra: 0, lst: 12, want: 180},
+	}
+
+	for _, tc := range testCases {
+		if got := HourAngle(tc.ra*rad, tc.lst) / rad; math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("the hour angle of ra %g at lst %g is %g, want %g", tc.ra, tc.lst, got, tc.want)
+		}
+	}
+}
+
+// TestAirmass checks that the airmass is sec z well above the horizon,
+// and near Kasten and Young's (1989) 38.09 at it, which their formula
+// is within half a percent of.
+func TestAirmass(t *testing.T) {
+	testCases := []struct {
+		alt, want, tolerance float64
+	}{
+		{alt: 90, want: 1, tolerance: 0.001},
+		{alt: 60, want: 2 / math.Sqrt(3), tolerance: 0.001},
+		{alt: 30, want: 2, tolerance: 0.005},
+		{alt: 0, want: 38.0868, tolerance: 0.005},
+	}
+
+	for _, tc := range testCases {
+		if got := Airmass(tc.alt * rad); math.Abs(got-tc.want) > tc.tolerance*tc.want {
+			t.Errorf("the airmass at %g° is %.4f, want %.4f", tc.alt, got, tc.want)
+		}
+	}
+}
+
+// TestPass checks the rising, transit and setting of Venus at Boston on
+// 1988 March 20 (Meeus example 15.a).  Venus moves, so its position is
+// interpolated to the time of each event, as Meeus does.
+func TestPass(t *testing.T) {
+	s := site(42.3333, -71.0833)
+	day := time.Date(1988, 3, 20, 0, 0, 0, 0, time.UTC)
+	h0 := -0.5667 * rad
+
+	// Meeus's positions at 0h dynamical time on the 19th, 20th and 21st,
+	// interpolated (3.3) to the fraction m of the 20th
+	venus := func(m float64) (ra, dec float64) {
+		n := m + 56.0/86400
+		y := func(y1, y2, y3 float64) float64 {
+			a, b := y2-y1, y3-y2
+			return (y2 + n/2*(a+b+n*(b-a))) * rad
+		}
+		return y(40.68021, 41.73129, 42.78204), y(18.04761, 18.44092, 18.82742)
+	}
+
+	testCases := []struct {
+		name string
+		// m is when the event is, as a fraction of the day, and from
+		// when the pass it's in is looked for
+		m    float64
+		from time.Duration
+		get  func(Pass) *time.Time
+	}{
+		{name: "rise", m: 0.51766, from: 12 * time.Hour, get: func(p Pass) *time.Time { return p.Rise }},
+		{name: "transit", m: 0.81980, from: 12 * time.Hour, get: func(p Pass) *time.Time { return &p.Transit }},
+		// at 0h Venus is still up from the day before
+		{name: "set", m: 0.12130, from: 0, get: func(p Pass) *time.Time { return p.Set }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ra, dec := venus(tc.m)
+			p := s.Pass(ra, dec, h0, day.Add(tc.from))
+			got := tc.get(p)
+			if got == nil {
+				t.Fatalf("venus has no %s: %+v", tc.name, p)
+			}
+
+			want := day.Add(time.Duration(tc.m * float64(24*time.Hour)))
+			if d := got.Sub(want); d.Abs() > 30*time.Second {
+				t.Errorf("venus %s at %s, want %s", tc.name, got.Format(time.TimeOnly), want.Format(time.TimeOnly))
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name string
+		dec  float64
+		up   bool
+	}{
+		{name: "circumpolar", dec: 60, up: true},
+		{name: "never rises", dec: -60, up: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, ok := Crossing(tc.dec*rad, s.Latitude, h0); ok {
+				t.Errorf("dec %g crosses the horizon at latitude 42", tc.dec)
+			}
+
+			if _, ok := SetsIn(0, tc.dec*rad, 0, s.Latitude, h0); ok {
+				t.Errorf("dec %g sets at latitude 42", tc.dec)
+			}
+
+			p := s.Pass(0, tc.dec*rad, h0, day)
+			if p.Rise != nil || p.Set != nil || p.Up != tc.up {
+				t.Errorf("dec %g has pass %+v, want it up: %t", tc.dec, p, tc.up)
+			}
+		})
+	}
+}
+
+// TestSetsIn checks that a star on the equator, seen from the equator,
+// sets six sidereal hours after it transits.
+func TestSetsIn(t *testing.T) {
+	h, ok := SetsIn(0, 0, 0, 0, 0)
+	if want := 6 / siderealRate; !ok || math.Abs(h-want) > 1e-9 {
+		t.Errorf("a star at transit sets in %g hours (%t), want %g", h, ok, want)
+	}
+
+	if _, ok := SetsIn(0, 0, 12, 0, 0); ok {
+		t.Error("a star below the horizon sets")
+	}
+}
+
+// TestSun checks against Meeus example 25.a (1992 October 13 0h), good
+// to the hundredth of a degree the formulas are.
+func TestSun(t *testing.T) {
+	ra, dec := Sun(time.Date(1992, 10, 13, 0, 0, 0, 0, time.UTC))
+	if math.Abs(ra/rad-198.38083) > 0.01 || math.Abs(dec/rad-(-7.78361)) > 0.01 {
+		t.Errorf("the sun is at ra %.5f and dec %.5f, want 198.38083 and -7.78361", ra/rad, dec/rad)
+	}
+}
+
+// TestMoon checks against Meeus example 47.a (1992 April 12 0h), good
+// to the few tenths of a degree the formulas are.
+func TestMoon(t *testing.T) {
+	ra, dec := Moon(time.Date(1992, 4, 12, 0, 0, 0, 0, time.UTC))
+	if math.Abs(ra/rad-134.688470) > 0.3 || math.Abs(dec/rad-13.768368) > 0.3 {
+		t.Errorf("the moon is at ra %.5f and dec %.5f, want 134.68847 and 13.76837", ra/rad, dec/rad)
+	}
+}
+
+func TestIllumination(t *testing.T) {
+	testCases := []struct {
+		name string
+		t    time.Time
+		want float64
+	}{
+		// the total eclipse of the sun
+		{name: "new", t: time.Date(2024, 4, 8, 18, 21, 0, 0, time.UTC), want: 0},
+		{name: "full", t: time.Date(2024, 4, 23, 23, 49, 0, 0, time.UTC), want: 1},
+	}
+
+	for _, tc := range testCases {
+		if got := Illumination(tc.t); math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("the %s moon is %.3f lit, want %g", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNight(t *testing.T) {
+	london := site(51.5074, -0.1278)
+	tromso := site(69.6492, 18.9553)
+
+	testCases := []struct {
+		name       string
+		s          Site
+		t          time.Time
+		alt        float64
+		start, end time.Time
+		ok         bool
+	}{
+		// the sun sets at 21:21 and rises at 4:43 BST on the solstice
+		{
+			name:  "day",
+			s:     london,
+			t:     time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC),
+			alt:   Sunset,
+			start: time.Date(2024, 6, 20, 20, 21, 0, 0, time.UTC),
+			end:   time.Date(2024, 6, 21, 3, 43, 0, 0, time.UTC),
+			ok:    true,
+		},
+		{
+			name:  "night",
+			s:     london,
+			t:     time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC),
+			alt:   Sunset,
+			start: time.Date(2024, 6, 20, 20, 21, 0, 0, time.UTC),
+			end:   time.Date(2024, 6, 21, 3, 43, 0, 0, time.UTC),
+			ok:    true,
+		},
+		// astronomical twilight lasts all night in a london summer
+		{name: "never dark", s: london, t: time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC), alt: Dark},
+		// and the sun doesn't rise in a tromsø winter
+		{name: "polar night", s: tromso, t: time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), alt: Sunset},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, ok := tc.s.Night(tc.t, tc.alt)
+			if ok != tc.ok {
+				t.Fatalf("got a night from %s to %s (%t), want %t", start, end, ok, tc.ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if start.Sub(tc.start).Abs() > 2*time.Minute || end.Sub(tc.end).Abs() > 2*time.Minute {
+				t.Errorf("got a night from %s to %s, want %s to %s", start, end, tc.start, tc.end)
+			}
+		})
+	}
+}
@@ -2,40 +2,131 @@ package main
 
 import (
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/cswank/geq/controller/internal/config"
+	"github.com/cswank/geq/controller/internal/gamepad"
+	"github.com/cswank/geq/controller/internal/handset"
 	"github.com/cswank/geq/controller/internal/mount"
 	"github.com/cswank/geq/controller/internal/server"
 )
 
+// flags set on the command line override the config file
 var (
-	serial = kingpin.Flag("serial", "serial device").String()
-	lat    = kingpin.Flag("latitude", "latitude").Float64()
-	lon    = kingpin.Flag("longitude", "longitude").Float64()
-	dev    = kingpin.Flag("dev", "develpment mode (no mount)").Short('d').Bool()
+	cfgPath = kingpin.Flag("config", "config file (toml)").Short('c').String()
+
+	serialSet, latSet, lonSet, raHomeSet, decHomeSet, collisionsSet, addrSet bool
+
+	serial     = kingpin.Flag("serial", "serial device").IsSetByUser(&serialSet).String()
+	lat        = kingpin.Flag("latitude", "latitude").IsSetByUser(&latSet).Float64()
+	lon        = kingpin.Flag("longitude", "longitude").IsSetByUser(&lonSet).Float64()
+	dev        = kingpin.Flag("dev", "develpment mode (no mount)").Short('d').Bool()
+	raHome     = kingpin.Flag("ra-home-pin", "gpio line that goes low when ra reaches its stop (home switch or DIAG)").IsSetByUser(&raHomeSet).Int()
+	decHome    = kingpin.Flag("dec-home-pin", "gpio line that goes low when dec reaches its stop (home switch or DIAG)").IsSetByUser(&decHomeSet).Int()
+	collisions = kingpin.Flag("collisions", "stop both axes when StallGuard sees a load spike during a slew").IsSetByUser(&collisionsSet).Bool()
+	addr       = kingpin.Flag("addr", "address the http server listens on").IsSetByUser(&addrSet).String()
 )
 
 func main() {
 	kingpin.Parse()
 
-	var err error
-	var ser string
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if !*dev {
-		ser = *serial
+	override(&cfg)
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%s", err)
 	}
 
-	m, err := mount.New(ser, *lat, *lon, 23, 24)
+	m, err := mount.New(cfg.Mount, cfg.Site)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	s, err := server.New(m)
+	s, err := server.New(m, cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := s.Start(); err != nil {
+	if cfg.Gamepad.Device != "" {
+		pad, err := gamepad.New(cfg.Gamepad, m, s.Next)
+		if err != nil {
+			log.Fatalf("invalid gamepad config:\n%s", err)
+		}
+		go pad.Watch()
+	}
+
+	var h *handset.Handset
+	if len(cfg.Handset.Pins) > 0 {
+		h, err = handset.New(cfg.Handset, cfg.Mount.GPIOChip, m)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Start()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-errs:
+	case <-sig:
+		log.Println("shutting down")
+	}
+
+	// the handset stops what its buttons were moving, so it goes first
+	if h != nil {
+		if err := h.Close(); err != nil {
+			log.Printf("unable to close the handset: %s", err)
+		}
+	}
+	m.Close()
+
+	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+func override(cfg *config.Config) {
+	if serialSet {
+		cfg.Mount.Serial = *serial
+	}
+
+	if *dev {
+		cfg.Mount.Serial = ""
+		cfg.Handset.Pins = nil
+	}
+
+	if latSet {
+		cfg.Site.Latitude = *lat
+	}
+
+	if lonSet {
+		cfg.Site.Longitude = *lon
+	}
+
+	if raHomeSet {
+		cfg.Mount.RA.HomePin = *raHome
+	}
+
+	if decHomeSet {
+		cfg.Mount.Dec.HomePin = *decHome
+	}
+
+	if collisionsSet {
+		cfg.Mount.Collisions = *collisions
+	}
+
+	if addrSet {
+		cfg.Server.Addr = *addr
+	}
+}
@@ -24,108 +24,316 @@ pub const microzig_options = microzig.Options{
     .logFn = rp2xxx.uart.log,
 };
 
-var ra_steps: u16 = 0;
-const msg_size = @sizeOf(message);
-
 var core1_stack: [1024]u32 = undefined;
-var buf: [256]u8 = .{0} ** 256;
+
+// the protocol spoken with the controller (see
+// controller/internal/firmware).  Every frame is
+//   sync | address | kind | seq | length | payload... | crc
+// and every command is answered with an ack, a nak or a reply.
+const protocol_version: u8 = 1;
+const firmware_version = [3]u8{ 0, 2, 0 };
+const sync: u8 = 0x5;
 const address: u8 = 0x11;
+const response_address: u8 = 0x12;
+const max_payload = 16;
+const header_size = 5;
+
+const Kind = enum(u8) {
+    hello = 0x01,
+    count = 0x02,
+    query = 0x03,
+    abort = 0x04,
+    ack = 0x80,
+    nak = 0x81,
+    hello_reply = 0x82,
+    query_reply = 0x83,
+    report = 0x84,
+    _,
+};
+
+const Nak = enum(u8) {
+    bad_crc = 1,
+    busy = 2,
+    unknown_command = 3,
+    bad_length = 4,
+};
+
+const Frame = struct {
+    kind: Kind,
+    seq: u8,
+    len: u8,
+    payload: [max_payload]u8,
+};
+
+const slow_steps: u16 = 100;
+
+// how many 100us polls to keep counting index pulses after telling the
+// controller to stop, so that any overrun shows up in the report
+const settle_polls: u32 = 2000;
+
+// poll_timeout is how long core0 waits for a command before checking
+// whether there's a report to send; byte_timeout is how long it waits
+// for the rest of a frame.
+const poll_timeout = time.Duration.from_ms(10);
+const byte_timeout = time.Duration.from_ms(20);
+
+// Axis is one of the counters.  Core1 counts; core0 reads counted to
+// answer queries and sets target before starting a count.
+const Axis = struct {
+    output: gpio.Pin,
+    index: gpio.Pin,
+    target: u16 = 0,
+    counted: std.atomic.Value(u16) = .init(0),
+    state: u1 = 0,
+    slowed: bool = false,
+    done: bool = false,
+
+    fn start(a: *Axis) void {
+        a.counted.store(0, .release);
+        a.state = 0;
+        a.slowed = a.target <= slow_steps;
+        a.output.toggle(); //tell controller to start motor
+        a.done = a.target == 0;
+        if (a.done) {
+            a.output.toggle(); //tell controller to stop
+        }
+    }
+
+    fn poll(a: *Axis) void {
+        if (a.index.read() == a.state) {
+            return;
+        }
+
+        a.state = 1 - a.state;
+        if (a.state == 0) {
+            return;
+        }
 
-var timeout = time.Duration.from_ms(100);
+        const i = a.counted.load(.monotonic) +| 1;
+        a.counted.store(i, .release);
+        if (a.done) {
+            return;
+        }
+
+        if (!a.slowed and a.target - i == slow_steps) {
+            a.slowed = true;
+            a.output.toggle(); //tell controller to slow down
+        }
 
-pub const message = packed struct {
-    sync: u8 = 0,
-    address: u8 = 0,
-    right_ascension_steps: u16 = 0,
-    declination_steps: u16 = 0,
-    crc: u8 = 0,
+        if (i >= a.target) {
+            a.done = true;
+            a.output.toggle(); //tell controller to stop
+        }
+    }
+
+    fn remaining(a: *Axis) u16 {
+        return a.target -| a.counted.load(.acquire);
+    }
 };
 
+var ra = Axis{ .output = ra_output, .index = ra_index };
+var dec = Axis{ .output = dec_output, .index = dec_index };
+
+var busy = std.atomic.Value(bool).init(false);
+var aborting = std.atomic.Value(bool).init(false);
+var aborted = std.atomic.Value(bool).init(false);
+var report_ready = std.atomic.Value(bool).init(false);
+
+// last_count is the seq of the count most recently started, so that a
+// count the controller resends because our ack got lost isn't started
+// twice.
+var last_count: ?u8 = null;
+
 pub fn main() !void {
     init();
 
     while (true) {
-        const msg = recv() catch |err| {
-            std.log.debug("recv error: {}", .{err});
+        if (report_ready.swap(false, .acq_rel)) {
+            report();
+        }
+
+        const f = recv() catch |err| {
+            if (err != error.Timeout and err != error.NoFrame) {
+                std.log.debug("recv error: {}", .{err});
+            }
             continue;
         };
 
-        if (msg.address != address) {
-            continue;
-        }
+        handle(f);
+    }
+}
 
-        std.log.debug("address: {d}, steps: {d}", .{ msg.address, msg.right_ascension_steps });
+fn handle(f: Frame) void {
+    if (f.kind != .count) {
+        last_count = null;
+    }
 
-        ra_steps = msg.right_ascension_steps;
-        mc.fifo.write_blocking(1);
-        count(msg.declination_steps, dec_output, dec_index);
-        _ = mc.fifo.read_blocking();
+    switch (f.kind) {
+        .hello => {
+            send(.hello_reply, f.seq, &.{ protocol_version, firmware_version[0], firmware_version[1], firmware_version[2] });
+        },
+        .count => {
+            if (last_count == f.seq) {
+                send(.ack, f.seq, &.{});
+                return;
+            }
+
+            if (f.len != 4) {
+                nak(f.seq, .bad_length);
+                return;
+            }
+
+            if (busy.load(.acquire)) {
+                nak(f.seq, .busy);
+                return;
+            }
+
+            ra.target = std.mem.readInt(u16, f.payload[0..2], .little);
+            dec.target = std.mem.readInt(u16, f.payload[2..4], .little);
+            std.log.debug("count ra: {d}, dec: {d}", .{ ra.target, dec.target });
+
+            last_count = f.seq;
+            aborting.store(false, .release);
+            busy.store(true, .release);
+            mc.fifo.write_blocking(1);
+            send(.ack, f.seq, &.{});
+        },
+        .query => {
+            const counting = busy.load(.acquire);
+            var out: [9]u8 = undefined;
+            out[0] = @intFromBool(counting);
+            std.mem.writeInt(u16, out[1..3], if (counting) ra.remaining() else 0, .little);
+            std.mem.writeInt(u16, out[3..5], if (counting) dec.remaining() else 0, .little);
+            std.mem.writeInt(u16, out[5..7], ra.counted.load(.acquire), .little);
+            std.mem.writeInt(u16, out[7..9], dec.counted.load(.acquire), .little);
+            send(.query_reply, f.seq, &out);
+        },
+        .abort => {
+            if (busy.load(.acquire)) {
+                aborting.store(true, .release);
+            }
+            send(.ack, f.seq, &.{});
+        },
+        else => nak(f.seq, .unknown_command),
     }
 }
 
-fn recv() !message {
-    try read();
+// report tells the controller how many index pulses were actually counted
+// on each axis during the last slew
+fn report() void {
+    var out: [5]u8 = undefined;
+    std.mem.writeInt(u16, out[0..2], ra.counted.load(.acquire), .little);
+    std.mem.writeInt(u16, out[2..4], dec.counted.load(.acquire), .little);
+    out[4] = @intFromBool(aborted.load(.acquire));
+    send(.report, 0, &out);
+}
+
+fn nak(seq: u8, code: Nak) void {
+    send(.nak, seq, &.{@intFromEnum(code)});
+}
 
-    for (0.., buf) |x, element| {
-        if (x + msg_size <= buf.len and element == 0x5 and buf[x + 1] == address) {
-            const raw = buf[x..][0..msg_size];
-            var xor: u8 = 0;
-            for (raw[0 .. msg_size - 1]) |b| xor ^= b;
-            if (xor != raw[msg_size - 1]) return error.BadCrc;
-            return std.mem.bytesToValue(message, raw);
-        }
+fn send(kind: Kind, seq: u8, payload: []const u8) void {
+    var out: [header_size + max_payload + 1]u8 = undefined;
+    out[0..header_size].* = .{ sync, response_address, @intFromEnum(kind), seq, @intCast(payload.len) };
+    @memcpy(out[header_size..][0..payload.len], payload);
+    const n = header_size + payload.len;
+    out[n] = crc8(out[0..n]);
+    uart1.write_blocking(out[0 .. n + 1], null) catch |err| {
+        std.log.debug("send error: {}", .{err});
+    };
+}
+
+// recv reads the next frame addressed to us.  Frames to the tmc2209s go
+// over the same line and are skipped.
+fn recv() !Frame {
+    var b: [1]u8 = undefined;
+    try read(&b, poll_timeout);
+    if (b[0] != sync) return error.NoFrame;
+
+    var header: [header_size]u8 = undefined;
+    header[0] = sync;
+    try read(header[1..], byte_timeout);
+    if (header[1] != address) return error.NoFrame;
+
+    var f = Frame{ .kind = @enumFromInt(header[2]), .seq = header[3], .len = header[4], .payload = undefined };
+    if (f.len > max_payload) {
+        nak(f.seq, .bad_length);
+        return error.BadLength;
+    }
+
+    try read(f.payload[0..f.len], byte_timeout);
+    try read(&b, byte_timeout);
+
+    var crc = crc8_update(0, &header);
+    crc = crc8_update(crc, f.payload[0..f.len]);
+    if (crc != b[0]) {
+        nak(f.seq, .bad_crc);
+        return error.BadCrc;
     }
 
-    return error.NoMessage;
+    return f;
 }
 
-fn read() !void {
-    buf = .{0} ** 256;
+fn read(buf: []u8, timeout: time.Duration) !void {
+    if (buf.len == 0) return;
+    _ = uart2.read_blocking(buf, timeout) catch |err| {
+        uart2.clear_errors();
+        return err;
+    };
+}
 
-    var to: ?time.Duration = null;
+// crc8 is the CRC from the tmc2209 datasheet, so the controller only
+// needs the one.
+fn crc8(buf: []const u8) u8 {
+    return crc8_update(0, buf);
+}
 
-    var idx: usize = 0;
-    while (idx < 256) {
-        _ = uart2.read_blocking(buf[idx .. idx + 8], to) catch |err| {
-            uart2.clear_errors();
-            if (err != error.Timeout) {
-                return err;
+fn crc8_update(start: u8, buf: []const u8) u8 {
+    var crc = start;
+    for (buf) |byte| {
+        var b = byte;
+        for (0..8) |_| {
+            if ((crc >> 7) ^ (b & 0x01) != 0) {
+                crc = (crc << 1) ^ 0x07;
+            } else {
+                crc = crc << 1;
             }
-            return;
-        };
-        to = timeout;
-        idx += 8;
+            b >>= 1;
+        }
     }
+    return crc;
 }
 
-fn ra_counter() void {
+// counter runs on core1 and counts both axes at once so that core0 is
+// free to answer the controller.
+fn counter() void {
     while (true) {
         _ = mc.fifo.read_blocking();
-        count(ra_steps, ra_output, ra_index);
-        mc.fifo.write_blocking(1);
-    }
-}
 
-fn count(target: u16, output: gpio.Pin, index: gpio.Pin) void {
-    var i: u32 = 0;
-    var state: u1 = 0;
-
-    output.toggle(); //tell controller to start motor
-
-    while (i < target) {
-        ptime.sleep_us(100);
-        if (index.read() != state) {
-            state = 1 - state;
-            if (state == 1) {
-                i += 1;
-                if (target > 100 and target - i == 100) {
-                    output.toggle(); //tell controller to slow down
-                }
-            }
+        ra.start();
+        dec.start();
+        while (!(ra.done and dec.done) and !aborting.load(.acquire)) {
+            ptime.sleep_us(100);
+            ra.poll();
+            dec.poll();
+        }
+
+        // an abort comes from the controller, which has already stopped
+        // the motors, so the outputs are left alone
+        aborted.store(aborting.load(.acquire), .release);
+        ra.done = true;
+        dec.done = true;
+
+        var polls: u32 = 0;
+        while (polls < settle_polls) : (polls += 1) {
+            ptime.sleep_us(100);
+            ra.poll();
+            dec.poll();
         }
-    }
 
-    output.toggle(); //tell controller to stop
+        busy.store(false, .release);
+        report_ready.store(true, .release);
+    }
 }
 
 fn init() void {
@@ -156,7 +364,7 @@ fn init() void {
 
     rp2xxx.uart.init_logger(uart1);
 
-    mc.launch_core1_with_stack(ra_counter, &core1_stack);
+    mc.launch_core1_with_stack(counter, &core1_stack);
 }
 
 pub fn panic(txt: []const u8, _: ?*std.builtin.StackTrace, _: ?usize) noreturn {